@@ -0,0 +1,5 @@
+module anagram
+
+go 1.24.2
+
+require golang.org/x/text v0.29.0
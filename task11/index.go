@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 — строка словаря не в UTF-8
+var ErrInvalidUTF8 = errors.New("строка не в UTF-8")
+
+// maxWordLen — предел длины слова в байтах: строка словаря длиннее —
+// скорее всего не словарь, а случайно поданный двоичный файл
+const maxWordLen = 1 << 10
+
+// Index — анаграммный индекс: подпись ключа слова (руны ключа по
+// возрастанию, см. Normalizer) → слова словаря с этой подписью в
+// исходном написании, без повторов ключа и по возрастанию ключа.
+// Искать можно из нескольких горутин сразу, но не во время Add
+type Index struct {
+	groups map[string][]string
+	// keys — ключи слов групп в том же порядке: поиск по группе
+	// не нормализует её слова заново
+	keys  map[string][]string
+	words int
+	norm  Normalizer
+	key   func(string) string
+
+	countMu sync.Mutex   // защищает byCount: его строит первый поиск
+	byCount []countEntry // см. counted
+}
+
+// NewIndex создаёт пустой индекс с правилами нормализации n
+func NewIndex(n Normalizer) *Index {
+	return &Index{
+		groups: make(map[string][]string),
+		keys:   make(map[string][]string),
+		norm:   n,
+		key:    n.keyFunc(),
+	}
+}
+
+// signature — ключ группы анаграмм: руны слова по возрастанию
+func signature(word string) string {
+	runes := []rune(word)
+	slices.Sort(runes)
+	return string(runes)
+}
+
+// Add добавляет слово в исходном написании. Возвращает false, если слово
+// с тем же ключом уже было в индексе (остаётся первое написание) или
+// ключ пуст
+func (ix *Index) Add(word string) bool {
+	key := ix.key(word)
+	if key == "" {
+		return false
+	}
+	sig := signature(key)
+	keys := ix.keys[sig]
+	i, found := slices.BinarySearch(keys, key)
+	if found {
+		return false
+	}
+	if len(keys) == 0 {
+		ix.byCount = nil
+	}
+	ix.groups[sig] = slices.Insert(ix.groups[sig], i, word)
+	ix.keys[sig] = slices.Insert(keys, i, key)
+	ix.words++
+	return true
+}
+
+// Len — число слов в индексе
+func (ix *Index) Len() int { return ix.words }
+
+// Anagrams — слова словаря из тех же букв, что и word, кроме него самого
+// (в любом написании с тем же ключом)
+func (ix *Index) Anagrams(word string) []string {
+	key := ix.key(word)
+	sig := signature(key)
+	var out []string
+	for i, k := range ix.keys[sig] {
+		if k != key {
+			out = append(out, ix.groups[sig][i])
+		}
+	}
+	return out
+}
+
+// Groups — все группы хотя бы из двух слов, по первому слову группы
+func (ix *Index) Groups() map[string][]string {
+	result := make(map[string][]string)
+	for _, group := range ix.groups {
+		if len(group) > 1 {
+			result[group[0]] = slices.Clone(group)
+		}
+	}
+	return result
+}
+
+// BuildIndex читает словарь по строке, не держа его целиком в памяти:
+// одно слово на строку, пробелы по краям и пустые строки пропускаются.
+// Слова сравниваются по правилам n. Строка не в UTF-8 — ошибка с её номером
+func BuildIndex(r io.Reader, n Normalizer) (*Index, error) {
+	ix := NewIndex(n)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxWordLen+2) // +2 на \r\n
+	line := 0
+	for sc.Scan() {
+		line++
+		if !utf8.Valid(sc.Bytes()) {
+			return nil, fmt.Errorf("строка %d: %w", line, ErrInvalidUTF8)
+		}
+		if word := strings.TrimSpace(sc.Text()); word != "" {
+			ix.Add(word)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("строка %d: слово длиннее %d байт", line+1, maxWordLen)
+		}
+		return nil, err
+	}
+	return ix, nil
+}
+
+// sortedKeys — подписи групп по возрастанию, для детерминированного вывода
+func (ix *Index) sortedKeys() []string {
+	keys := make([]string, 0, len(ix.groups))
+	for k := range ix.groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
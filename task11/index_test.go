@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchDict(t *testing.T) {
+	got := searchDict([]string{"пятак", "пятка", "Тяпка", "листок", "слиток", "столик", "стол", "пятка"})
+	want := map[string][]string{
+		"пятак":  {"пятак", "пятка", "Тяпка"},
+		"листок": {"листок", "слиток", "столик"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("searchDict = %v, want %v", got, want)
+	}
+}
+
+// пример, который печатала прежняя main
+func Example_searchDict() {
+	fmt.Println(searchDict([]string{"пятак", "пятка", "тяпка", "листок", "слиток", "столик", "стол"}))
+	// Output: map[листок:[листок слиток столик] пятак:[пятак пятка тяпка]]
+}
+
+func TestIndexKeysOnce(t *testing.T) {
+	ix := NewIndex(Normalizer{})
+	calls := 0
+	key := ix.key
+	ix.key = func(w string) string {
+		calls++
+		return key(w)
+	}
+	// ключ слова считается один раз, а не на каждом сравнении в группе
+	words := []string{"пятак", "пятка", "тяпка", "ПЯТАК", "катяп", "паятк"}
+	for _, w := range words {
+		ix.Add(w)
+	}
+	if calls != len(words) {
+		t.Errorf("Add: ключ посчитан %d раз на %d слов", calls, len(words))
+	}
+	calls = 0
+	if got := ix.Anagrams("Пятак"); len(got) != 4 || calls != 1 {
+		t.Errorf("Anagrams = %v, ключ посчитан %d раз", got, calls)
+	}
+}
+
+func TestBuildIndex(t *testing.T) {
+	ix, err := BuildIndex(strings.NewReader("пятак\r\n  пятка \n\nтяпка\nПЯТАК\nкот\n"), Normalizer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Len() != 4 {
+		t.Errorf("Len = %d, want 4", ix.Len())
+	}
+	if got := ix.Anagrams("Тяпка"); !reflect.DeepEqual(got, []string{"пятак", "пятка"}) {
+		t.Errorf("Anagrams(Тяпка) = %v", got)
+	}
+	if got := ix.Anagrams("дом"); got != nil {
+		t.Errorf("Anagrams(дом) = %v", got)
+	}
+
+	_, err = BuildIndex(strings.NewReader("кот\nток\n\xffкот\n"), Normalizer{})
+	if !errors.Is(err, ErrInvalidUTF8) || !strings.Contains(err.Error(), "строка 3") {
+		t.Errorf("err = %v", err)
+	}
+	_, err = BuildIndex(strings.NewReader(strings.Repeat("a", maxWordLen+10)), Normalizer{})
+	if err == nil {
+		t.Error("слишком длинное слово принято")
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	ix, err := BuildIndex(strings.NewReader("пятак\nпятка\nтяпка\nлисток\nслиток\nстол\n"), Normalizer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := ix.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo = %d, %v; записано %d", n, err, buf.Len())
+	}
+	data := buf.Bytes()
+
+	loaded, err := ReadIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.groups, ix.groups) || loaded.Len() != ix.Len() {
+		t.Errorf("загружено %v, want %v", loaded.groups, ix.groups)
+	}
+	// загруженный индекс можно пополнять
+	loaded.Add("тапка")
+	if got := loaded.Anagrams("пятка"); !reflect.DeepEqual(got, []string{"пятак", "тяпка"}) {
+		t.Errorf("Anagrams(пятка) = %v", got)
+	}
+
+	var again bytes.Buffer
+	_, _ = ix.WriteTo(&again)
+	if !bytes.Equal(again.Bytes(), data) {
+		t.Error("повторное сохранение дало другой файл")
+	}
+
+	for i := range data {
+		bad := bytes.Clone(data)
+		bad[i] ^= 0x40
+		if _, err := ReadIndex(bytes.NewReader(bad)); !errors.Is(err, ErrBadIndex) {
+			t.Errorf("порча байта %d не замечена: %v", i, err)
+		}
+	}
+	if _, err := ReadIndex(bytes.NewReader(data[:len(data)-1])); !errors.Is(err, ErrBadIndex) {
+		t.Errorf("обрезанный файл: %v", err)
+	}
+}
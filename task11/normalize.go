@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer — правила, по которым слово приводится к ключу: слова с
+// одинаковым ключом считаются одним словом, а анаграммы ищутся по буквам
+// ключа. Само слово в индексе хранится как было написано.
+// Нулевое значение — только смена регистра и каноническая композиция:
+// «é» одной руной и «e» с комбинируемым акутом дают один ключ
+type Normalizer struct {
+	// Decompose приводит ключ к NFD вместо NFC: диакритический знак
+	// становится отдельной буквой и может «переехать» на другую
+	Decompose bool
+	// StripMarks убирает диакритику: é → e, ё → е, й → и
+	StripMarks bool
+	// Lang задаёт правила смены регистра: для tr и az «I» — это «ı»,
+	// а «İ» — «i». Пустой тег — правила без учёта языка
+	Lang language.Tag
+	// LettersOnly отбрасывает всё, кроме букв и их диакритики:
+	// дефисы, апострофы, пробелы внутри слова, цифры
+	LettersOnly bool
+	// YoToE заменяет ё на е, не трогая остальную диакритику
+	YoToE bool
+}
+
+// keyFunc собирает функцию, приводящую слово к ключу. Порядок шагов:
+// NFC, нижний регистр по правилам Lang и свёртка регистра (ß → ss,
+// ς → σ), ё → е, снятие диакритики, отбор букв, итоговая форма.
+// Цепочка шагов держит состояние, поэтому у каждой горутины своя
+// из пула: возвращённую функцию можно звать из нескольких горутин
+func (n Normalizer) keyFunc() func(string) string {
+	chains := sync.Pool{New: func() any { return n.chain() }}
+	return func(word string) string {
+		t := chains.Get().(transform.Transformer)
+		defer chains.Put(t)
+		key, _, err := transform.String(t, word)
+		if err != nil {
+			// на корректном UTF-8 цепочка не ошибается; на всякий случай —
+			// ключ без нормализации, как было до неё
+			return strings.ToLower(word)
+		}
+		return key
+	}
+}
+
+// chain собирает цепочку шагов keyFunc
+func (n Normalizer) chain() transform.Transformer {
+	steps := []transform.Transformer{norm.NFC, cases.Lower(n.Lang), cases.Fold()}
+	if n.YoToE {
+		steps = append(steps, runes.Map(func(r rune) rune {
+			if r == 'ё' {
+				return 'е'
+			}
+			return r
+		}))
+	}
+	if n.StripMarks {
+		steps = append(steps, norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	}
+	if n.LettersOnly {
+		steps = append(steps, runes.Remove(runes.Predicate(func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsMark(r)
+		})))
+	}
+	if n.Decompose {
+		steps = append(steps, norm.NFD)
+	} else {
+		// свёртка регистра и снятие букв могут нарушить композицию
+		steps = append(steps, norm.NFC)
+	}
+	return transform.Chain(steps...)
+}
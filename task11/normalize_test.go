@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestNormalizerKey(t *testing.T) {
+	tests := []struct {
+		name string
+		n    Normalizer
+		in   string
+		want string
+	}{
+		{"нижний регистр", Normalizer{}, "ТЯПКА", "тяпка"},
+		{"NFC", Normalizer{}, "Cafe\u0301", "caf\u00e9"},
+		{"NFD", Normalizer{Decompose: true}, "Caf\u00e9", "cafe\u0301"},
+		{"свёртка ß", Normalizer{}, "Straße", "strasse"},
+		{"свёртка ς", Normalizer{}, "ΛΌΓΟΣ", "λόγοσ"},
+		{"I без языка", Normalizer{}, "KISA", "kisa"},
+		{"I по-турецки", Normalizer{Lang: language.Turkish}, "KISA", "kısa"},
+		{"İ по-турецки", Normalizer{Lang: language.Turkish}, "İKİ", "iki"},
+		{"İ разложенная", Normalizer{Lang: language.Turkish}, "I\u0307KI\u0307", "iki"},
+		{"ё остаётся", Normalizer{}, "Ёлка", "ёлка"},
+		{"ё → е", Normalizer{YoToE: true}, "Ёлка", "елка"},
+		{"ё → е без прочей диакритики", Normalizer{YoToE: true}, "йёé", "йеé"},
+		{"снятие диакритики", Normalizer{StripMarks: true}, "Ёлка Éclair йод", "елка eclair иод"},
+		{"снятие диакритики в NFD", Normalizer{StripMarks: true}, "e\u0301te\u0301", "ete"},
+		{"только буквы", Normalizer{LettersOnly: true}, "L'ami-2 dé", "lamidé"},
+		{"только буквы, пустой ключ", Normalizer{LettersOnly: true}, "--42--", ""},
+		{"всё сразу", Normalizer{StripMarks: true, LettersOnly: true, Lang: language.Turkish}, "Işık-Çay", "ısıkcay"},
+	}
+	for _, tc := range tests {
+		if got := tc.n.keyFunc()(tc.in); got != tc.want {
+			t.Errorf("%s: key(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+// Поиски из нескольких горутин не делят состояние цепочки нормализации
+func TestIndexConcurrentSearch(t *testing.T) {
+	dict := testDict + "Ёлка\nкелья\nКАЛЕ\nл'ека\nCaf\u00e9\nface\n"
+	ix, err := BuildIndex(strings.NewReader(dict), Normalizer{YoToE: true, StripMarks: true, LettersOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []string{"Silent", "ЁЛКА", "сотл", "L'ISTEN", "caf\u00e9", "tinsel-net"}
+	type result struct {
+		anagrams, sub, phrases []string
+	}
+	search := func(q string) result {
+		return result{ix.Anagrams(q), ix.SubAnagrams(q, 0), collect(ix, q, 2)}
+	}
+	want := make([]result, len(queries))
+	for i, q := range queries {
+		want[i] = search(q)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				for i, q := range queries {
+					if got := search(q); !reflect.DeepEqual(got, want[i]) {
+						t.Errorf("%q: %v, want %v", q, got, want[i])
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIndexNormalized(t *testing.T) {
+	dict := "Ёлка\nелка\nкелья\nКАЛЕ\nл'ека\nCaf\u00e9\nface\ncafe\u0301\n"
+	ix, err := BuildIndex(strings.NewReader(dict), Normalizer{YoToE: true, LettersOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// «елка» и «cafe» с отдельным акутом повторяют уже добавленные слова
+	if ix.Len() != 6 {
+		t.Errorf("Len = %d, want 6", ix.Len())
+	}
+	want := map[string][]string{"Ёлка": {"Ёлка", "КАЛЕ", "л'ека"}}
+	if got := ix.Groups(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Groups = %v, want %v", got, want)
+	}
+	if got := ix.Anagrams("ЕЛКА"); !reflect.DeepEqual(got, []string{"КАЛЕ", "л'ека"}) {
+		t.Errorf("Anagrams(ЕЛКА) = %v", got)
+	}
+	if got := ix.SubAnagrams("кал-ёк", 0); !reflect.DeepEqual(got, []string{"Ёлка", "КАЛЕ", "л'ека"}) {
+		t.Errorf("SubAnagrams = %v", got)
+	}
+
+	tr, err := BuildIndex(strings.NewReader("KISA\nsakı\nkısa\nkasi\n"), Normalizer{Lang: language.Turkish})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.Anagrams("kısa"); !reflect.DeepEqual(got, []string{"sakı"}) {
+		t.Errorf("tr: Anagrams(kısa) = %v", got)
+	}
+
+	// правила нормализации сохраняются вместе с индексом
+	var buf bytes.Buffer
+	if _, err := tr.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.norm != tr.norm || !reflect.DeepEqual(loaded.groups, tr.groups) {
+		t.Errorf("загружено %+v %v, want %+v %v", loaded.norm, loaded.groups, tr.norm, tr.groups)
+	}
+	if got := loaded.Anagrams("SAKI"); !reflect.DeepEqual(got, []string{"KISA"}) {
+		t.Errorf("после загрузки: Anagrams(SAKI) = %v", got)
+	}
+}
+
+func TestRunNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		code int
+		out  string
+	}{
+		{[]string{"-lang", "tr", "sakı"}, 0, "sakı: KISA\n"},
+		{[]string{"sakı"}, 0, "sakı: kısa\n"},
+		{[]string{"-strip", "-letters", "Éclair"}, 0, "Éclair: l'éCrai\n"},
+		{[]string{"-form", "nfk"}, 2, ""},
+		{[]string{"-lang", "!!"}, 2, ""},
+	} {
+		var stdout, stderr bytes.Buffer
+		args := append([]string{"-dict", "-"}, tc.args...)
+		code := run(args, strings.NewReader("KISA\nsakı\nkısa\nl'éCrai\n"), &stdout, &stderr)
+		if code != tc.code || stdout.String() != tc.out {
+			t.Errorf("%v: code = %d, stdout = %q, stderr = %q", tc.args, code, stdout.String(), stderr.String())
+		}
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"-index", "x.idx", "-yo"}, nil, io.Discard, &stderr); code != 2 {
+		t.Errorf("-index -yo: code = %d, want 2", code)
+	}
+}
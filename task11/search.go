@@ -0,0 +1,293 @@
+package main
+
+import (
+	"cmp"
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// letterCount — буква и сколько раз она встречается
+type letterCount struct {
+	r rune
+	n int
+}
+
+// letters — буквы слова с кратностями по возрастанию буквы. В отличие от
+// подписи-строки, по ним сразу видно, собирается ли одно слово из букв
+// другого и что после этого остаётся
+type letters []letterCount
+
+// countLetters считает буквы подписи: руны в ней уже по возрастанию
+func countLetters(sig string) letters {
+	var l letters
+	for _, r := range sig {
+		if n := len(l); n > 0 && l[n-1].r == r {
+			l[n-1].n++
+		} else {
+			l = append(l, letterCount{r, 1})
+		}
+	}
+	return l
+}
+
+// size — число букв с учётом кратностей
+func (l letters) size() int {
+	n := 0
+	for _, c := range l {
+		n += c.n
+	}
+	return n
+}
+
+// covers сообщает, хватит ли букв l, чтобы собрать w
+func (l letters) covers(w letters) bool {
+	i := 0
+	for _, c := range w {
+		for i < len(l) && l[i].r < c.r {
+			i++
+		}
+		if i == len(l) || l[i].r != c.r || l[i].n < c.n {
+			return false
+		}
+	}
+	return true
+}
+
+// minus — буквы, оставшиеся от l после того, как из них собрали w.
+// w должно покрываться l
+func (l letters) minus(w letters) letters {
+	out := make(letters, 0, len(l))
+	i := 0
+	for _, c := range l {
+		if i < len(w) && w[i].r == c.r {
+			c.n -= w[i].n
+			i++
+		}
+		if c.n > 0 {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// mark сбрасывает missing[i] для букв l[i], которые есть в w
+func (l letters) mark(w letters, missing []bool) {
+	i := 0
+	for _, c := range w {
+		for l[i].r < c.r {
+			i++
+		}
+		missing[i] = false
+	}
+}
+
+// key — подпись-строка тех же букв, для ключей в map
+func (l letters) key() string {
+	var b strings.Builder
+	for _, c := range l {
+		for range c.n {
+			b.WriteRune(c.r)
+		}
+	}
+	return b.String()
+}
+
+// countEntry — группа анаграмм с подсчитанными буквами
+type countEntry struct {
+	key     string
+	letters letters
+	size    int
+}
+
+// counted — группы индекса с подсчитанными буквами: сначала длинные,
+// при равной длине по подписи. Строится при первом поиске и
+// сбрасывается, когда Add заводит новую группу
+func (ix *Index) counted() []countEntry {
+	ix.countMu.Lock()
+	defer ix.countMu.Unlock()
+	if ix.byCount != nil {
+		return ix.byCount
+	}
+	entries := make([]countEntry, 0, len(ix.groups))
+	for key := range ix.groups {
+		l := countLetters(key)
+		entries = append(entries, countEntry{key: key, letters: l, size: l.size()})
+	}
+	slices.SortFunc(entries, func(a, b countEntry) int {
+		return cmp.Or(cmp.Compare(b.size, a.size), strings.Compare(a.key, b.key))
+	})
+	ix.byCount = entries
+	return entries
+}
+
+// pool — буквы ключа запроса без пробелов между словами
+func (ix *Index) pool(text string) letters {
+	return countLetters(signature(ix.key(strings.Join(strings.Fields(text), ""))))
+}
+
+// candidates — группы, которые собираются из букв p, в порядке counted
+func (ix *Index) candidates(p letters) []countEntry {
+	size := p.size()
+	var out []countEntry
+	for _, e := range ix.counted() {
+		if e.size <= size && p.covers(e.letters) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// SubAnagrams — слова словаря, которые можно собрать из букв text (каждую
+// букву — не больше раз, чем она есть в text; пробелы не считаются):
+// сначала длинные, при равной длине по возрастанию ключа. limit > 0
+// ограничивает число слов
+func (ix *Index) SubAnagrams(text string, limit int) []string {
+	type found struct{ word, key string }
+	var all []found
+	for _, e := range ix.candidates(ix.pool(text)) {
+		for i, w := range ix.groups[e.key] {
+			all = append(all, found{w, ix.keys[e.key][i]})
+		}
+	}
+	slices.SortFunc(all, func(a, b found) int {
+		return cmp.Or(cmp.Compare(utf8.RuneCountInString(b.key), utf8.RuneCountInString(a.key)), strings.Compare(a.key, b.key))
+	})
+	if limit > 0 && len(all) > limit {
+		all = all[:limit]
+	}
+	out := make([]string, len(all))
+	for i, f := range all {
+		out[i] = f.word
+	}
+	return out
+}
+
+// Phrases перечисляет фразы из слов словаря, в которых ровно те же буквы,
+// что в phrase (пробелы не считаются), и не больше maxWords слов. Слово
+// может повторяться. Фраза из тех же слов, что phrase (с точностью до
+// нормализации), не выдаётся.
+// Слова во фразе идут от длинных к коротким, порядок фраз детерминирован.
+// Фразы выдаются по мере нахождения и не копятся в памяти, а перебор
+// прекращается, как только цикл по ним прерван: так ограничивают число
+// результатов — их бывают миллионы
+func (ix *Index) Phrases(phrase string, maxWords int) iter.Seq[[]string] {
+	return func(yield func([]string) bool) {
+		p := ix.pool(phrase)
+		if len(p) == 0 || maxWords < 1 {
+			return
+		}
+		s := phraseSearch{
+			ix:       ix,
+			maxWords: maxWords,
+			self:     ix.wordKeys(strings.Fields(phrase)),
+			cands:    ix.candidates(p),
+			yield:    yield,
+			dead:     make(map[string]bool),
+		}
+		s.search(p, 0, nil)
+	}
+}
+
+// phraseSearch — состояние перебора в Phrases
+type phraseSearch struct {
+	ix       *Index
+	maxWords int
+	self     []string // ключи слов исходной фразы по возрастанию
+	cands    []countEntry
+	yield    func([]string) bool
+	stopped  bool // yield вернул false
+	// dead — состояния (остаток букв, с какой группы, сколько слов
+	// осталось), из которых фраза не собирается. Без него перебор
+	// экспоненциален на запросах без ответа
+	dead map[string]bool
+}
+
+// search подбирает группы для остатка букв rest, начиная с группы from,
+// чтобы каждый набор групп встретился один раз. chosen — уже выбранные
+// группы. Возвращает, нашёлся ли хоть один набор групп
+func (s *phraseSearch) search(rest letters, from int, chosen []int) bool {
+	if len(rest) == 0 {
+		s.expand(chosen, 0, 0, nil)
+		return true
+	}
+	left := s.maxWords - len(chosen)
+	if left == 0 {
+		return false
+	}
+	state := rest.key() + "\x00" + strconv.Itoa(from) + "\x00" + strconv.Itoa(left)
+	if s.dead[state] {
+		return false
+	}
+	// сначала отбираются подходящие группы: если какую-то букву rest
+	// не содержит ни одна из них, фраза не соберётся, перебирать нечего
+	size := rest.size()
+	var fit []int
+	missing := make([]bool, len(rest))
+	for i := range missing {
+		missing[i] = true
+	}
+	for i := from; i < len(s.cands); i++ {
+		e := s.cands[i]
+		// группы идут от длинных к коротким: если даже left самых длинных
+		// из оставшихся не наберут size букв, дальше только хуже
+		if e.size*left < size {
+			break
+		}
+		if e.size > size || !rest.covers(e.letters) {
+			continue
+		}
+		fit = append(fit, i)
+		rest.mark(e.letters, missing)
+	}
+	ok := !slices.Contains(missing, true)
+	if ok {
+		ok = false
+		for _, i := range fit {
+			if s.stopped {
+				break
+			}
+			if s.search(rest.minus(s.cands[i].letters), i, append(chosen, i)) {
+				ok = true
+			}
+		}
+	}
+	if !ok && !s.stopped {
+		s.dead[state] = true
+	}
+	return ok
+}
+
+// expand превращает набор групп в фразы: по слову из каждой группы. Для
+// подряд идущих одинаковых групп слова берутся по неубыванию, чтобы
+// «a b» и «b a» не считались разными фразами
+func (s *phraseSearch) expand(chosen []int, pos, start int, words []string) {
+	if s.stopped {
+		return
+	}
+	if pos == len(chosen) {
+		if !slices.Equal(s.ix.wordKeys(words), s.self) {
+			s.stopped = !s.yield(slices.Clone(words))
+		}
+		return
+	}
+	if pos > 0 && chosen[pos] != chosen[pos-1] {
+		start = 0
+	}
+	group := s.ix.groups[s.cands[chosen[pos]].key]
+	for j := start; j < len(group); j++ {
+		s.expand(chosen, pos+1, j, append(words, group[j]))
+	}
+}
+
+// wordKeys — ключи слов words по возрастанию
+func (ix *Index) wordKeys(words []string) []string {
+	keys := make([]string, len(words))
+	for i, w := range words {
+		keys[i] = ix.key(w)
+	}
+	slices.Sort(keys)
+	return keys
+}
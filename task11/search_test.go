@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testDict = "listen\nsilent\nenlist\ntinsel\nlist\nnet\nten\nlens\nis\nit\nsit\nlent\nстол\nсто\nлот\n"
+
+func testIndex(t *testing.T) *Index {
+	t.Helper()
+	ix, err := BuildIndex(strings.NewReader(testDict), Normalizer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ix
+}
+
+func TestLetters(t *testing.T) {
+	pool := countLetters(signature("mississippi"))
+	if pool.size() != 11 || pool.key() != signature("mississippi") {
+		t.Errorf("pool = %v", pool)
+	}
+	for _, tc := range []struct {
+		word   string
+		covers bool
+		rest   string
+	}{
+		{"miss", true, "iiippss"},
+		{"sips", true, "iiimpss"},
+		{"mississippi", true, ""},
+		{"mist", false, ""},
+		{"misssss", false, ""},
+	} {
+		w := countLetters(signature(tc.word))
+		if got := pool.covers(w); got != tc.covers {
+			t.Errorf("covers(%q) = %v", tc.word, got)
+			continue
+		}
+		if tc.covers {
+			if got := pool.minus(w).key(); got != tc.rest {
+				t.Errorf("minus(%q) = %q, want %q", tc.word, got, tc.rest)
+			}
+		}
+	}
+}
+
+func TestSubAnagrams(t *testing.T) {
+	ix := testIndex(t)
+	if got, want := ix.SubAnagrams("Silent", 0), []string{
+		"enlist", "listen", "silent", "tinsel", "lens", "lent", "list", "net", "sit", "ten", "is", "it",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SubAnagrams(Silent) = %v", got)
+	}
+	if got := ix.SubAnagrams("sit", 2); !reflect.DeepEqual(got, []string{"sit", "is"}) {
+		t.Errorf("SubAnagrams(sit, 2) = %v", got)
+	}
+	if got := ix.SubAnagrams("сотл", 0); !reflect.DeepEqual(got, []string{"стол", "лот", "сто"}) {
+		t.Errorf("SubAnagrams(сотл) = %v", got)
+	}
+	// новое слово попадает в поиск после Add
+	ix.Add("tile")
+	if got := ix.SubAnagrams("tile", 0); !reflect.DeepEqual(got, []string{"tile", "it"}) {
+		t.Errorf("после Add: %v", got)
+	}
+}
+
+// Первый поиск строит byCount; параллельные поиски получают одну и ту же таблицу
+func TestCountedConcurrent(t *testing.T) {
+	ix := testIndex(t)
+	var wg sync.WaitGroup
+	results := make([][]countEntry, 8)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = ix.counted()
+		}()
+	}
+	wg.Wait()
+	for _, r := range results[1:] {
+		if len(r) == 0 || &r[0] != &results[0][0] {
+			t.Fatal("таблица построена больше одного раза")
+		}
+	}
+}
+
+func collect(ix *Index, phrase string, maxWords int) []string {
+	var out []string
+	for words := range ix.Phrases(phrase, maxWords) {
+		out = append(out, strings.Join(words, " "))
+	}
+	return out
+}
+
+func TestPhrases(t *testing.T) {
+	ix := testIndex(t)
+
+	got := collect(ix, "listen silent", 2)
+	if len(got) != 9 || got[0] != "enlist enlist" || slices.Contains(got, "listen silent") || slices.Contains(got, "silent listen") {
+		t.Errorf("listen silent: %v", got)
+	}
+
+	got = collect(ix, "Listen", 3)
+	for _, want := range []string{"enlist", "lens it", "lent is"} {
+		if !slices.Contains(got, want) {
+			t.Errorf("Listen: нет %q в %v", want, got)
+		}
+	}
+	if slices.Contains(got, "listen") {
+		t.Errorf("Listen: исходная фраза в ответе %v", got)
+	}
+	if got := collect(ix, "Listen", 1); !reflect.DeepEqual(got, []string{"enlist", "silent", "tinsel"}) {
+		t.Errorf("Listen, 1 слово: %v", got)
+	}
+	if got := collect(ix, "listenq", 3); got != nil {
+		t.Errorf("listenq: %v", got)
+	}
+	if got := collect(ix, "  ", 3); got != nil {
+		t.Errorf("пустая фраза: %v", got)
+	}
+
+	n := 0
+	for range ix.Phrases("listen silent", 3) {
+		if n++; n == 5 {
+			break
+		}
+	}
+	if n != 5 {
+		t.Errorf("прервано на %d", n)
+	}
+}
+
+func TestRunSearch(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		code int
+		out  string
+	}{
+		{[]string{"-sub", "-limit", "3", "silent"}, 0, "silent: enlist listen silent\n"},
+		{[]string{"-phrase", "-words", "1", "listen"}, 0, "enlist\nsilent\ntinsel\n"},
+		{[]string{"-phrase", "-limit", "2", "listen", "silent"}, 0, "enlist enlist\nenlist listen\n"},
+		{[]string{"-sub", "-phrase", "listen"}, 2, ""},
+		{[]string{"-phrase", "-words", "0", "listen"}, 2, ""},
+		{[]string{"-sub"}, 2, ""},
+	} {
+		var stdout, stderr bytes.Buffer
+		args := append([]string{"-dict", "-"}, tc.args...)
+		code := run(args, strings.NewReader(testDict), &stdout, &stderr)
+		if code != tc.code || stdout.String() != tc.out {
+			t.Errorf("%v: code = %d, stdout = %q, stderr = %q", tc.args, code, stdout.String(), stderr.String())
+		}
+	}
+}
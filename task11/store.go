@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+// Двоичный формат индекса:
+//
+//	"ANGI" версия(1 байт)
+//	нормализация: флаги (1 байт), тег языка: длина (uvarint) и байты
+//	число групп (uvarint)
+//	группа: число слов (uvarint), слова: длина (uvarint) и байты UTF-8
+//	CRC-32 (IEEE) всего предыдущего, 4 байта big-endian
+//
+// Подписи и ключи не хранятся: при загрузке они вычисляются по правилам
+// нормализации из файла, подпись — по первому слову группы, остальные не
+// перепроверяются — от случайной порчи защищает контрольная сумма. Группы
+// идут по возрастанию подписи, слова в группе — по возрастанию ключа,
+// поэтому один и тот же индекс всегда даёт один и тот же файл
+const (
+	indexMagic   = "ANGI"
+	indexVersion = 2
+)
+
+// Флаги нормализации в заголовке индекса
+const (
+	flagDecompose = 1 << iota
+	flagStripMarks
+	flagLettersOnly
+	flagYoToE
+	flagsKnown = 1<<iota - 1
+)
+
+// maxLangLen — предел длины тега языка в заголовке
+const maxLangLen = 64
+
+// ErrBadIndex — файл не индекс или повреждён
+var ErrBadIndex = errors.New("неверный файл индекса")
+
+// WriteTo сохраняет индекс в двоичном формате
+func (ix *Index) WriteTo(w io.Writer) (int64, error) {
+	crc := crc32.NewIEEE()
+	cw := &countingWriter{w: io.MultiWriter(w, crc)}
+	bw := bufio.NewWriter(cw)
+
+	var buf []byte
+	buf = append(buf, indexMagic...)
+	buf = append(buf, indexVersion, ix.norm.flags())
+	lang := ix.norm.Lang.String()
+	buf = binary.AppendUvarint(buf, uint64(len(lang)))
+	buf = append(buf, lang...)
+	buf = binary.AppendUvarint(buf, uint64(len(ix.groups)))
+	_, _ = bw.Write(buf)
+	for _, key := range ix.sortedKeys() {
+		group := ix.groups[key]
+		buf = binary.AppendUvarint(buf[:0], uint64(len(group)))
+		for _, word := range group {
+			buf = binary.AppendUvarint(buf, uint64(len(word)))
+			buf = append(buf, word...)
+		}
+		_, _ = bw.Write(buf)
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	sum := binary.BigEndian.AppendUint32(nil, crc.Sum32())
+	n, err := w.Write(sum)
+	return cw.n + int64(n), err
+}
+
+// ReadIndex загружает индекс, сохранённый WriteTo
+func ReadIndex(r io.Reader) (*Index, error) {
+	crc := crc32.NewIEEE()
+	br := bufio.NewReader(r)
+	tr := &byteTee{r: br, w: crc}
+
+	head := make([]byte, len(indexMagic)+1)
+	if _, err := io.ReadFull(tr, head); err != nil || string(head[:len(indexMagic)]) != indexMagic {
+		return nil, fmt.Errorf("%w: нет заголовка", ErrBadIndex)
+	}
+	if head[len(indexMagic)] != indexVersion {
+		return nil, fmt.Errorf("%w: версия %d, поддерживается %d", ErrBadIndex, head[len(indexMagic)], indexVersion)
+	}
+
+	n, err := readNormalizer(tr)
+	if err != nil {
+		return nil, err
+	}
+	ix := NewIndex(n)
+	groups, err := binary.ReadUvarint(tr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadIndex, err)
+	}
+	for g := uint64(0); g < groups; g++ {
+		count, err := binary.ReadUvarint(tr)
+		if err != nil || count == 0 {
+			return nil, fmt.Errorf("%w: группа %d", ErrBadIndex, g)
+		}
+		// размер группы из файла не используется для выделения памяти:
+		// повреждённый файл не должен заставить выделить гигабайты
+		var group, keys []string
+		for i := uint64(0); i < count; i++ {
+			size, err := binary.ReadUvarint(tr)
+			if err != nil || size == 0 || size > maxWordLen {
+				return nil, fmt.Errorf("%w: группа %d, слово %d", ErrBadIndex, g, i)
+			}
+			word := make([]byte, size)
+			if _, err := io.ReadFull(tr, word); err != nil || !utf8.Valid(word) {
+				return nil, fmt.Errorf("%w: группа %d, слово %d", ErrBadIndex, g, i)
+			}
+			// Add ищет в группе двоичным поиском по ключу
+			key := ix.key(string(word))
+			if key == "" || len(keys) > 0 && key <= keys[len(keys)-1] {
+				return nil, fmt.Errorf("%w: группа %d не по порядку", ErrBadIndex, g)
+			}
+			group = append(group, string(word))
+			keys = append(keys, key)
+		}
+		sig := signature(keys[0])
+		if _, dup := ix.groups[sig]; dup {
+			return nil, fmt.Errorf("%w: группа %d повторяется", ErrBadIndex, g)
+		}
+		ix.groups[sig] = group
+		ix.keys[sig] = keys
+		ix.words += len(group)
+	}
+
+	want := crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(br, sum[:]); err != nil || binary.BigEndian.Uint32(sum[:]) != want {
+		return nil, fmt.Errorf("%w: не сошлась контрольная сумма", ErrBadIndex)
+	}
+	return ix, nil
+}
+
+// flags — флаги нормализации для заголовка индекса
+func (n Normalizer) flags() byte {
+	var f byte
+	if n.Decompose {
+		f |= flagDecompose
+	}
+	if n.StripMarks {
+		f |= flagStripMarks
+	}
+	if n.LettersOnly {
+		f |= flagLettersOnly
+	}
+	if n.YoToE {
+		f |= flagYoToE
+	}
+	return f
+}
+
+// readNormalizer читает правила нормализации из заголовка индекса
+func readNormalizer(r *byteTee) (Normalizer, error) {
+	f, err := r.ReadByte()
+	if err != nil || f&^flagsKnown != 0 {
+		return Normalizer{}, fmt.Errorf("%w: флаги нормализации", ErrBadIndex)
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > maxLangLen {
+		return Normalizer{}, fmt.Errorf("%w: тег языка", ErrBadIndex)
+	}
+	lang := make([]byte, size)
+	if _, err := io.ReadFull(r, lang); err != nil {
+		return Normalizer{}, fmt.Errorf("%w: тег языка", ErrBadIndex)
+	}
+	tag, err := language.Parse(string(lang))
+	if err != nil {
+		return Normalizer{}, fmt.Errorf("%w: тег языка %q", ErrBadIndex, lang)
+	}
+	return Normalizer{
+		Decompose:   f&flagDecompose != 0,
+		StripMarks:  f&flagStripMarks != 0,
+		LettersOnly: f&flagLettersOnly != 0,
+		YoToE:       f&flagYoToE != 0,
+		Lang:        tag,
+	}, nil
+}
+
+// countingWriter считает записанные байты
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// byteTee читает из r и копирует прочитанное в w (хеш). binary.ReadUvarint
+// нужен io.ByteReader, а io.TeeReader его не даёт
+type byteTee struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func (t *byteTee) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	_, _ = t.w.Write(p[:n])
+	return n, err
+}
+
+func (t *byteTee) ReadByte() (byte, error) {
+	b, err := t.r.ReadByte()
+	if err == nil {
+		_, _ = t.w.Write([]byte{b})
+	}
+	return b, err
+}
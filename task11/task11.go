@@ -1,42 +1,205 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+
+	"golang.org/x/text/language"
 )
 
 func main() {
-	example := []string{"пятак", "пятка", "тяпка", "листок", "слиток", "столик", "стол"}
-	fmt.Println(searchDict(example))
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
 
-func searchDict(words []string) map[string][]string {
-	result := make(map[string][]string)
-	anagramGroups := make(map[string][]string)
-	seen := make(map[string]bool)
-
-	for _, word := range words {
-		word = strings.ToLower(word)
-		if seen[word] {
-			continue
+// run строит индекс из словаря (-dict, "-" — stdin) или загружает
+// сохранённый (-index), при -save сохраняет его и отвечает на запросы:
+// для каждого слова из аргументов печатает его анаграммы из словаря,
+// с -sub — слова, собирающиеся из его букв, а с -phrase печатает по
+// строке фразы-анаграммы всех аргументов вместе.
+// Без слов печатает все группы анаграмм. Слова сравниваются по правилам
+// нормализации из флагов (-form, -strip, -lang, -letters, -yo), а
+// печатаются как написаны в словаре. Сохранённый индекс помнит свои
+// правила, поэтому с -index эти флаги не задаются.
+// Код возврата: 0 — успех, 1 — ошибка чтения или записи, 2 — ошибка в аргументах
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("anagram", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dictPath := fs.String("dict", "", "dictionary `file`, one word per line (- for stdin)")
+	indexPath := fs.String("index", "", "load a saved index `file` instead of a dictionary")
+	savePath := fs.String("save", "", "save the index to `file`")
+	sub := fs.Bool("sub", false, "list dictionary words that can be built from the letters of each word")
+	phrase := fs.Bool("phrase", false, "list multi-word anagrams of all words together")
+	maxWords := fs.Int("words", 3, "maximum number of words in a phrase for -phrase")
+	limit := fs.Int("limit", 100, "maximum number of results per query for -sub and -phrase (0 means no limit)")
+	form := fs.String("form", "nfc", "Unicode normalization form of keys: nfc, or nfd to treat diacritics as separate letters")
+	strip := fs.Bool("strip", false, "ignore diacritics (é = e, ё = е, й = и)")
+	lang := fs.String("lang", "", "language `tag` for case folding, e.g. tr for dotted and dotless i")
+	lettersOnly := fs.Bool("letters", false, "ignore everything but letters: hyphens, apostrophes, spaces, digits")
+	yo := fs.Bool("yo", false, "treat ё as е")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (*dictPath == "") == (*indexPath == "") {
+		_, _ = fmt.Fprintln(stderr, "Ошибка: нужен ровно один из -dict и -index")
+		return 2
+	}
+	if *sub && *phrase {
+		_, _ = fmt.Fprintln(stderr, "Ошибка: -sub и -phrase несовместимы")
+		return 2
+	}
+	if *maxWords < 1 || *limit < 0 {
+		_, _ = fmt.Fprintln(stderr, "Ошибка: -words должно быть не меньше 1, -limit — не меньше 0")
+		return 2
+	}
+	if (*sub || *phrase) && fs.NArg() == 0 {
+		_, _ = fmt.Fprintln(stderr, "Ошибка: для -sub и -phrase нужны слова запроса")
+		return 2
+	}
+	n := Normalizer{StripMarks: *strip, LettersOnly: *lettersOnly, YoToE: *yo}
+	switch *form {
+	case "nfc":
+	case "nfd":
+		n.Decompose = true
+	default:
+		_, _ = fmt.Fprintf(stderr, "Ошибка: неизвестная форма нормализации %q, нужна nfc или nfd\n", *form)
+		return 2
+	}
+	if *lang != "" {
+		tag, err := language.Parse(*lang)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка: -lang: %v\n", err)
+			return 2
 		}
-		seen[word] = true
-
-		runes := []rune(word)
-		sort.Slice(runes, func(i, j int) bool {
-			return runes[i] < runes[j]
+		n.Lang = tag
+	}
+	if *indexPath != "" {
+		normFlags := false
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "form", "strip", "lang", "letters", "yo":
+				normFlags = true
+			}
 		})
-		key := string(runes)
-		anagramGroups[key] = append(anagramGroups[key], word)
+		if normFlags {
+			_, _ = fmt.Fprintln(stderr, "Ошибка: правила нормализации задаются при построении индекса, с -index они не нужны")
+			return 2
+		}
+	}
+
+	ix, err := loadIndex(*dictPath, *indexPath, stdin, n)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Ошибка: %v\n", err)
+		return 1
+	}
+	if *savePath != "" {
+		if err := saveIndex(ix, *savePath); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка сохранения: %v\n", err)
+			return 1
+		}
 	}
 
-	for _, group := range anagramGroups {
-		if len(group) > 1 {
-			sort.Strings(group)
-			result[group[0]] = group
+	if fs.NArg() == 0 {
+		if *savePath == "" {
+			printGroups(stdout, ix.Groups())
+		}
+		return 0
+	}
+	if *phrase {
+		// фраз бывают миллионы: печатаются по мере нахождения
+		out := bufio.NewWriter(stdout)
+		count := 0
+		for words := range ix.Phrases(strings.Join(fs.Args(), " "), *maxWords) {
+			if _, err := fmt.Fprintln(out, strings.Join(words, " ")); err != nil {
+				break
+			}
+			if count++; count == *limit {
+				break
+			}
+		}
+		if err := out.Flush(); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	for _, word := range fs.Args() {
+		found := ix.Anagrams(word)
+		if *sub {
+			found = ix.SubAnagrams(word, *limit)
 		}
+		_, _ = fmt.Fprintf(stdout, "%s: %s\n", word, strings.Join(found, " "))
 	}
+	return 0
+}
 
-	return result
+// loadIndex строит индекс из словаря по правилам n или читает сохранённый
+func loadIndex(dictPath, indexPath string, stdin io.Reader, n Normalizer) (*Index, error) {
+	path, load := dictPath, func(r io.Reader) (*Index, error) { return BuildIndex(r, n) }
+	if indexPath != "" {
+		path, load = indexPath, ReadIndex
+	}
+	if path == "-" {
+		return load(stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ix, err := load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ix, nil
+}
+
+// saveIndex пишет индекс во временный файл рядом и переименовывает его,
+// чтобы оборванная запись не испортила прежний индекс
+func saveIndex(ix *Index, path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".anagram-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// CreateTemp создаёт файл 0600, а индекс — не секрет
+	if err := tmp.Chmod(0o644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := ix.WriteTo(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// printGroups печатает группы по алфавиту первого слова
+func printGroups(w io.Writer, groups map[string][]string) {
+	firsts := make([]string, 0, len(groups))
+	for first := range groups {
+		firsts = append(firsts, first)
+	}
+	sort.Strings(firsts)
+	for _, first := range firsts {
+		_, _ = fmt.Fprintf(w, "%s: %s\n", first, strings.Join(groups[first], " "))
+	}
+}
+
+// searchDict группирует слова по анаграммам без учёта регистра, сохраняя
+// написание. Ключ — первое слово группы, группы из одного слова отбрасываются
+func searchDict(words []string) map[string][]string {
+	ix := NewIndex(Normalizer{})
+	for _, word := range words {
+		ix.Add(word)
+	}
+	return ix.Groups()
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// runExec — builtin exec. С командой заменяет процесс шелла через syscall.Exec,
+// без команды навсегда применяет редиректы к самому шеллу (exec > log).
+// Сеанс сервера не владеет процессом, поэтому в нём работают только редиректы
+func (s *session) runExec(args []string, stdinFile, stdoutFile string, subst *procSubst) error {
+	if !s.interactive {
+		if len(args) > 0 {
+			return fmt.Errorf("exec: replacing the shell is not allowed in a server session")
+		}
+		return s.redirectSession(stdinFile, stdoutFile)
+	}
+
+	// в ограниченном режиме exec вывел бы команду из-под проверок шелла
+	if s.restrict != nil && len(args) > 0 {
+		return s.restrictedError("exec: replacing the shell is not allowed")
+	}
+
+	var path string
+	if len(args) > 0 {
+		// ищем программу до редиректов, чтобы при ошибке шелл остался как был
+		p, err := s.lookupCommand(args[0])
+		if err != nil {
+			return s.printError(fmt.Errorf("exec: %s: not found", args[0]))
+		}
+		path = p
+	}
+
+	if err := s.redirectShell(stdinFile, stdoutFile); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	// пайпы <(cmd) и >(cmd) должны пережить exec под теми же номерами
+	subst.inherit()
+
+	if err := os.Chdir(s.dir); err != nil {
+		return fmt.Errorf("exec: %v", err)
+	}
+
+	// readline включает raw-режим только на время чтения строки,
+	// так что терминал здесь уже в обычном режиме
+	err := syscall.Exec(path, args, s.environ())
+	return fmt.Errorf("exec: %s: %v", args[0], err)
+}
+
+// redirectShell перенаправляет stdin/stdout самого процесса шелла на файлы
+func (s *session) redirectShell(stdinFile, stdoutFile string) error {
+	if stdinFile != "" {
+		f, err := s.openInput(stdinFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := unix.Dup2(int(f.Fd()), 0); err != nil {
+			return fmt.Errorf("exec: %v", err)
+		}
+	}
+
+	if stdoutFile != "" {
+		f, err := s.openOutput(stdoutFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := unix.Dup2(int(f.Fd()), 1); err != nil {
+			return fmt.Errorf("exec: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// redirectSession запоминает редиректы как постоянные потоки сеанса
+func (s *session) redirectSession(stdinFile, stdoutFile string) error {
+	if stdinFile != "" {
+		f, err := s.openInput(stdinFile)
+		if err != nil {
+			return err
+		}
+		if s.redirIn != nil {
+			_ = s.redirIn.Close()
+		}
+		s.redirIn = f
+	}
+
+	if stdoutFile != "" {
+		f, err := s.openOutput(stdoutFile)
+		if err != nil {
+			return err
+		}
+		if s.redirOut != nil {
+			_ = s.redirOut.Close()
+		}
+		s.redirOut = f
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// histEntry — одна выполненная строка в истории: где, когда, сколько шла и чем закончилась
+type histEntry struct {
+	Time     time.Time     `json:"time"`
+	Dir      string        `json:"dir"`
+	Cmd      string        `json:"cmd"`
+	Status   int           `json:"status"`
+	Duration time.Duration `json:"duration"`
+}
+
+// histStore — история в файле формата JSON lines, по записи на строку.
+// Каждая запись дописывается одним write с O_APPEND, поэтому несколько
+// шеллов могут вести один файл одновременно
+type histStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// defaultHistDB — файл истории по умолчанию: в домашнем каталоге, если он известен
+func defaultHistDB() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".myshell_history.jsonl")
+	}
+	return filepath.Join(os.TempDir(), "myshell_history.jsonl")
+}
+
+func newHistStore(path string) *histStore {
+	return &histStore{path: path}
+}
+
+// append дописывает запись в конец файла
+func (h *histStore) append(e histEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// load читает все записи. Битые строки (например, недописанные при падении)
+// пропускаются, отсутствующий файл — это пустая история
+func (h *histStore) load() ([]histEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []histEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 4096), maxLineSize+4096)
+	for sc.Scan() {
+		var e histEntry
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, sc.Err()
+}
+
+// recordHistory сохраняет выполненную строку, если у сеанса есть хранилище
+func (s *session) recordHistory(line, dir string, start time.Time, err error) {
+	if s.hist == nil {
+		return
+	}
+	e := histEntry{
+		Time:     start,
+		Dir:      dir,
+		Cmd:      line,
+		Status:   exitStatus(err),
+		Duration: time.Since(start).Round(time.Millisecond),
+	}
+	if werr := s.hist.append(e); werr != nil {
+		fmt.Fprintln(s.stderr, "history error:", werr)
+	}
+}
+
+// histFilter — условия hist search; пустые поля не проверяются
+type histFilter struct {
+	dir          string
+	status       string // число, "ok" или "fail"
+	since, until time.Time
+	pattern      string
+}
+
+func (f *histFilter) match(e histEntry) bool {
+	if f.dir != "" && e.Dir != f.dir {
+		return false
+	}
+	switch f.status {
+	case "":
+	case "ok":
+		if e.Status != 0 {
+			return false
+		}
+	case "fail":
+		if e.Status == 0 {
+			return false
+		}
+	default:
+		if strconv.Itoa(e.Status) != f.status {
+			return false
+		}
+	}
+	if !f.since.IsZero() && e.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && e.Time.After(f.until) {
+		return false
+	}
+	return strings.Contains(e.Cmd, f.pattern)
+}
+
+// parseHistTime понимает длительность назад от now ("2h", "30m")
+// и абсолютное время ("2006-01-02", "2006-01-02 15:04", RFC 3339)
+func parseHistTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// runHist — builtin hist. Пока есть одна подкоманда:
+//
+//	hist search [-dir DIR] [-status N|ok|fail] [-since T] [-until T] [-n N] [substring]
+//
+// Выводит подходящие записи от старых к новым: время, код, длительность, каталог, команда
+func (s *session) runHist(args []string) (string, error) {
+	fail := func(format string, a ...any) (string, error) {
+		return "", s.printError(fmt.Errorf("hist: "+format, a...))
+	}
+	if len(args) == 0 || args[0] != "search" {
+		return fail("usage: hist search [-dir DIR] [-status N|ok|fail] [-since T] [-until T] [-n N] [substring]")
+	}
+	if s.hist == nil {
+		return fail("no history store in this session")
+	}
+
+	fs := flag.NewFlagSet("hist search", flag.ContinueOnError)
+	fs.SetOutput(s.stderr)
+	dir := fs.String("dir", "", "only commands run in this directory")
+	status := fs.String("status", "", "exit status: a number, ok or fail")
+	since := fs.String("since", "", "not older than: duration ago (2h) or date/time")
+	until := fs.String("until", "", "not newer than: duration ago or date/time")
+	limit := fs.Int("n", 0, "show only the last N matches")
+	if err := fs.Parse(args[1:]); err != nil {
+		return "", fmt.Errorf("hist: %v", err)
+	}
+
+	now := time.Now()
+	f := histFilter{status: *status, pattern: strings.Join(fs.Args(), " ")}
+	if *dir != "" {
+		f.dir = filepath.Clean(s.path(*dir))
+	}
+	if f.status != "" && f.status != "ok" && f.status != "fail" {
+		if _, err := strconv.Atoi(f.status); err != nil {
+			return fail("invalid status %q", f.status)
+		}
+	}
+	var err error
+	if *since != "" {
+		if f.since, err = parseHistTime(*since, now); err != nil {
+			return fail("-since: %v", err)
+		}
+	}
+	if *until != "" {
+		if f.until, err = parseHistTime(*until, now); err != nil {
+			return fail("-until: %v", err)
+		}
+	}
+
+	entries, err := s.hist.load()
+	if err != nil {
+		return fail("%v", err)
+	}
+	var lines []string
+	for _, e := range entries {
+		if f.match(e) {
+			lines = append(lines, fmt.Sprintf("%s\t%d\t%s\t%s\t%s",
+				e.Time.Local().Format("2006-01-02 15:04:05"), e.Status, e.Duration, e.Dir, e.Cmd))
+		}
+	}
+	if *limit > 0 && len(lines) > *limit {
+		lines = lines[len(lines)-*limit:]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// frecency возвращает команды, содержащие query, от лучших к худшим.
+// Вес запуска зависит от давности (час, день, неделя, старше),
+// запуски в каталоге dir весят вчетверо больше остальных
+func frecency(entries []histEntry, dir, query string, now time.Time) []string {
+	scores := map[string]float64{}
+	for _, e := range entries {
+		if !strings.Contains(e.Cmd, query) {
+			continue
+		}
+		age := now.Sub(e.Time)
+		var w float64
+		switch {
+		case age < time.Hour:
+			w = 4
+		case age < 24*time.Hour:
+			w = 2
+		case age < 7*24*time.Hour:
+			w = 1
+		default:
+			w = 0.25
+		}
+		if e.Dir == dir {
+			w *= 4
+		}
+		scores[e.Cmd] += w
+	}
+
+	cmds := make([]string, 0, len(scores))
+	for c := range scores {
+		cmds = append(cmds, c)
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		if scores[cmds[i]] != scores[cmds[j]] {
+			return scores[cmds[i]] > scores[cmds[j]]
+		}
+		return cmds[i] < cmds[j]
+	})
+	return cmds
+}
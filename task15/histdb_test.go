@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHistTime(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"2h", now.Add(-2 * time.Hour), true},
+		{"30m", now.Add(-30 * time.Minute), true},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local), true},
+		{"2024-05-01 08:30", time.Date(2024, 5, 1, 8, 30, 0, 0, time.Local), true},
+		{"2024-05-01 08:30:15", time.Date(2024, 5, 1, 8, 30, 15, 0, time.Local), true},
+		{"2024-05-01T08:30:00Z", time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC), true},
+		{"yesterday", time.Time{}, false},
+		{"2024-13-01", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, tc := range tests {
+		got, err := parseHistTime(tc.in, now)
+		if !got.Equal(tc.want) || (err == nil) != tc.ok {
+			t.Errorf("parseHistTime(%q) = %v, %v; want %v, ok %v", tc.in, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestFrecency(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	at := func(cmd, dir string, ago time.Duration) histEntry {
+		return histEntry{Cmd: cmd, Dir: dir, Time: now.Add(-ago)}
+	}
+	entries := []histEntry{
+		// веса для запроса из /p
+		at("make old", "/p", 30*24*time.Hour), // 0.25 * 4
+		at("make old", "/p", 30*24*time.Hour), // ещё 1, всего 2
+		at("make week", "/q", 3*24*time.Hour), // 1
+		at("make day", "/q", 2*time.Hour),     // 2, при равенстве — по алфавиту
+		at("make here", "/p", 2*time.Hour),    // 2 * 4
+		at("make now", "/q", time.Minute),     // 4
+		at("go test", "/p", time.Minute),
+	}
+
+	tests := []struct {
+		dir, query string
+		want       []string
+	}{
+		{"/p", "make", []string{"make here", "make now", "make day", "make old", "make week"}},
+		{"/q", "make", []string{"make now", "make day", "make week", "make here", "make old"}},
+		{"/p", "test", []string{"go test"}},
+		{"/p", "nothing", []string{}},
+	}
+	for _, tc := range tests {
+		if got := frecency(entries, tc.dir, tc.query, now); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("frecency(%q, %q) = %q, want %q", tc.dir, tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestHistSearch(t *testing.T) {
+	s := testSession(t)
+	s.setenv("PATH", os.Getenv("PATH"))
+	s.hist = newHistStore(filepath.Join(t.TempDir(), "hist.jsonl"))
+
+	sub := filepath.Join(s.dir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	s.recordHistory("echo old", s.dir, old, nil)
+	for _, line := range []string{"echo one", "false", "cd sub", "echo two"} {
+		dir := s.dir
+		_, _, err := runLine(t, s, line)
+		s.recordHistory(line, dir, time.Now(), err)
+	}
+	// битая строка не мешает читать остальные
+	f, err := os.OpenFile(s.hist.path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("{broken\n")
+	_ = f.Close()
+	root := filepath.Dir(sub)
+
+	tests := []struct {
+		args string
+		want []string // команды в выводе
+		ok   bool
+	}{
+		{"", []string{"echo old", "echo one", "false", "cd sub", "echo two"}, true},
+		{"echo", []string{"echo old", "echo one", "echo two"}, true},
+		{"-status fail", []string{"false"}, true},
+		{"-status 1", []string{"false"}, true},
+		{"-status ok -n 2", []string{"cd sub", "echo two"}, true},
+		{"-since 1h", []string{"echo one", "false", "cd sub", "echo two"}, true},
+		{"-until 24h", []string{"echo old"}, true},
+		{"-dir " + root + " echo", []string{"echo old", "echo one"}, true},
+		{"-dir .", []string{"echo two"}, true}, // относительно текущего каталога сеанса
+		{"-status maybe", nil, false},
+		{"-since soon", nil, false},
+	}
+	for _, tc := range tests {
+		out, err := s.runHist(append([]string{"search"}, strings.Fields(tc.args)...))
+		var got []string
+		for _, line := range strings.Split(out, "\n") {
+			if parts := strings.Split(line, "\t"); len(parts) == 5 {
+				got = append(got, parts[4])
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) || (err == nil) != tc.ok {
+			t.Errorf("hist search %s = %q, %v; want %q, ok %v", tc.args, got, err, tc.want, tc.ok)
+		}
+	}
+
+	s.hist = nil
+	if _, err := s.runHist([]string{"search"}); err == nil {
+		t.Error("без хранилища hist search должен завершаться ошибкой")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+// histPicker — Ctrl+R по истории с ранжированием по frecency.
+// Первое нажатие берёт набранный текст как запрос и подставляет лучшую
+// команду для текущего каталога, следующие — перебирают остальные.
+// Любая другая клавиша заканчивает выбор, Enter выполняет выбранную строку.
+// Работает внутри readline: filter и onChange вызываются из его цикла ввода
+type histPicker struct {
+	s      *session
+	rl     *readline.Instance
+	prompt string
+
+	line    string // текущая строка ввода, её сообщает onChange
+	active  bool
+	query   string
+	matches []string
+	idx     int
+}
+
+// onChange запоминает набранный текст — он станет запросом для Ctrl+R
+func (p *histPicker) onChange(line []rune, pos int, key rune) ([]rune, int, bool) {
+	if !p.active {
+		p.line = string(line)
+	}
+	return nil, 0, false
+}
+
+// filter перехватывает Ctrl+R до встроенного поиска readline
+func (p *histPicker) filter(r rune) (rune, bool) {
+	if r != readline.CharBckSearch || p.s.hist == nil {
+		if p.active {
+			p.active = false
+			p.rl.SetPrompt(p.prompt)
+		}
+		return r, true
+	}
+
+	if !p.active {
+		entries, err := p.s.hist.load()
+		if err != nil {
+			fmt.Fprintln(p.s.stderr, "\nhistory error:", err)
+		}
+		p.active, p.query, p.idx = true, p.line, 0
+		p.matches = frecency(entries, p.s.dir, p.query, time.Now())
+	} else if len(p.matches) > 0 {
+		p.idx = (p.idx + 1) % len(p.matches)
+	}
+
+	if len(p.matches) == 0 {
+		p.rl.SetPrompt(fmt.Sprintf("(no match for %q) %s", p.query, p.prompt))
+		return r, false
+	}
+	p.rl.SetPrompt(fmt.Sprintf("(%d/%d %q) %s", p.idx+1, len(p.matches), p.query, p.prompt))
+	p.rl.Operation.SetBuffer(p.matches[p.idx])
+	return r, false
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// job — фоновое задание: команда или пайплайн, запущенные с &.
+// done закрывается, когда все процессы задания завершились, err — их статус
+type job struct {
+	ID   int
+	Cmd  string
+	cmds []*exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+// startJob запускает строку в фоне со stdin из /dev/null и регистрирует задание
+// в таблице сеанса. В отличие от currentProcesses, задания не получают Ctrl+C
+// и живут, пока их не дождутся через wait или не сообщат о завершении
+func (s *session) startJob(line string, subst *procSubst) error {
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		subst.finish()
+		return err
+	}
+	// в сеансе сервера вывод команды собирается до её завершения,
+	// поэтому фоновые задания пишут в постоянные потоки сеанса
+	stdout, stderr := s.out(), s.stderr
+	if s.jobStdout != nil && s.redirOut == nil {
+		stdout = s.jobStdout
+	}
+	if s.jobStderr != nil {
+		stderr = s.jobStderr
+	}
+
+	cmds, err := s.startPipeline(line, devNull, stdout, stderr, false, subst)
+	_ = devNull.Close()
+	if err != nil {
+		subst.finish()
+		return err
+	}
+
+	// startPipeline регистрирует процессы как текущие, фоновым это не нужно
+	for _, cmd := range cmds {
+		removeCurrentProcess(cmd.Process)
+	}
+
+	j := &job{Cmd: line, cmds: cmds, done: make(chan struct{})}
+	s.mu.Lock()
+	j.ID = 1
+	if len(s.jobs) > 0 {
+		j.ID = s.jobs[len(s.jobs)-1].ID + 1
+	}
+	s.jobs = append(s.jobs, j)
+	s.mu.Unlock()
+
+	fmt.Fprintf(s.stdout, "[%d] %d\n", j.ID, cmds[len(cmds)-1].Process.Pid)
+
+	go func() {
+		var lastErr error
+		for _, cmd := range cmds {
+			if err := cmd.Wait(); err != nil {
+				lastErr = err
+			}
+		}
+		subst.finish()
+		j.err = lastErr
+		close(j.done)
+	}()
+
+	return nil
+}
+
+// finished сообщает, завершилось ли задание
+func (j *job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// status возвращает строку состояния задания для jobs и уведомлений
+func (j *job) status() string {
+	if !j.finished() {
+		return "Running"
+	}
+	if j.err == nil {
+		return "Done"
+	}
+	if exitErr, ok := j.err.(*exec.ExitError); ok {
+		return fmt.Sprintf("Exit %d", exitErr.ExitCode())
+	}
+	return "Failed"
+}
+
+// removeJob убирает задание из таблицы
+func (s *session) removeJob(j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	newList := s.jobs[:0]
+	for _, jj := range s.jobs {
+		if jj != j {
+			newList = append(newList, jj)
+		}
+	}
+	s.jobs = newList
+}
+
+// notifyJobs печатает завершившиеся задания и удаляет их из таблицы.
+// Вызывается перед каждым приглашением ввода
+func (s *session) notifyJobs() {
+	s.mu.Lock()
+	var doneJobs []*job
+	for _, j := range s.jobs {
+		if j.finished() {
+			doneJobs = append(doneJobs, j)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, j := range doneJobs {
+		fmt.Fprintf(s.stdout, "[%d]  %-8s %s\n", j.ID, j.status(), j.Cmd)
+		s.removeJob(j)
+	}
+}
+
+// findJob ищет задание по спецификации %N, %%, %+ или pid любого его процесса
+func (s *session) findJob(spec string) (*job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := s.jobs
+
+	if spec == "%%" || spec == "%+" {
+		if len(jobs) == 0 {
+			return nil, fmt.Errorf("wait: %s: no such job", spec)
+		}
+		return jobs[len(jobs)-1], nil
+	}
+
+	if strings.HasPrefix(spec, "%") {
+		id, err := strconv.Atoi(spec[1:])
+		if err != nil {
+			return nil, fmt.Errorf("wait: %s: no such job", spec)
+		}
+		for _, j := range jobs {
+			if j.ID == id {
+				return j, nil
+			}
+		}
+		return nil, fmt.Errorf("wait: %s: no such job", spec)
+	}
+
+	pid, err := strconv.Atoi(spec)
+	if err != nil {
+		return nil, fmt.Errorf("wait: `%s': not a pid or valid job spec", spec)
+	}
+	for _, j := range jobs {
+		for _, cmd := range j.cmds {
+			if cmd.Process != nil && cmd.Process.Pid == pid {
+				return j, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("wait: pid %d is not a child of this shell", pid)
+}
+
+// waitJob блокируется до завершения задания. false — ожидание прервано
+// отменой ctx (Ctrl+C или timeout)
+func (s *session) waitJob(ctx context.Context, j *job) bool {
+	select {
+	case <-j.done:
+		s.removeJob(j)
+		return true
+	case <-ctx.Done():
+		markInterrupted(ctx)
+		return false
+	}
+}
+
+// runWait — builtin wait [pid|%job ...]. Без аргументов ждёт все задания.
+// Возвращает статус последнего из перечисленных заданий
+func (s *session) runWait(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		s.mu.Lock()
+		pending := append([]*job(nil), s.jobs...)
+		s.mu.Unlock()
+		for _, j := range pending {
+			if !s.waitJob(ctx, j) {
+				return fmt.Errorf("wait: interrupted")
+			}
+		}
+		return nil
+	}
+
+	var err error
+	for _, spec := range args {
+		j, e := s.findJob(spec)
+		if e != nil {
+			err = s.printError(e)
+			continue
+		}
+		if !s.waitJob(ctx, j) {
+			return fmt.Errorf("wait: interrupted")
+		}
+		err = j.err
+	}
+	return err
+}
+
+// runJobs — builtin jobs: список фоновых заданий
+func (s *session) runJobs() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := make([]string, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		lines = append(lines, fmt.Sprintf("[%d]  %-8s %s", j.ID, j.status(), j.Cmd))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestWait(t *testing.T) {
+	s := testSession(t)
+	bin := filepath.Join(s.dir, "bin")
+	s.setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	writeScript(t, bin, "ok", "sleep 0.1")
+	writeScript(t, bin, "fail", "sleep 0.1; exit 3")
+
+	started := regexp.MustCompile(`^\[\d+\] \d+\n$`)
+	tests := []struct {
+		line   string
+		stderr string
+		status int
+	}{
+		{"wait", "", 0},
+		{"ok &", "", 0},
+		{"fail &", "", 0},
+		{"wait %2", "", 3},
+		{"wait %1", "", 0},
+		{"wait %1", "wait: %1: no such job\n", 1},
+		{"wait %x", "wait: %x: no such job\n", 1},
+		{"wait abc", "wait: `abc': not a pid or valid job spec\n", 1},
+		{"wait 1", "wait: pid 1 is not a child of this shell\n", 1},
+		{"fail &", "", 0},
+		{"timeout 0.01 wait", "", timeoutStatus},
+		{"wait", "", 0},
+		{"fail & ok &", "", 0},
+		{"wait", "", 0},
+		{"jobs", "", 0},
+	}
+	for _, tc := range tests {
+		stdout, stderr, err := runLine(t, s, tc.line)
+		if stderr != tc.stderr || exitStatus(err) != tc.status {
+			t.Errorf("%s: stderr %q, status %d; want %q, %d", tc.line, stderr, exitStatus(err), tc.stderr, tc.status)
+		}
+		if tc.line == "ok &" && !started.MatchString(stdout) {
+			t.Errorf("%s: stdout %q", tc.line, stdout)
+		}
+		if tc.line == "jobs" && stdout != "" {
+			t.Errorf("после wait остались задания: %q", stdout)
+		}
+	}
+}
+
+func TestFindJob(t *testing.T) {
+	s := testSession(t)
+	bin := filepath.Join(s.dir, "bin")
+	s.setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	writeScript(t, bin, "ok", "sleep 0.1")
+	for i := 0; i < 2; i++ {
+		if _, _, err := runLine(t, s, "ok &"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pid := s.jobs[0].cmds[0].Process.Pid
+
+	tests := []struct {
+		spec string
+		id   int // 0 — ошибка
+	}{
+		{"%1", 1},
+		{"%2", 2},
+		{"%%", 2},
+		{"%+", 2},
+		{"%3", 0},
+		{fmt.Sprint(pid), 1},
+		{"-", 0},
+	}
+	for _, tc := range tests {
+		j, err := s.findJob(tc.spec)
+		switch {
+		case tc.id == 0 && err == nil:
+			t.Errorf("findJob(%q) = [%d], want error", tc.spec, j.ID)
+		case tc.id != 0 && (err != nil || j.ID != tc.id):
+			t.Errorf("findJob(%q) = %v, %v; want [%d]", tc.spec, j, err, tc.id)
+		}
+	}
+	if _, _, err := runLine(t, s, "wait"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// В сеансе без терминала exec без команды меняет потоки сеанса, а не процесса
+func TestExecRedirectsSession(t *testing.T) {
+	s := testSession(t)
+	s.setenv("PATH", os.Getenv("PATH"))
+	if err := os.WriteFile(filepath.Join(s.dir, "in.txt"), []byte("from file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line   string
+		stdout string
+		failed bool
+	}{
+		{"exec > out.txt", "", false},
+		{"echo one", "", false},
+		{"exec < in.txt", "", false},
+		{"cat", "", false},
+		{"exec /bin/true", "", true},
+	}
+	for _, tc := range tests {
+		stdout, _, err := runLine(t, s, tc.line)
+		if stdout != tc.stdout || (err != nil) != tc.failed {
+			t.Errorf("%s: stdout %q, err %v; want %q, failed %v", tc.line, stdout, err, tc.stdout, tc.failed)
+		}
+	}
+
+	got, err := os.ReadFile(filepath.Join(s.dir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "one\nfrom file\n" {
+		t.Errorf("out.txt = %q", got)
+	}
+}
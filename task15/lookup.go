@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hashEntry — запись таблицы хешированных команд: полный путь и число обращений
+type hashEntry struct {
+	Path string
+	Hits int
+}
+
+// lookPath ищет исполняемый файл так же, как exec.LookPath, но по PATH
+// и рабочему каталогу сеанса, а не процесса
+func (s *session) lookPath(name string) (string, error) {
+	if strings.Contains(name, "/") {
+		path := s.path(name)
+		if err := findExecutable(path); err != nil {
+			return "", &exec.Error{Name: name, Err: err}
+		}
+		return path, nil
+	}
+	for _, dir := range filepath.SplitList(s.getenv("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		path := filepath.Join(s.path(dir), name)
+		if findExecutable(path) == nil {
+			return path, nil
+		}
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+// findExecutable проверяет, что path — обычный файл с правом на исполнение
+func findExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// lookupCommand возвращает полный путь к внешней команде.
+// Имена со слэшем не ищутся в PATH, остальные берутся из кеша сеанса
+// (аналог hash в bash), который сбрасывается при изменении PATH
+func (s *session) lookupCommand(name string) (string, error) {
+	if err := s.checkCommandName(name); err != nil {
+		return "", err
+	}
+	if strings.Contains(name, "/") {
+		return s.lookPath(name)
+	}
+
+	s.syncHash()
+	s.mu.Lock()
+	e, ok := s.cmdHash[name]
+	s.mu.Unlock()
+
+	if ok {
+		// файл могли удалить — тогда ищем заново
+		if _, err := os.Stat(e.Path); err == nil {
+			s.mu.Lock()
+			e.Hits++
+			s.mu.Unlock()
+			return e.Path, nil
+		}
+	}
+
+	found, err := s.lookPath(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		delete(s.cmdHash, name)
+		return "", err
+	}
+	s.cmdHash[name] = &hashEntry{Path: found, Hits: 1}
+	return found, nil
+}
+
+// syncHash сбрасывает кеш команд, если PATH сеанса изменился
+func (s *session) syncHash() {
+	path := s.getenv("PATH")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if path != s.hashedPath {
+		s.cmdHash = map[string]*hashEntry{}
+		s.hashedPath = path
+	}
+}
+
+// hashedCommand возвращает путь из кеша без поиска в PATH
+func (s *session) hashedCommand(name string) (string, bool) {
+	path := s.getenv("PATH")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if path != s.hashedPath {
+		return "", false
+	}
+	e, ok := s.cmdHash[name]
+	if !ok {
+		return "", false
+	}
+	return e.Path, true
+}
+
+// newCommand создаёт exec.Cmd, находя программу через lookupCommand.
+// argv[0] остаётся таким, как его ввёл пользователь, каталог и окружение берутся из сеанса
+func (s *session) newCommand(fields []string) *exec.Cmd {
+	cmd := &exec.Cmd{Path: fields[0], Args: fields}
+	path, err := s.lookupCommand(fields[0])
+	if err != nil {
+		// Start вернёт ошибку поиска из cmd.Err
+		cmd.Err = err
+	} else {
+		cmd.Path = path
+	}
+	cmd.Dir = s.dir
+	cmd.Env = s.environ()
+	return cmd
+}
+
+// describeCommand возвращает описание команды для type
+func (s *session) describeCommand(name string) (string, bool) {
+	if isBuiltin(name) {
+		return name + " is a shell builtin", true
+	}
+	if path, ok := s.hashedCommand(name); ok {
+		return fmt.Sprintf("%s is hashed (%s)", name, path), true
+	}
+	path, err := s.lookPath(name)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s is %s", name, path), true
+}
+
+// runType — builtin type: как шелл будет выполнять каждое имя
+func (s *session) runType(names []string) (string, error) {
+	if len(names) == 0 {
+		return "", nil
+	}
+	var lines []string
+	var err error
+	for _, name := range names {
+		desc, ok := s.describeCommand(name)
+		if !ok {
+			err = s.printError(fmt.Errorf("type: %s: not found", name))
+			continue
+		}
+		lines = append(lines, desc)
+	}
+	return strings.Join(lines, "\n"), err
+}
+
+// runWhich — builtin which: полный путь к внешним командам.
+// type, which и command -v только смотрят в PATH и кеш не пополняют
+func (s *session) runWhich(names []string) (string, error) {
+	if len(names) == 0 {
+		return "", s.printError(fmt.Errorf("which: missing argument"))
+	}
+	var lines []string
+	var err error
+	for _, name := range names {
+		path, e := s.lookPath(name)
+		if e != nil {
+			err = s.printError(fmt.Errorf("which: no %s in PATH", name))
+			continue
+		}
+		lines = append(lines, path)
+	}
+	return strings.Join(lines, "\n"), err
+}
+
+// runCommandV — command -v: имя builtin или путь к файлу
+func (s *session) runCommandV(names []string) (string, error) {
+	var lines []string
+	var err error
+	for _, name := range names {
+		if isBuiltin(name) {
+			lines = append(lines, name)
+			continue
+		}
+		path, e := s.lookPath(name)
+		if e != nil {
+			err = s.printError(fmt.Errorf("command: %s: not found", name))
+			continue
+		}
+		lines = append(lines, path)
+	}
+	return strings.Join(lines, "\n"), err
+}
+
+// runHash — builtin hash: без аргументов печатает таблицу, -r очищает её,
+// с именами — заранее кеширует их пути
+func (s *session) runHash(args []string) (string, error) {
+	if len(args) > 0 && args[0] == "-r" {
+		s.mu.Lock()
+		s.cmdHash = map[string]*hashEntry{}
+		s.mu.Unlock()
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		s.syncHash()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.cmdHash) == 0 {
+			return "", nil
+		}
+		names := make([]string, 0, len(s.cmdHash))
+		for name := range s.cmdHash {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		lines := []string{"hits\tcommand"}
+		for _, name := range names {
+			e := s.cmdHash[name]
+			lines = append(lines, fmt.Sprintf("%4d\t%s", e.Hits, e.Path))
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	var err error
+	for _, name := range args {
+		if isBuiltin(name) {
+			continue
+		}
+		if _, e := s.lookupCommand(name); e != nil {
+			err = s.printError(fmt.Errorf("hash: %s: not found", name))
+		}
+	}
+	return "", err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookPath(t *testing.T) {
+	s := testSession(t)
+	bin := filepath.Join(s.dir, "bin")
+	tool := writeScript(t, bin, "tool", "echo tool")
+	if err := os.WriteFile(filepath.Join(bin, "plain"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(bin, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	local := writeScript(t, s.dir, "local", "echo local")
+
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"tool", tool, nil},
+		{"plain", "", exec.ErrNotFound},     // нет права на исполнение
+		{"dir", "", exec.ErrNotFound},       // каталог
+		{"local", "", exec.ErrNotFound},     // текущего каталога нет в PATH
+		{"./local", local, nil},             // со слэшем — от каталога сеанса
+		{"./plain", "", os.ErrNotExist},     // в каталоге сеанса такого нет
+		{"bin/plain", "", os.ErrPermission}, // есть, но не исполняемый
+	}
+	for _, tc := range tests {
+		got, err := s.lookPath(tc.name)
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("lookPath(%q) = %q, %v; want %q, %v", tc.name, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestLookupBuiltins(t *testing.T) {
+	s := testSession(t)
+	tool := writeScript(t, filepath.Join(s.dir, "bin"), "tool", "echo tool")
+
+	tests := []struct {
+		line   string
+		stdout string
+		stderr string
+		failed bool
+	}{
+		{"type cd", "cd is a shell builtin\n", "", false},
+		{"type tool", "tool is " + tool + "\n", "", false},
+		{"type nosuch", "", "type: nosuch: not found\n", true},
+		{"type cd nosuch tool", "cd is a shell builtin\ntool is " + tool + "\n", "type: nosuch: not found\n", true},
+		{"which tool", tool + "\n", "", false},
+		{"which cd", "", "which: no cd in PATH\n", true},
+		{"which nosuch tool", tool + "\n", "which: no nosuch in PATH\n", true},
+		{"which", "", "which: missing argument\n", true},
+		{"command -v cd tool", "cd\n" + tool + "\n", "", false},
+		{"command -v nosuch", "", "command: nosuch: not found\n", true},
+		// type, which и command -v таблицу не пополняют, а запуск пополняет
+		{"hash", "", "", false},
+		{"command echo hi", "hi\n", "", false},
+		{"command tool", "tool\n", "", false},
+		{"hash nosuch", "", "hash: nosuch: not found\n", true},
+		{"hash tool", "", "", false},
+		{"type tool", "tool is hashed (" + tool + ")\n", "", false},
+		{"tool", "tool\n", "", false},
+		{"hash", "hits\tcommand\n   3\t" + tool + "\n", "", false},
+		{"hash -r", "", "", false},
+		{"hash", "", "", false},
+	}
+	for _, tc := range tests {
+		stdout, stderr, err := runLine(t, s, tc.line)
+		if stdout != tc.stdout || stderr != tc.stderr || (err != nil) != tc.failed {
+			t.Errorf("%s: stdout %q, stderr %q, err %v; want %q, %q, failed %v",
+				tc.line, stdout, stderr, err, tc.stdout, tc.stderr, tc.failed)
+		}
+	}
+}
+
+func TestHashFollowsPath(t *testing.T) {
+	s := testSession(t)
+	first := writeScript(t, filepath.Join(s.dir, "bin"), "tool", "echo first")
+	other := filepath.Join(s.dir, "other")
+	if err := os.Mkdir(other, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	second := writeScript(t, other, "tool", "echo second")
+
+	if got, _ := s.lookupCommand("tool"); got != first {
+		t.Fatalf("lookupCommand = %q, want %q", got, first)
+	}
+	// смена PATH сбрасывает таблицу
+	if _, _, err := runLine(t, s, "export PATH="+other); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.lookupCommand("tool"); got != second {
+		t.Errorf("после смены PATH lookupCommand = %q, want %q", got, second)
+	}
+	// удалённую программу ищут заново
+	if err := os.Remove(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.lookupCommand("tool"); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("после удаления: %v", err)
+	}
+	if stdout, _, _ := runLine(t, s, "hash"); strings.Contains(stdout, "tool") {
+		t.Errorf("удалённая программа осталась в таблице: %q", stdout)
+	}
+}
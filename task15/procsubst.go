@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// procSubst — процессы, запущенные для подстановок <(cmd) и >(cmd) одной команды.
+// files — концы пайпов, оставшиеся у шелла; внешняя команда получает их
+// под теми же номерами дескрипторов, что подставлены в /dev/fd/N.
+// readers — пайплайны <(cmd), writers — >(cmd)
+type procSubst struct {
+	files   []*os.File
+	fds     []int
+	readers [][]*exec.Cmd
+	writers [][]*exec.Cmd
+}
+
+// expandProcSubst находит в строке <(cmd) и >(cmd) вне кавычек, запускает
+// внутренние команды через пайпы и заменяет подстановки путями /dev/fd/N.
+// Если подстановок нет, возвращает nil вместо *procSubst
+func (s *session) expandProcSubst(line string) (string, *procSubst, error) {
+	var ps *procSubst
+	var out strings.Builder
+	inSingle := false
+	inDouble := false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' && i+1 < len(line) {
+			out.WriteByte(c)
+			out.WriteByte(line[i+1])
+			i++
+			continue
+		}
+		if c == '"' && !inSingle {
+			inDouble = !inDouble
+		}
+		if c == '\'' && !inDouble {
+			inSingle = !inSingle
+		}
+
+		isSubst := (c == '<' || c == '>') && i+1 < len(line) && line[i+1] == '(' &&
+			!inSingle && !inDouble
+		if !isSubst {
+			out.WriteByte(c)
+			continue
+		}
+
+		end := matchParen(line, i+1)
+		if end == -1 {
+			ps.finish()
+			return "", nil, fmt.Errorf("process substitution: missing ')'")
+		}
+		inner := strings.TrimSpace(line[i+2 : end])
+		if inner == "" {
+			ps.finish()
+			return "", nil, fmt.Errorf("process substitution: empty command")
+		}
+		// внутренняя команда запускается как пайплайн, без && и ||
+		if indexOutsideQuotes(inner, "&&") != -1 || indexOutsideQuotes(inner, "||") != -1 {
+			ps.finish()
+			return "", nil, fmt.Errorf("process substitution: && and || are not supported")
+		}
+
+		// set -n: внутреннюю команду только проверяем, путь — заглушка
+		if s.opts.noexec {
+			if err := checkSyntax(inner); err != nil {
+				return "", nil, err
+			}
+			out.WriteString("/dev/fd/-1")
+			i = end
+			continue
+		}
+
+		if ps == nil {
+			ps = &procSubst{}
+		}
+		path, err := ps.start(s, inner, c == '<')
+		if err != nil {
+			ps.finish()
+			return "", nil, err
+		}
+		out.WriteString(path)
+		i = end
+	}
+
+	return out.String(), ps, nil
+}
+
+// matchParen возвращает индекс скобки, закрывающей line[open], или -1
+func matchParen(line string, open int) int {
+	depth := 0
+	inSingle := false
+	inDouble := false
+	for i := open; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\':
+			i++
+		case c == '"' && !inSingle:
+			inDouble = !inDouble
+		case c == '\'' && !inDouble:
+			inSingle = !inSingle
+		case inSingle || inDouble:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// start запускает внутреннюю команду подстановки. Для <(cmd) шелл оставляет
+// себе конец пайпа для чтения (cmd пишет в него), для >(cmd) — для записи
+func (ps *procSubst) start(s *session, inner string, read bool) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", fmt.Errorf("pipe error: %v", err)
+	}
+
+	var cmds []*exec.Cmd
+	var keep *os.File
+	if read {
+		cmds, err = s.startPipeline(inner, s.in(), w, s.stderr, false, nil)
+		_ = w.Close()
+		keep = r
+	} else {
+		cmds, err = s.startPipeline(inner, r, s.out(), s.stderr, false, nil)
+		_ = r.Close()
+		keep = w
+	}
+	if err != nil {
+		_ = keep.Close()
+		return "", err
+	}
+
+	fd := int(keep.Fd())
+	ps.files = append(ps.files, keep)
+	ps.fds = append(ps.fds, fd)
+	if read {
+		ps.readers = append(ps.readers, cmds)
+	} else {
+		ps.writers = append(ps.writers, cmds)
+	}
+	return fmt.Sprintf("/dev/fd/%d", fd), nil
+}
+
+// attach передаёт команде концы пайпов так, чтобы внутри неё они
+// открывались под теми же номерами (ExtraFiles[i] становится fd 3+i)
+func (ps *procSubst) attach(cmd *exec.Cmd) {
+	if ps == nil {
+		return
+	}
+	for i, f := range ps.files {
+		n := ps.fds[i] - 3
+		for len(cmd.ExtraFiles) <= n {
+			cmd.ExtraFiles = append(cmd.ExtraFiles, nil)
+		}
+		cmd.ExtraFiles[n] = f
+	}
+}
+
+// owns сообщает, что path — путь /dev/fd/N одной из подстановок
+func (ps *procSubst) owns(path string) bool {
+	if ps == nil {
+		return false
+	}
+	for _, fd := range ps.fds {
+		if path == fmt.Sprintf("/dev/fd/%d", fd) {
+			return true
+		}
+	}
+	return false
+}
+
+// finish вызывается после завершения внешней команды и закрывает пайпы.
+// Внутренних команд шелл, как и bash, не ждёт: >(cmd) получает EOF и
+// доделывает работу сама, а вывод <(cmd) читать уже некому, поэтому её
+// группа получает SIGTERM, а через killGrace — SIGKILL. Процессы
+// дожидаются в фоне и до тех пор остаются в currentProcesses
+func (ps *procSubst) finish() {
+	if ps == nil {
+		return
+	}
+	for _, f := range ps.files {
+		_ = f.Close()
+	}
+	for _, cmds := range ps.readers {
+		reapSubst(cmds, true)
+	}
+	for _, cmds := range ps.writers {
+		reapSubst(cmds, false)
+	}
+	ps.files, ps.fds, ps.readers, ps.writers = nil, nil, nil, nil
+}
+
+// reapSubst дожидается в фоне пайплайна подстановки, при kill — сначала
+// завершая его группу процессов
+func reapSubst(cmds []*exec.Cmd, kill bool) {
+	stop := func() {}
+	if kill {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		stop = killOnCancel(ctx, cmds[0].Process.Pid)
+	}
+	go func() {
+		defer stop()
+		for _, cmd := range cmds {
+			_ = cmd.Wait()
+			removeCurrentProcess(cmd.Process)
+		}
+	}()
+}
+
+// inherit снимает close-on-exec с пайпов, чтобы они пережили syscall.Exec
+func (ps *procSubst) inherit() {
+	if ps == nil {
+		return
+	}
+	for _, fd := range ps.fds {
+		_, _ = unix.FcntlInt(uintptr(fd), unix.F_SETFD, 0)
+	}
+}
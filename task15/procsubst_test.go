@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchParen(t *testing.T) {
+	tests := []struct {
+		line string
+		open int
+		want int
+	}{
+		{"(a)", 0, 2},
+		{"<(a (b) c) d", 1, 9},
+		{"<(echo ')') x", 1, 10},
+		{`<(echo ")(") x`, 1, 11},
+		{`<(echo \)) x`, 1, 9},
+		{"<(echo a", 1, -1},
+		{"<(echo '(')", 1, 10},
+	}
+	for _, tc := range tests {
+		if got := matchParen(tc.line, tc.open); got != tc.want {
+			t.Errorf("matchParen(%q, %d) = %d, want %d", tc.line, tc.open, got, tc.want)
+		}
+	}
+}
+
+func TestIndexOutsideQuotes(t *testing.T) {
+	tests := []struct {
+		s, sub string
+		want   int
+	}{
+		{"a && b", "&&", 2},
+		{"'a && b' && c", "&&", 9},
+		{`"a || b" || c`, "||", 9},
+		{`a \&& b`, "&&", -1},
+		{"diff <(a && b) c && d", "&&", 17},
+		{"tee >(a || b) || c", "||", 14},
+		{"cat <(a && b)", "&&", -1},
+		{"cat <(a && b", "&&", 8}, // без закрывающей скобки подстановки нет
+	}
+	for _, tc := range tests {
+		if got := indexOutsideQuotes(tc.s, tc.sub); got != tc.want {
+			t.Errorf("indexOutsideQuotes(%q, %q) = %d, want %d", tc.s, tc.sub, got, tc.want)
+		}
+	}
+}
+
+func TestExpandProcSubst(t *testing.T) {
+	s := testSession(t)
+	s.setenv("PATH", os.Getenv("PATH"))
+
+	tests := []struct {
+		line   string
+		stdout string
+		stderr string
+		failed bool
+	}{
+		{"cat <(echo a) <(echo b)", "a\nb\n", "", false},
+		{"cat <(echo a | tr a A)", "A\n", "", false},
+		{"echo '<(echo a)'", "<(echo a)\n", "", false},
+		{"cat <(echo a && echo b)", "", "process substitution: && and || are not supported\n", true},
+		{"cat <(echo a) && echo ok", "a\nok\n", "", false},
+		{"cat <(echo a", "", "process substitution: missing ')'\n", true},
+		{"cat <( )", "", "process substitution: empty command\n", true},
+	}
+	for _, tc := range tests {
+		stdout, stderr, err := runLine(t, s, tc.line)
+		if stdout != tc.stdout || stderr != tc.stderr || (err != nil) != tc.failed {
+			t.Errorf("%s: stdout %q, stderr %q, err %v; want %q, %q, failed %v",
+				tc.line, stdout, stderr, err, tc.stdout, tc.stderr, tc.failed)
+		}
+	}
+}
+
+// Команда, которая не читает <(cmd), не должна ждать завершения cmd
+func TestProcSubstDoesNotWait(t *testing.T) {
+	s := testSession(t)
+	s.setenv("PATH", os.Getenv("PATH"))
+
+	start := time.Now()
+	stdout, _, err := runLine(t, s, "echo <(sleep 100) done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(stdout, " done\n") {
+		t.Errorf("stdout %q", stdout)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("команда шла %v", d)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// restriction — ограничения режима --restricted (как у rbash):
+// нельзя менять каталог, указывать путь в имени команды, менять PATH,
+// а редиректы разрешены только внутри allowDir
+type restriction struct {
+	allowDir string
+}
+
+// restrictedVars — переменные, которые нельзя менять в ограниченном режиме
+var restrictedVars = map[string]bool{
+	"PATH":     true,
+	"SHELL":    true,
+	"ENV":      true,
+	"BASH_ENV": true,
+}
+
+// newRestriction создаёт ограничения с каталогом для редиректов dir
+func newRestriction(dir string) (*restriction, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+	return &restriction{allowDir: real}, nil
+}
+
+// restrictedError печатает сообщение об ограничении в stderr сеанса
+// и возвращает его как ошибку команды
+func (s *session) restrictedError(format string, args ...any) error {
+	return s.printError(fmt.Errorf("restricted: "+format, args...))
+}
+
+// checkCommandName запрещает пути в имени команды
+func (s *session) checkCommandName(name string) error {
+	if s.restrict == nil || !strings.Contains(name, "/") {
+		return nil
+	}
+	return s.restrictedError("cannot specify `/' in command names")
+}
+
+// checkVar запрещает менять PATH и другие защищённые переменные
+func (s *session) checkVar(name string) error {
+	if s.restrict == nil || !restrictedVars[name] {
+		return nil
+	}
+	return s.restrictedError("%s: readonly variable", name)
+}
+
+// checkRedirect разрешает редирект только в файл внутри allowDir
+// или в путь /dev/fd/N подстановки текущей команды (cat < <(cmd)).
+// Симлинки раскрываются, чтобы через них нельзя было выйти из каталога
+func (s *session) checkRedirect(path string) error {
+	if s.restrict == nil || path == os.DevNull || s.subst.owns(path) {
+		return nil
+	}
+
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		// файла ещё нет (редирект > создаст его) — проверяем каталог
+		dir, e := filepath.EvalSymlinks(filepath.Dir(path))
+		if e != nil {
+			return s.restrictedError("%s: cannot resolve redirect target", path)
+		}
+		real = filepath.Join(dir, filepath.Base(path))
+	}
+
+	rel, err := filepath.Rel(s.restrict.allowDir, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return s.restrictedError("%s: redirect outside %s", path, s.restrict.allowDir)
+	}
+	return nil
+}
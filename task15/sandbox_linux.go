@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// checkNamespaces сообщает, можно ли включить --unshare на этой системе
+func checkNamespaces() error { return nil }
+
+// namespaceAttr запускает внешнюю команду в новых mount, pid и net namespace.
+// Без root дополнительно создаётся user namespace, где текущий пользователь —
+// root, иначе ядро не даст создать остальные. Пайплайны не изолируются:
+// их команды живут в одной группе процессов, а из нового pid namespace
+// pid лидера группы не виден
+func namespaceAttr(attr *syscall.SysProcAttr) {
+	attr.Cloneflags = syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET
+	if os.Geteuid() == 0 {
+		return
+	}
+	attr.Cloneflags |= syscall.CLONE_NEWUSER
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+}
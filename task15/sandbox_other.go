@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errNoNamespaces = errors.New("изоляция в namespace (--unshare) поддерживается только в Linux")
+
+// checkNamespaces — заглушка для систем, кроме Linux: namespace нет
+func checkNamespaces() error { return errNoNamespaces }
+
+// namespaceAttr ничего не делает: --unshare отклоняется при запуске
+func namespaceAttr(attr *syscall.SysProcAttr) {}
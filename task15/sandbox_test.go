@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// restrictedSession — testSession в режиме --restricted с allowDir в каталоге сеанса
+func restrictedSession(t *testing.T) *session {
+	t.Helper()
+	s := testSession(t)
+	s.setenv("PATH", os.Getenv("PATH"))
+	r, err := newRestriction(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.restrict = r
+	s.dir = r.allowDir
+	return s
+}
+
+func TestCheckRedirect(t *testing.T) {
+	s := restrictedSession(t)
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(s.dir, "in.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(s.dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(s.dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(s.dir, "in.txt"), filepath.Join(outside, "back")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"in.txt", true},
+		{"new.txt", true}, // ещё не существует
+		{"sub/new", true}, // в подкаталоге
+		{"sub/../in.txt", true},
+		{"nodir/new", false}, // каталога нет
+		{"../x", false},
+		{"escape/x", false},                    // симлинк наружу
+		{filepath.Join(outside, "back"), true}, // симлинк снаружи внутрь
+		{filepath.Join(outside, "x"), false},
+		{os.DevNull, true},
+		{"/etc/passwd", false},
+	}
+	for _, tc := range tests {
+		err := s.checkRedirect(s.path(tc.path))
+		if (err == nil) != tc.ok {
+			t.Errorf("checkRedirect(%q) = %v, want ok %v", tc.path, err, tc.ok)
+		}
+	}
+
+	// пути подстановок разрешены, только пока они принадлежат команде
+	s.subst = &procSubst{fds: []int{5}}
+	for path, ok := range map[string]bool{"/dev/fd/5": true, "/dev/fd/6": false} {
+		if err := s.checkRedirect(path); (err == nil) != ok {
+			t.Errorf("checkRedirect(%q) с подстановкой = %v, want ok %v", path, err, ok)
+		}
+	}
+	s.subst.finish()
+	if err := s.checkRedirect("/dev/fd/5"); err == nil {
+		t.Error("после finish путь подстановки всё ещё разрешён")
+	}
+
+	s.restrict = nil
+	if err := s.checkRedirect("/etc/passwd"); err != nil {
+		t.Errorf("без ограничений: %v", err)
+	}
+}
+
+func TestRestrictedCommands(t *testing.T) {
+	s := restrictedSession(t)
+
+	tests := []struct {
+		line   string
+		stdout string
+		stderr string
+		failed bool
+	}{
+		{"echo ok", "ok\n", "", false},
+		{"true", "", "", false},
+		{"cd /", "", "restricted: cd: not allowed\n", true},
+		{"/bin/echo x", "", "restricted: cannot specify `/' in command names\n", true},
+		{"./tool", "", "restricted: cannot specify `/' in command names\n", true},
+		{"echo a | /bin/cat", "", "restricted: cannot specify `/' in command names\n", true},
+		{"export PATH=/tmp", "", "restricted: PATH: readonly variable\n", true},
+		{"unset SHELL", "", "restricted: SHELL: readonly variable\n", true},
+		{"export X=1", "", "", false},
+		{"echo hi > out.txt", "", "", false},
+		{"cat < out.txt", "hi\n", "", false},
+		{"cat < <(echo sub)", "sub\n", "", false},
+		{"echo hi > /tmp/out.txt", "", "restricted: /tmp/out.txt: redirect outside " + s.dir + "\n", true},
+		{"pwd", s.dir + "\n", "", false},
+	}
+	for _, tc := range tests {
+		stdout, stderr, err := runLine(t, s, tc.line)
+		if stdout != tc.stdout || stderr != tc.stderr || (err != nil) != tc.failed {
+			t.Errorf("%s: stdout %q, stderr %q, err %v; want %q, %q, failed %v",
+				tc.line, stdout, stderr, err, tc.stdout, tc.stderr, tc.failed)
+		}
+	}
+}
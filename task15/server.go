@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Протокол режима --listen.
+//
+// Клиент пишет в сокет командные строки, по одной на строку (\n в конце).
+// Сервер отвечает кадрами: 1 байт типа, 4 байта длины (big-endian) и данные.
+//
+//	'O' — кусок stdout, 'E' — кусок stderr,
+//	'X' — конец команды, данные — код завершения (int32, big-endian).
+//
+// На каждую строку приходит ровно один кадр 'X', после всего вывода команды.
+// Вывод фоновых заданий (cmd &) приходит кадрами 'O'/'E' в любой момент.
+// После команды exit сервер отправляет 'X' и закрывает соединение
+const (
+	frameStdout byte = 'O'
+	frameStderr byte = 'E'
+	frameExit   byte = 'X'
+)
+
+// maxLineSize — максимальная длина командной строки от клиента
+const maxLineSize = 1 << 20
+
+// drainTimeout — сколько после завершения команды дочитывать её вывод
+// из пайпов, которые ещё держат открытыми ушедшие в фон внуки
+const drainTimeout = 100 * time.Millisecond
+
+// frameWriter пишет кадры в соединение; запись идёт из нескольких горутин
+type frameWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	err     error
+	onError func()
+}
+
+// frame отправляет один кадр. После первой ошибки записи кадры отбрасываются,
+// а onError сообщает сеансу, что клиент пропал
+func (fw *frameWriter) frame(kind byte, payload []byte) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.err != nil {
+		return fw.err
+	}
+
+	var hdr [5]byte
+	hdr[0] = kind
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+	if _, err := fw.w.Write(hdr[:]); err != nil {
+		fw.fail(err)
+		return err
+	}
+	if _, err := fw.w.Write(payload); err != nil {
+		fw.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (fw *frameWriter) fail(err error) {
+	fw.err = err
+	if fw.onError != nil {
+		go fw.onError()
+	}
+}
+
+// forward пересылает всё, что читается из r, кадрами типа kind до EOF
+func (fw *frameWriter) forward(kind byte, r io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			_ = fw.frame(kind, buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// exit отправляет кадр с кодом завершения команды
+func (fw *frameWriter) exit(status int) error {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], uint32(int32(status)))
+	return fw.frame(frameExit, payload[:])
+}
+
+// serve принимает клиентов на unix-сокете, у каждого соединения свой сеанс.
+// setup настраивает каждый новый сеанс (ограничения --restricted и т.п.).
+// Завершается по SIGINT/SIGTERM, сокет при этом удаляется
+func serve(sockPath string, setup func(*session)) error {
+	// сокет мог остаться от прошлого запуска
+	if info, err := os.Lstat(sockPath); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(sockPath)
+	}
+
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		_ = ln.Close()
+	}()
+
+	fmt.Fprintln(os.Stderr, "listening on", sockPath)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			return err
+		}
+		go serveConn(conn, setup)
+	}
+
+	procMu.Lock()
+	for _, p := range currentProcesses {
+		if p != nil {
+			_ = syscall.Kill(-p.Pid, syscall.SIGTERM)
+		}
+	}
+	procMu.Unlock()
+	return nil
+}
+
+// serveConn обслуживает одного клиента: читает строки и выполняет их
+// тем же runConditionals, что и интерактивный шелл, но в отдельном сеансе
+func serveConn(conn net.Conn, setup func(*session)) {
+	defer conn.Close()
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return
+	}
+	defer devNull.Close()
+
+	s, err := newSession(devNull, nil, nil)
+	if err != nil {
+		return
+	}
+	setup(s)
+	fw := &frameWriter{w: conn, onError: s.cancelForeground}
+
+	// постоянные потоки для фоновых заданий сеанса
+	jobOutR, jobOutW, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	jobErrR, jobErrW, err := os.Pipe()
+	if err != nil {
+		_ = jobOutR.Close()
+		_ = jobOutW.Close()
+		return
+	}
+	s.jobStdout, s.jobStderr = jobOutW, jobErrW
+	go fw.forward(frameStdout, jobOutR)
+	go fw.forward(frameStderr, jobErrR)
+	defer func() {
+		s.close()
+		_ = jobOutW.Close()
+		_ = jobErrW.Close()
+	}()
+
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 0, 4096), maxLineSize)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		status := 0
+		if line != "" {
+			status = s.runCaptured(fw, line)
+		}
+		if fw.exit(status) != nil || s.exited {
+			return
+		}
+	}
+}
+
+// runCaptured выполняет строку, отправляя её stdout и stderr клиенту,
+// и возвращает код завершения. Вывод собирается через пайпы, которые
+// закрываются после команды, так что кадр 'X' уходит после всего вывода.
+// Внуки, оставшиеся в фоне (sh -c 'sleep 100 &'), держат пишущие концы
+// открытыми: их ждём не дольше drainTimeout, дальнейший их вывод теряется
+func (s *session) runCaptured(fw *frameWriter, line string) int {
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return 1
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		_ = outR.Close()
+		_ = outW.Close()
+		return 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		fw.forward(frameStdout, outR)
+	}()
+	go func() {
+		defer wg.Done()
+		fw.forward(frameStderr, errR)
+	}()
+
+	s.stdout, s.stderr = outW, errW
+	s.notifyJobs()
+	err = s.runConditionals(line)
+	s.stdout, s.stderr = nil, nil
+
+	_ = outW.Close()
+	_ = errW.Close()
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(drainTimeout):
+	}
+	// закрытие читающих концов прерывает forward, если пайп ещё открыт
+	_ = outR.Close()
+	_ = errR.Close()
+	<-drained
+
+	return exitStatus(err)
+}
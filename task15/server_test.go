@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFrameEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	fw := &frameWriter{w: &buf}
+	if err := fw.frame(frameStdout, []byte("hi\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.frame(frameStderr, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.exit(127); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.exit(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{
+		'O', 0, 0, 0, 3, 'h', 'i', '\n',
+		'E', 0, 0, 0, 0,
+		'X', 0, 0, 0, 4, 0, 0, 0, 127,
+		'X', 0, 0, 0, 4, 0xff, 0xff, 0xff, 0xff,
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("frames = %v, want %v", buf.Bytes(), want)
+	}
+}
+
+// errWriter отказывает после первой записи
+type errWriter struct{ n int }
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	w.n++
+	if w.n > 1 {
+		return 0, io.ErrClosedPipe
+	}
+	return len(p), nil
+}
+
+func TestFrameWriterStopsAfterError(t *testing.T) {
+	failed := make(chan struct{})
+	w := &errWriter{}
+	fw := &frameWriter{w: w, onError: func() { close(failed) }}
+	if err := fw.frame(frameStdout, []byte("x")); err == nil {
+		t.Fatal("want error")
+	}
+	<-failed
+	if err := fw.exit(0); err == nil || w.n != 2 {
+		t.Errorf("после ошибки: err %v, writes %d", err, w.n)
+	}
+}
+
+// client — тестовый клиент сервера поверх net.Pipe
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+	cliConn, srvConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		serveConn(srvConn, func(s *session) { s.dir = t.TempDir() })
+		close(done)
+	}()
+	t.Cleanup(func() {
+		_ = cliConn.Close()
+		<-done
+	})
+	return &client{t: t, conn: cliConn, r: bufio.NewReader(cliConn)}
+}
+
+// run отправляет строку и собирает ответ до кадра 'X'
+func (c *client) run(line string) (stdout, stderr string, status int) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, line+"\n"); err != nil {
+		c.t.Fatal(err)
+	}
+	var out, errOut bytes.Buffer
+	for {
+		var hdr [5]byte
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			c.t.Fatal(err)
+		}
+		data := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			c.t.Fatal(err)
+		}
+		switch hdr[0] {
+		case frameStdout:
+			out.Write(data)
+		case frameStderr:
+			errOut.Write(data)
+		case frameExit:
+			return out.String(), errOut.String(), int(int32(binary.BigEndian.Uint32(data)))
+		default:
+			c.t.Fatalf("unknown frame %q", hdr[0])
+		}
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	c := newClient(t)
+	tests := []struct {
+		line   string
+		stdout string
+		stderr string
+		status int
+	}{
+		{"echo hi", "hi\n", "", 0},
+		{"", "", "", 0},
+		{"sh -c 'echo out; echo err >&2; exit 3'", "out\n", "err\n", 3},
+		{"nosuchcmd", "", "nosuchcmd: command not found\n", 127},
+		{"cat < /nonexistent", "", "input file error: open /nonexistent: no such file or directory\n", 1},
+		{"exec /bin/true", "", "exec: replacing the shell is not allowed in a server session\n", 1},
+		{"type nosuch", "", "type: nosuch: not found\n", 1},
+	}
+	for _, tc := range tests {
+		stdout, stderr, status := c.run(tc.line)
+		if stdout != tc.stdout || stderr != tc.stderr || status != tc.status {
+			t.Errorf("%s: %q, %q, %d; want %q, %q, %d",
+				tc.line, stdout, stderr, status, tc.stdout, tc.stderr, tc.status)
+		}
+	}
+}
+
+func TestServeBackground(t *testing.T) {
+	c := newClient(t)
+	// без кадра 'X' чтение упадёт по дедлайну, а не повиснет
+	if err := c.conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// строку "[1] pid" фоновое задание пишет в свой поток, и она может
+	// прийти в ответе на любую из команд
+	started := regexp.MustCompile(`(?m)^\[\d+\] \d+\n`)
+	tests := []struct {
+		line   string
+		stdout string
+	}{
+		{"sleep 100 &", ""},
+		// внук держит пайпы вывода открытыми после выхода sh
+		{"sh -c 'echo started; sleep 10 &'", "started\n"},
+		{"echo after", "after\n"},
+	}
+	for _, tc := range tests {
+		stdout, stderr, status := c.run(tc.line)
+		if stdout = started.ReplaceAllString(stdout, ""); stdout != tc.stdout || status != 0 {
+			t.Errorf("%s: %q, %q, %d; want %q", tc.line, stdout, stderr, status, tc.stdout)
+		}
+	}
+}
+
+func TestSessionIsolation(t *testing.T) {
+	a, b := newClient(t), newClient(t)
+
+	sub := t.TempDir()
+	steps := []struct {
+		c      *client
+		line   string
+		stdout string
+	}{
+		{a, "export X=a", ""},
+		{b, "export X=b", ""},
+		{a, "echo $X", "a\n"},
+		{b, "echo $X", "b\n"},
+		{a, "cd " + sub, ""},
+		{a, "pwd", sub + "\n"},
+		{a, "exec > out.txt", ""},
+		{a, "echo hidden", ""},
+		{b, "echo shown", "shown\n"},
+	}
+	for _, st := range steps {
+		if stdout, stderr, status := st.c.run(st.line); stdout != st.stdout || status != 0 {
+			t.Errorf("%s: %q, %q, %d; want %q", st.line, stdout, stderr, status, st.stdout)
+		}
+	}
+	if stdout, _, _ := b.run("pwd"); stdout == sub+"\n" {
+		t.Error("cd в одном сеансе сменил каталог другого")
+	}
+
+	got, err := os.ReadFile(sub + "/out.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hidden\n" {
+		t.Errorf("out.txt = %q", got)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// session — состояние одного сеанса шелла: рабочий каталог, окружение,
+// потоки ввода-вывода, история, фоновые задания и кеш команд.
+// Интерактивный шелл — это один сеанс, в режиме --listen у каждого клиента свой
+type session struct {
+	dir string
+	env map[string]string
+
+	// stdin/stdout/stderr — «терминал» сеанса. В режиме сервера stdout и stderr
+	// подменяются пайпами на время каждой команды
+	stdin, stdout, stderr *os.File
+	// redirIn/redirOut — постоянные редиректы, заданные через exec в сеансе сервера
+	redirIn, redirOut *os.File
+	// jobStdout/jobStderr — куда пишут фоновые задания в сеансе сервера
+	// (nil — туда же, куда и обычные команды)
+	jobStdout, jobStderr *os.File
+	// interactive — сеанс владеет терминалом и дескрипторами 0/1/2 процесса
+	interactive bool
+	// tty — управляющий терминал (nil, если шелл не на терминале)
+	tty *terminal
+	// restrict — ограничения режима --restricted (nil — без ограничений)
+	restrict *restriction
+	// unshare — запускать внешние команды в отдельных namespace (--unshare)
+	unshare bool
+	// opts — флаги исполнителя, меняются builtin set
+	opts shellOptions
+	// subst — подстановки <(cmd) и >(cmd) выполняемой команды: их пути
+	// /dev/fd/N разрешены как цели редиректов и в режиме --restricted
+	subst *procSubst
+
+	history []string
+	// hist — структурированная история с каталогом, кодом и временем (nil — не ведётся)
+	hist   *histStore
+	exited bool
+
+	mu         sync.Mutex // защищает поля ниже
+	fgCancel   context.CancelFunc
+	jobs       []*job
+	cmdHash    map[string]*hashEntry
+	hashedPath string
+}
+
+// newSession создаёт сеанс с текущим каталогом и окружением процесса
+func newSession(stdin, stdout, stderr *os.File) (*session, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		dir:     dir,
+		env:     map[string]string{},
+		stdin:   stdin,
+		stdout:  stdout,
+		stderr:  stderr,
+		cmdHash: map[string]*hashEntry{},
+	}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			s.env[k] = v
+		}
+	}
+	return s, nil
+}
+
+// getenv возвращает переменную окружения сеанса ("" если её нет)
+func (s *session) getenv(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.env[name]
+}
+
+// setenv меняет переменную окружения сеанса
+func (s *session) setenv(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.env[name] = value
+}
+
+// unsetenv удаляет переменную окружения сеанса
+func (s *session) unsetenv(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.env, name)
+}
+
+// environ возвращает окружение сеанса в формате KEY=value для exec.Cmd.Env
+func (s *session) environ() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	env := make([]string, 0, len(s.env))
+	for k, v := range s.env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// path переводит путь, заданный относительно каталога сеанса, в абсолютный
+func (s *session) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.dir, name)
+}
+
+// in и out возвращают потоки сеанса с учётом постоянных редиректов exec
+func (s *session) in() *os.File {
+	if s.redirIn != nil {
+		return s.redirIn
+	}
+	return s.stdin
+}
+
+func (s *session) out() *os.File {
+	if s.redirOut != nil {
+		return s.redirOut
+	}
+	return s.stdout
+}
+
+// openInput открывает файл для редиректа <
+func (s *session) openInput(name string) (*os.File, error) {
+	if err := s.checkRedirect(s.path(name)); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path(name))
+	if err != nil {
+		return nil, fmt.Errorf("input file error: %v", err)
+	}
+	return f, nil
+}
+
+// openOutput открывает файл для редиректа > (или >>, если имя начинается с ">>")
+func (s *session) openOutput(name string) (*os.File, error) {
+	if err := s.checkRedirect(s.path(strings.TrimPrefix(name, ">>"))); err != nil {
+		return nil, err
+	}
+
+	var f *os.File
+	var err error
+	if strings.HasPrefix(name, ">>") {
+		f, err = os.OpenFile(s.path(name[2:]), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	} else {
+		f, err = os.Create(s.path(name))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("output file error: %v", err)
+	}
+	return f, nil
+}
+
+// close завершает сеанс: отменяет текущую команду, останавливает
+// фоновые задания и закрывает постоянные редиректы
+func (s *session) close() {
+	s.cancelForeground()
+
+	s.mu.Lock()
+	jobs := append([]*job(nil), s.jobs...)
+	s.mu.Unlock()
+	for _, j := range jobs {
+		for _, cmd := range j.cmds {
+			if cmd.Process != nil {
+				_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+			}
+		}
+	}
+
+	for _, f := range []*os.File{s.redirIn, s.redirOut} {
+		if f != nil {
+			_ = f.Close()
+		}
+	}
+	s.redirIn, s.redirOut = nil, nil
+}
+
+// shownError — ошибка, которую команда уже напечатала в stderr сеанса
+type shownError struct {
+	err error
+}
+
+func (e *shownError) Error() string { return e.err.Error() }
+func (e *shownError) Unwrap() error { return e.err }
+
+// printError печатает ошибку в stderr сеанса и возвращает её помеченной
+// как показанная, чтобы reportError не напечатал её второй раз
+func (s *session) printError(err error) error {
+	fmt.Fprintln(s.stderr, err)
+	return &shownError{err: err}
+}
+
+// reportError печатает ошибку команды в stderr сеанса, если её ещё никто
+// не показал. Ненулевой код завершения сообщением не считается: программа
+// сама пишет в stderr всё, что нужно
+func (s *session) reportError(err error) {
+	if err == nil {
+		return
+	}
+	var shown *shownError
+	var se *statusErr
+	var exitErr *exec.ExitError
+	if errors.As(err, &shown) || errors.As(err, &se) || errors.As(err, &exitErr) {
+		return
+	}
+	var execErr *exec.Error
+	if errors.As(err, &execErr) && errors.Is(err, exec.ErrNotFound) {
+		fmt.Fprintf(s.stderr, "%s: command not found\n", execErr.Name)
+		return
+	}
+	fmt.Fprintln(s.stderr, err)
+}
+
+// exitStatus переводит ошибку команды в код завершения как в sh:
+// 0 — успех, 127 — команда не найдена, 128+N — убита сигналом N
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var se *statusErr
+	if errors.As(err, &se) {
+		return se.code
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return 127
+	}
+	return 1
+}
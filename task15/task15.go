@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unicode"
 
 	"github.com/chzyer/readline"
@@ -25,6 +29,55 @@ type ConditionalCmd struct {
 }
 
 func main() {
+	listen := flag.String("listen", "", "serve isolated shell sessions on this unix socket")
+	restricted := flag.Bool("restricted", false, "restricted mode: no cd, no '/' in command names, fixed PATH")
+	allowDir := flag.String("allow-dir", ".", "directory allowed for redirects in restricted mode")
+	unshare := flag.Bool("unshare", false, "run external commands in new mount, pid and net namespaces")
+	histDB := flag.String("histdb", defaultHistDB(), "structured history file (JSON lines), empty disables it")
+	flag.Parse()
+
+	// sandbox применяет ограничения к каждому сеансу, в том числе к сеансам сервера
+	var restrict *restriction
+	if *restricted {
+		r, err := newRestriction(*allowDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "allow-dir error:", err)
+			os.Exit(1)
+		}
+		restrict = r
+	}
+	if *unshare {
+		if err := checkNamespaces(); err != nil {
+			fmt.Fprintln(os.Stderr, "unshare error:", err)
+			os.Exit(1)
+		}
+	}
+	sandbox := func(s *session) {
+		s.restrict = restrict
+		s.unshare = *unshare
+	}
+
+	if *listen != "" {
+		if err := serve(*listen, sandbox); err != nil {
+			fmt.Fprintln(os.Stderr, "server error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	sh, err := newSession(os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "session error:", err)
+		os.Exit(1)
+	}
+	sandbox(sh)
+	if *histDB != "" {
+		sh.hist = newHistStore(*histDB)
+	}
+	sh.interactive = true
+	sh.tty = openTerminal()
+	defer sh.tty.close()
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT)
 
@@ -38,24 +91,35 @@ func main() {
 					_ = syscall.Kill(-p.Pid, syscall.SIGINT)
 				}
 			}
+			sh.cancelForeground()
 			fmt.Println("\n[Ctrl+C] interrupted")
 		}
 	}()
 
+	// Ctrl+R ищет по структурированной истории вместо встроенного поиска readline
+	picker := &histPicker{s: sh, prompt: "> "}
 	rl, err := readline.NewEx(&readline.Config{
-		Prompt:          "> ",
-		HistoryFile:     "/tmp/shell_history.tmp", // сохраняет историю между сессиями
-		InterruptPrompt: "^C",
-		EOFPrompt:       "exit",
+		Prompt:              picker.prompt,
+		HistoryFile:         "/tmp/shell_history.tmp", // сохраняет историю между сессиями
+		InterruptPrompt:     "^C",
+		EOFPrompt:           "exit",
+		Listener:            readline.FuncListener(picker.onChange),
+		FuncFilterInputRune: picker.filter,
 	})
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "readline error:", err)
 		return
 	}
 	defer rl.Close()
+	picker.rl = rl
 
 	for {
+		sh.notifyJobs()
+		idle := sh.idleTimer(rl)
 		line, err := rl.Readline()
+		if idle != nil {
+			idle.Stop()
+		}
 		if err == readline.ErrInterrupt {
 			fmt.Println()
 			continue
@@ -69,15 +133,34 @@ func main() {
 			continue
 		}
 
-		runConditionals(line)
+		start, dir := time.Now(), sh.dir
+		err = sh.runConditionals(line)
+		sh.recordHistory(line, dir, start, err)
+		if sh.exited {
+			break
+		}
+	}
+
+	procMu.Lock()
+	for _, p := range currentProcesses {
+		if p != nil {
+			_ = syscall.Kill(-p.Pid, syscall.SIGTERM)
+		}
 	}
+	procMu.Unlock()
 }
 
 // runConditionals разбирает строку на команды с && и ||
-func runConditionals(line string) {
+// и возвращает ошибку последней выполненной команды
+func (s *session) runConditionals(line string) error {
+	s.history = append(s.history, line)
+	s.traceLine(line)
+
 	var cmds []ConditionalCmd
 	trimmed := strings.TrimSpace(line)
 
+	// Оператор относится к команде после него: в "a || b" b выполняется, только если a упала
+	prevOp := ""
 	i := 0
 	for i < len(trimmed) {
 		var end int
@@ -99,14 +182,19 @@ func runConditionals(line string) {
 
 		cmdStr := strings.TrimSpace(trimmed[i:end])
 		if cmdStr != "" {
-			cmds = append(cmds, ConditionalCmd{Cmd: cmdStr, Operator: op})
+			cmds = append(cmds, ConditionalCmd{Cmd: cmdStr, Operator: prevOp})
 		}
 
+		prevOp = op
 		i = end + len(op)
 	}
 
+	var lastErr error
 	prevSuccess := true
 	for _, c := range cmds {
+		if s.exited {
+			break
+		}
 		if c.Operator == "&&" && !prevSuccess {
 			prevSuccess = false
 			continue
@@ -116,35 +204,90 @@ func runConditionals(line string) {
 			continue
 		}
 
-		var err error
+		// Команда с & в конце уходит в фон
+		cmdStr := c.Cmd
+		background := strings.HasSuffix(cmdStr, "&")
+		if background {
+			cmdStr = strings.TrimSpace(strings.TrimSuffix(cmdStr, "&"))
+		}
+
+		// <(cmd) и >(cmd) запускаются до разбора пайплайна,
+		// в строке вместо них остаются пути /dev/fd/N
+		cmdStr, subst, err := s.expandProcSubst(cmdStr)
+		s.subst = subst
+		if err != nil {
+			fmt.Fprintln(s.stderr, err)
+			lastErr = err
+			prevSuccess = false
+			continue
+		}
+
+		// set -n: только проверяем разбор. Сам set выполняется, чтобы можно было сделать set +n
+		if s.opts.noexec {
+			err = checkSyntax(cmdStr)
+			if err != nil {
+				fmt.Fprintln(s.stderr, err)
+			}
+			fields := splitFieldsRespectingQuotes(cmdStr)
+			if err != nil || background || strings.Contains(cmdStr, "|") ||
+				len(fields) == 0 || fields[0] != "set" {
+				lastErr = err
+				prevSuccess = (err == nil)
+				continue
+			}
+		}
+
+		if background {
+			err = s.startJob(cmdStr, subst)
+			if err != nil {
+				fmt.Fprintln(s.stderr, err)
+			}
+			lastErr = err
+			prevSuccess = (err == nil)
+			continue
+		}
+
+		// Ctrl+C отменяет контекст текущей команды
+		ctx, done := s.foregroundContext()
+
 		// Если есть пайплайн
-		if strings.Contains(c.Cmd, "|") {
-			err = pipeLine(c.Cmd)
+		if strings.Contains(cmdStr, "|") {
+			err = s.pipeLine(ctx, cmdStr, subst)
 		} else {
-			fields := splitFieldsRespectingQuotes(c.Cmd)
+			fields := splitFieldsRespectingQuotes(cmdStr)
 			if len(fields) == 0 {
+				done()
+				subst.finish()
 				continue
 			}
 
-			fields = expandEnvVars(fields)
+			fields = s.expandEnvVars(fields)
 			fields, stdinFile, stdoutFile := handleRedirection(fields)
+			s.trace(fields)
 
 			if isBuiltin(fields[0]) {
-				err = runBuiltin(fields, stdinFile, stdoutFile)
+				err = s.runBuiltin(ctx, fields, stdinFile, stdoutFile, subst)
 			} else {
-				err = runExternal(fields, stdinFile, stdoutFile)
+				err = s.runExternal(ctx, fields, stdinFile, stdoutFile, subst)
 			}
 
 		}
+		done()
+		subst.finish()
+		s.reportError(err)
 
+		lastErr = err
 		prevSuccess = (err == nil)
 	}
+	return lastErr
 }
 
 // isBuiltin проверяет, является ли команда встроенной (builtin),
 func isBuiltin(cmd string) bool {
 	switch cmd {
-	case "cd", "pwd", "exit", "help", "echo", "kill", "ps":
+	case "cd", "pwd", "exit", "help", "echo", "kill", "ps",
+		"type", "which", "command", "hash", "exec", "wait", "jobs",
+		"timeout", "export", "unset", "history", "hist", "set":
 		return true
 	default:
 		return false
@@ -152,27 +295,35 @@ func isBuiltin(cmd string) bool {
 }
 
 // runBuiltin — обработка встроенных команд вроде cd, pwd, echo и т.д.
-func runBuiltin(fields []string, stdinFile, stdoutFile string) error {
+func (s *session) runBuiltin(ctx context.Context, fields []string, stdinFile, stdoutFile string, subst *procSubst) error {
 	var output string
 	var err error
 
 	switch fields[0] {
 	case "cd":
+		if s.restrict != nil {
+			return s.restrictedError("cd: not allowed")
+		}
 		if len(fields) < 2 {
-			home := os.Getenv("HOME")
+			home := s.getenv("HOME")
 			if home == "" {
 				return fmt.Errorf("cd: missing argument")
 			}
 			fields = append(fields, home)
 		}
-		err = os.Chdir(fields[1])
-
-	case "pwd":
-		dir, e := os.Getwd()
+		// каталог хранится в сеансе: у каждого клиента сервера он свой
+		dir := s.path(fields[1])
+		info, e := os.Stat(dir)
 		if e != nil {
-			return e
+			return fmt.Errorf("cd: %v", e)
 		}
-		output = dir
+		if !info.IsDir() {
+			return fmt.Errorf("cd: %s: not a directory", fields[1])
+		}
+		s.dir = filepath.Clean(dir)
+
+	case "pwd":
+		output = s.dir
 
 	case "echo":
 		if len(fields) > 1 {
@@ -180,12 +331,88 @@ func runBuiltin(fields []string, stdinFile, stdoutFile string) error {
 		}
 
 	case "help":
-		output = "Builtins: cd <path>, pwd, echo <args>, kill <pid>, ps, exit, help"
+		output = "Builtins: cd <path>, pwd, echo <args>, kill <pid>, ps, exit, help,\n" +
+			"  type <name>, which <name>, command [-v] <name> [args], hash [-r] [name],\n" +
+			"  exec [cmd [args]] [redirects], wait [pid|%job], jobs; <cmd> & runs in background,\n" +
+			"  timeout <duration> <cmd> [args]; TMOUT=<sec> logs out an idle shell,\n" +
+			"  export NAME=value, unset NAME, history,\n" +
+			"  hist search [-dir DIR] [-status N|ok|fail] [-since T] [-until T] [-n N] [text];\n" +
+			"  Ctrl+R picks from history, most frequent and recent in this directory first,\n" +
+			"  set [-+xnv] [-+o name]: -x traces commands, -n only parses, -v echoes input"
+
+	case "export":
+		for _, kv := range fields[1:] {
+			name, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				continue
+			}
+			if e := s.checkVar(name); e != nil {
+				return e
+			}
+			s.setenv(name, value)
+		}
+
+	case "unset":
+		for _, name := range fields[1:] {
+			if e := s.checkVar(name); e != nil {
+				return e
+			}
+			s.unsetenv(name)
+		}
+
+	case "set":
+		output, err = s.runSet(fields[1:])
+
+	case "hist":
+		output, err = s.runHist(fields[1:])
+
+	case "history":
+		lines := make([]string, len(s.history))
+		for i, h := range s.history {
+			lines[i] = fmt.Sprintf("%5d  %s", i+1, h)
+		}
+		output = strings.Join(lines, "\n")
+
+	case "type":
+		output, err = s.runType(fields[1:])
+
+	case "which":
+		output, err = s.runWhich(fields[1:])
+
+	case "command":
+		// функций и алиасов в шелле нет, поэтому command лишь
+		// выбирает между builtin и внешней программой
+		if len(fields) < 2 {
+			return nil
+		}
+		if fields[1] == "-v" {
+			output, err = s.runCommandV(fields[2:])
+			break
+		}
+		if isBuiltin(fields[1]) {
+			return s.runBuiltin(ctx, fields[1:], stdinFile, stdoutFile, subst)
+		}
+		return s.runExternal(ctx, fields[1:], stdinFile, stdoutFile, subst)
+
+	case "hash":
+		output, err = s.runHash(fields[1:])
+
+	case "exec":
+		return s.runExec(fields[1:], stdinFile, stdoutFile, subst)
+
+	case "wait":
+		err = s.runWait(ctx, fields[1:])
+
+	case "timeout":
+		return s.runTimeout(ctx, fields[1:], stdinFile, stdoutFile, subst)
+
+	case "jobs":
+		output = s.runJobs()
 
 	case "ps":
 		cmd := exec.Command("ps", "aux")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = s.out()
+		cmd.Stderr = s.stderr
 		return cmd.Run()
 
 	case "kill":
@@ -199,37 +426,29 @@ func runBuiltin(fields []string, stdinFile, stdoutFile string) error {
 		return syscall.Kill(pid, syscall.SIGTERM)
 
 	case "exit":
-		procMu.Lock()
-		for _, p := range currentProcesses {
-			if p != nil {
-				_ = syscall.Kill(-p.Pid, syscall.SIGTERM)
-			}
-		}
-		procMu.Unlock()
-		os.Exit(0)
+		// сеанс завершает тот, кто его запустил: main или сервер
+		s.exited = true
+		return nil
 	}
 
 	// Если есть редирект — записываем в файл
 	if stdoutFile != "" {
-		var f *os.File
-		if strings.HasPrefix(stdoutFile, ">>") {
-			f, err = os.OpenFile(stdoutFile[2:], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		} else {
-			f, err = os.Create(stdoutFile)
-		}
-		if err != nil {
-			return err
+		f, ferr := s.openOutput(stdoutFile)
+		if ferr != nil {
+			return ferr
 		}
 		defer f.Close()
 		if output != "" {
-			_, err = fmt.Fprintln(f, output)
+			if _, ferr = fmt.Fprintln(f, output); ferr != nil {
+				return ferr
+			}
 		}
 		return err
 	}
 
 	// иначе просто выводим на экран
 	if output != "" {
-		fmt.Println(output)
+		fmt.Fprintln(s.out(), output)
 	}
 
 	return err
@@ -237,58 +456,67 @@ func runBuiltin(fields []string, stdinFile, stdoutFile string) error {
 
 // runExternal выполняет внешнюю команду (не builtin).
 // Поддерживает перенаправление ввода (<) и вывода (> и >>),
-// добавляет процесс в список currentProcesses для обработки Ctrl+C (SIGINT)
-func runExternal(fields []string, stdinFile, stdoutFile string) error {
+// добавляет процесс в список currentProcesses для обработки Ctrl+C (SIGINT).
+// При отмене ctx группа процесса завершается через SIGTERM, затем SIGKILL
+func (s *session) runExternal(ctx context.Context, fields []string, stdinFile, stdoutFile string, subst *procSubst) error {
 	if len(fields) == 0 {
 		return nil
 	}
 
-	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd := s.newCommand(fields)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	s.tty.foreground(cmd.SysProcAttr)
+	if s.unshare {
+		namespaceAttr(cmd.SysProcAttr)
+	}
+	subst.attach(cmd)
 
 	// stdin
 	if stdinFile != "" {
-		inFile, err := os.Open(stdinFile)
+		inFile, err := s.openInput(stdinFile)
 		if err != nil {
-			return fmt.Errorf("input file error: %v", err)
+			return err
 		}
 		defer inFile.Close()
 		cmd.Stdin = inFile
 	} else {
-		cmd.Stdin = os.Stdin
+		cmd.Stdin = s.in()
 	}
 
 	// stdout
 	if stdoutFile != "" {
-		var outFile *os.File
-		var err error
-		if strings.HasPrefix(stdoutFile, ">>") {
-			outFile, err = os.OpenFile(stdoutFile[2:], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		} else {
-			outFile, err = os.Create(stdoutFile)
-		}
+		outFile, err := s.openOutput(stdoutFile)
 		if err != nil {
-			return fmt.Errorf("output file error: %v", err)
+			return err
 		}
 		defer outFile.Close()
 		cmd.Stdout = outFile
 	} else {
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = s.out()
 	}
 
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = s.stderr
 
 	if err := cmd.Start(); err != nil {
+		s.tty.reclaim(nil)
 		return err
 	}
 
 	addCurrentProcess(cmd.Process)
 	defer removeCurrentProcess(cmd.Process)
 
-	if err := cmd.Wait(); err != nil {
+	stop := killOnCancel(ctx, cmd.Process.Pid)
+	defer stop()
+
+	err := cmd.Wait()
+	s.tty.reclaim(err)
+	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				return fmt.Errorf("process exited with code %d", status.ExitStatus())
+				if status.Signaled() {
+					return statusError(128 + int(status.Signal()))
+				}
+				return statusError(status.ExitStatus())
 			}
 		}
 		return err
@@ -298,14 +526,45 @@ func runExternal(fields []string, stdinFile, stdoutFile string) error {
 }
 
 // pipeLine принимает строку вида "ps | grep foo | wc -l".
-func pipeLine(line string) error {
+// При отмене ctx завершается группа процессов пайплайна
+func (s *session) pipeLine(ctx context.Context, line string, subst *procSubst) error {
+	cmds, err := s.startPipeline(line, s.in(), s.out(), s.stderr, true, subst)
+	if err != nil {
+		s.tty.reclaim(nil)
+		return err
+	}
+
+	stop := killOnCancel(ctx, cmds[0].Process.Pid)
+	defer stop()
+
+	// 🔹 Ожидаем завершения всех команд пайплайна
+	var lastErr error
+	for _, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			lastErr = err
+		}
+		removeCurrentProcess(cmd.Process)
+	}
+	s.tty.reclaim(lastErr)
+
+	return lastErr
+
+}
+
+// startPipeline настраивает и запускает команды пайплайна.
+// stdin и stdout достаются первой и последней команде, если у них нет редиректа,
+// stderr — всем командам. Все команды попадают в одну группу процессов во главе
+// с первой; при foreground эта группа получает терминал интерактивного шелла.
+// Запущенные процессы добавляются в currentProcesses, ждать их должен вызывающий
+func (s *session) startPipeline(line string, stdin, stdout, stderr *os.File, foreground bool, subst *procSubst) ([]*exec.Cmd, error) {
 	parts := strings.Split(line, "|")
 	numCmds := len(parts)
 	if numCmds == 0 {
-		return nil
+		return nil, nil
 	}
 
 	cmds := make([]*exec.Cmd, numCmds)
+	stages := make([][]string, numCmds)
 	pipes := make([][2]*os.File, numCmds-1)
 	var closers []*os.File
 
@@ -313,7 +572,7 @@ func pipeLine(line string) error {
 	for i := 0; i < numCmds-1; i++ {
 		r, w, err := os.Pipe()
 		if err != nil {
-			return fmt.Errorf("pipe error: %v", err)
+			return nil, fmt.Errorf("pipe error: %v", err)
 		}
 		pipes[i] = [2]*os.File{r, w}
 	}
@@ -322,27 +581,29 @@ func pipeLine(line string) error {
 	for i, part := range parts {
 		fields := splitFieldsRespectingQuotes(strings.TrimSpace(part))
 		if len(fields) == 0 {
-			return fmt.Errorf("empty command in pipeline")
+			return nil, fmt.Errorf("empty command in pipeline")
 		}
 
-		fields = expandEnvVars(fields)
+		fields = s.expandEnvVars(fields)
 		fields, stdinFile, stdoutFile := handleRedirection(fields)
+		stages[i] = fields
 
-		cmd := exec.Command(fields[0], fields[1:]...)
+		cmd := s.newCommand(fields)
 		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-		cmd.Stderr = os.Stderr
+		cmd.Stderr = stderr
+		subst.attach(cmd)
 
 		// 🔹 stdin для первой команды
 		if i == 0 {
 			if stdinFile != "" {
-				in, err := os.Open(stdinFile)
+				in, err := s.openInput(stdinFile)
 				if err != nil {
-					return fmt.Errorf("input file error: %v", err)
+					return nil, err
 				}
 				cmd.Stdin = in
 				closers = append(closers, in)
 			} else {
-				cmd.Stdin = os.Stdin
+				cmd.Stdin = stdin
 			}
 		} else {
 			cmd.Stdin = pipes[i-1][0]
@@ -351,20 +612,14 @@ func pipeLine(line string) error {
 		// 🔹 stdout для последней команды
 		if i == numCmds-1 {
 			if stdoutFile != "" {
-				var out *os.File
-				var err error
-				if strings.HasPrefix(stdoutFile, ">>") {
-					out, err = os.OpenFile(stdoutFile[2:], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-				} else {
-					out, err = os.Create(stdoutFile)
-				}
+				out, err := s.openOutput(stdoutFile)
 				if err != nil {
-					return fmt.Errorf("output file error: %v", err)
+					return nil, err
 				}
 				cmd.Stdout = out
 				closers = append(closers, out)
 			} else {
-				cmd.Stdout = os.Stdout
+				cmd.Stdout = stdout
 			}
 		} else {
 			cmd.Stdout = pipes[i][1]
@@ -373,10 +628,18 @@ func pipeLine(line string) error {
 		cmds[i] = cmd
 	}
 
+	s.tracePipeline(stages)
+
 	// 🔹 Запускаем команды с откатом при ошибке
 	started := []*os.Process{}
 
-	for _, cmd := range cmds {
+	for i, cmd := range cmds {
+		if i == 0 && foreground {
+			s.tty.foreground(cmd.SysProcAttr)
+		}
+		if i > 0 {
+			cmd.SysProcAttr.Pgid = cmds[0].Process.Pid
+		}
 		if err := cmd.Start(); err != nil {
 			// Останавливаем уже запущенные процессы
 			for _, p := range started {
@@ -385,7 +648,7 @@ func pipeLine(line string) error {
 					removeCurrentProcess(p)
 				}
 			}
-			return err
+			return nil, err
 		}
 		addCurrentProcess(cmd.Process)
 		started = append(started, cmd.Process)
@@ -404,17 +667,7 @@ func pipeLine(line string) error {
 		}
 	}
 
-	// 🔹 Ожидаем завершения всех команд пайплайна
-	var lastErr error
-	for _, cmd := range cmds {
-		if err := cmd.Wait(); err != nil {
-			lastErr = err
-		}
-		removeCurrentProcess(cmd.Process)
-	}
-
-	return lastErr
-
+	return cmds, nil
 }
 
 // addCurrentProcess сохраняет процесс, чтобы потом можно было его убить при Ctrl+C.
@@ -484,11 +737,11 @@ func splitFieldsRespectingQuotes(s string) []string {
 	return res
 }
 
-// expandEnvVars подставляет значения переменных окружения в аргументы.
-func expandEnvVars(fields []string) []string {
+// expandEnvVars подставляет значения переменных окружения сеанса в аргументы.
+func (s *session) expandEnvVars(fields []string) []string {
 	for i, f := range fields {
 		if strings.HasPrefix(f, "$") && len(f) > 1 && !strings.ContainsAny(f, "\"'") {
-			val := os.Getenv(f[1:])
+			val := s.getenv(f[1:])
 			fields[i] = val // если переменной нет, вернёт ""
 			continue
 		}
@@ -508,7 +761,7 @@ func expandEnvVars(fields []string) []string {
 					}
 					if k > j+1 {
 						varName := f[j+1 : k]
-						val := os.Getenv(varName)
+						val := s.getenv(varName)
 						out.WriteString(val) // если нет — просто ""
 						j = k
 						continue
@@ -547,6 +800,7 @@ func handleRedirection(fields []string) (cmdFields []string, stdinFile, stdoutFi
 }
 
 // indexOutsideQuotes ищет подстроку sub в s, игнорируя вхождения внутри кавычек
+// и подстановок <(...) и >(...)
 func indexOutsideQuotes(s, sub string) int {
 	inSingle := false
 	inDouble := false
@@ -568,6 +822,13 @@ func indexOutsideQuotes(s, sub string) int {
 		if inSingle || inDouble {
 			continue
 		}
+		// подстановка процесса: её && и || относятся к внутренней команде
+		if (c == '<' || c == '>') && i+1 < len(s) && s[i+1] == '(' {
+			if end := matchParen(s, i+1); end != -1 {
+				i = end
+				continue
+			}
+		}
 		if s[i:i+len(sub)] == sub {
 			return i
 		}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSession — неинтерактивный сеанс в каталоге t.TempDir() со stdin
+// из /dev/null и PATH из одного каталога bin внутри него
+func testSession(t *testing.T) *session {
+	t.Helper()
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = devNull.Close() })
+
+	s, err := newSession(devNull, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.dir = t.TempDir()
+	if err := os.Mkdir(filepath.Join(s.dir, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s.setenv("PATH", filepath.Join(s.dir, "bin"))
+	t.Cleanup(s.close)
+	return s
+}
+
+// runLine выполняет строку в сеансе и возвращает её stdout, stderr и ошибку
+func runLine(t *testing.T, s *session, line string) (stdout, stderr string, err error) {
+	t.Helper()
+	outF, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errF, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outF.Close()
+	defer errF.Close()
+
+	s.stdout, s.stderr = outF, errF
+	err = s.runConditionals(line)
+	s.stdout, s.stderr = nil, nil
+	return readAll(t, outF), readAll(t, errF), err
+}
+
+func readAll(t *testing.T, f *os.File) string {
+	t.Helper()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// writeScript кладёт исполняемый sh-скрипт name в dir и возвращает его путь
+func writeScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
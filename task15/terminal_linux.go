@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// terminal — управляющий терминал интерактивного шелла.
+// Шелл отдаёт терминал группе процессов foreground-команды (tcsetpgrp),
+// а после её завершения забирает обратно и следит за настройками termios
+type terminal struct {
+	file  *os.File // /dev/tty; держим открытым, пока жив шелл
+	fd    int
+	pgrp  int
+	saved *unix.Termios // настройки терминала, которые шелл считает своими
+}
+
+// openTerminal открывает /dev/tty, если шелл запущен на терминале
+// и его группа процессов сейчас в foreground. Иначе возвращает nil:
+// управление терминалом тогда не нужно (ввод из файла, сервер, фоновый запуск)
+func openTerminal() *terminal {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil
+	}
+	fd := int(f.Fd())
+
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		_ = f.Close()
+		return nil
+	}
+	pgrp := unix.Getpgrp()
+	if fg, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP); err != nil || fg != pgrp {
+		_ = f.Close()
+		return nil
+	}
+
+	// Шелл и его дети вызывают tcsetpgrp из фоновой группы,
+	// без этого ядро остановит их сигналом SIGTTOU
+	signal.Ignore(syscall.SIGTTOU)
+
+	return &terminal{file: f, fd: fd, pgrp: pgrp, saved: termios}
+}
+
+// foreground настраивает запуск так, чтобы новая группа процессов сразу
+// получила терминал. tcsetpgrp делает сам ребёнок до exec, поэтому
+// программа не успеет прочитать терминал из фона и получить SIGTTIN
+func (t *terminal) foreground(attr *syscall.SysProcAttr) {
+	if t == nil {
+		return
+	}
+	attr.Foreground = true
+	attr.Ctty = t.fd
+}
+
+// reclaim возвращает терминал шеллу после foreground-команды.
+// Если команда упала по сигналу, она могла оставить терминал в raw-режиме —
+// тогда восстанавливаем сохранённые настройки. Если завершилась сама,
+// её изменения (например, stty) становятся новыми настройками шелла
+func (t *terminal) reclaim(err error) {
+	if t == nil {
+		return
+	}
+	_ = unix.IoctlSetPointerInt(t.fd, unix.TIOCSPGRP, t.pgrp)
+
+	if exitStatus(err) > 128 {
+		_ = unix.IoctlSetTermios(t.fd, unix.TCSETSW, t.saved)
+		return
+	}
+	if termios, e := unix.IoctlGetTermios(t.fd, unix.TCGETS); e == nil {
+		t.saved = termios
+	}
+}
+
+// close восстанавливает настройки терминала при выходе из шелла
+func (t *terminal) close() {
+	if t == nil {
+		return
+	}
+	_ = unix.IoctlSetTermios(t.fd, unix.TCSETSW, t.saved)
+	_ = t.file.Close()
+}
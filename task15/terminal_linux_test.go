@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY открывает новую пару псевдотерминалов и возвращает её ведомую сторону
+func openPTY(t *testing.T) *os.File {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skip("no pty:", err)
+	}
+	t.Cleanup(func() { _ = master.Close() })
+	mfd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(mfd, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetUint32(mfd, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = slave.Close() })
+	return slave
+}
+
+func TestTerminalReclaim(t *testing.T) {
+	f := openPTY(t)
+	fd := int(f.Fd())
+	saved, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tty := &terminal{file: f, fd: fd, pgrp: unix.Getpgrp(), saved: saved}
+
+	echo := func() bool {
+		t.Helper()
+		tio, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tio.Lflag&unix.ECHO != 0
+	}
+	// rawMode делает то же, что программа, переводящая терминал в raw-режим
+	rawMode := func() {
+		t.Helper()
+		tio, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tio.Lflag &^= unix.ECHO | unix.ICANON
+		if err := unix.IoctlSetTermios(fd, unix.TCSETS, tio); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !echo() {
+		t.Fatal("новый pty без ECHO")
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		echo bool
+	}{
+		{"убита сигналом", statusError(128 + int(syscall.SIGSEGV)), true},
+		{"ненулевой код", statusError(1), false},
+		{"успех", nil, false},
+	}
+	for _, tc := range tests {
+		if err := unix.IoctlSetTermios(fd, unix.TCSETS, saved); err != nil {
+			t.Fatal(err)
+		}
+		tty.saved = saved
+		rawMode()
+		tty.reclaim(tc.err)
+		if echo() != tc.echo {
+			t.Errorf("%s: ECHO %v, want %v", tc.name, echo(), tc.echo)
+		}
+		if got := tty.saved.Lflag&unix.ECHO != 0; got != tc.echo {
+			t.Errorf("%s: в saved ECHO %v, want %v", tc.name, got, tc.echo)
+		}
+	}
+}
+
+func TestNilTerminal(t *testing.T) {
+	var tty *terminal
+	attr := &syscall.SysProcAttr{}
+	tty.foreground(attr)
+	tty.reclaim(nil)
+	tty.close()
+	if attr.Foreground {
+		t.Error("без терминала foreground не должен включаться")
+	}
+
+	tty = &terminal{fd: 7}
+	tty.foreground(attr)
+	if !attr.Foreground || attr.Ctty != 7 {
+		t.Errorf("foreground: %+v", attr)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// terminal — заглушка для систем, кроме Linux: управление терминалом
+// (tcsetpgrp, termios) не поддерживается, шелл работает как без терминала
+type terminal struct{}
+
+func openTerminal() *terminal                       { return nil }
+func (t *terminal) foreground(*syscall.SysProcAttr) {}
+func (t *terminal) reclaim(error)                   {}
+func (t *terminal) close()                          {}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+// killGrace — сколько ждать после SIGTERM, прежде чем добить группу SIGKILL
+const killGrace = 2 * time.Second
+
+// timeoutStatus — код завершения команды, прерванной по таймауту (как у GNU timeout)
+const timeoutStatus = 124
+
+// foregroundContext создаёт контекст для очередной команды строки.
+// Его отменяет cancelForeground (Ctrl+C). Возвращённую функцию нужно
+// вызвать, когда команда завершилась
+func (s *session) foregroundContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.fgCancel = cancel
+	s.mu.Unlock()
+	return ctx, func() {
+		s.mu.Lock()
+		s.fgCancel = nil
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancelForeground отменяет текущую foreground-команду сеанса, если она есть
+func (s *session) cancelForeground() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fgCancel != nil {
+		s.fgCancel()
+	}
+}
+
+// interruptKey — ключ контекста с флагом, который ставит тот, кого отмена
+// контекста действительно прервала (см. markInterrupted)
+type interruptKey struct{}
+
+// interruptFlag — флаг прерывания одного timeout. parent — флаг объемлющего
+// timeout: прерванная команда прервала и его
+type interruptFlag struct {
+	atomic.Bool
+	parent *interruptFlag
+}
+
+// withInterruptFlag возвращает контекст, в котором markInterrupted
+// отметит прерванную команду в возвращённом флаге
+func withInterruptFlag(ctx context.Context) (context.Context, *interruptFlag) {
+	parent, _ := ctx.Value(interruptKey{}).(*interruptFlag)
+	flag := &interruptFlag{parent: parent}
+	return context.WithValue(ctx, interruptKey{}, flag), flag
+}
+
+// markInterrupted отмечает, что отмена ctx прервала команду: процессам
+// отправлен сигнал или builtin перестал ждать. Команда, успевшая
+// завершиться сама, флаг не ставит, даже если дедлайн уже прошёл
+func markInterrupted(ctx context.Context) {
+	flag, _ := ctx.Value(interruptKey{}).(*interruptFlag)
+	for ; flag != nil; flag = flag.parent {
+		flag.Store(true)
+	}
+}
+
+// killOnCancel следит за ctx и при его отмене завершает группы процессов:
+// сначала SIGTERM, а через killGrace — SIGKILL. Возвращённую функцию
+// нужно вызвать после Wait, чтобы остановить слежение
+func killOnCancel(ctx context.Context, pgids ...int) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+		markInterrupted(ctx)
+		for _, pgid := range pgids {
+			_ = syscall.Kill(-pgid, syscall.SIGTERM)
+		}
+
+		t := time.NewTimer(killGrace)
+		defer t.Stop()
+		select {
+		case <-done:
+		case <-t.C:
+			for _, pgid := range pgids {
+				_ = syscall.Kill(-pgid, syscall.SIGKILL)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// statusErr — ошибка с кодом завершения команды
+type statusErr struct {
+	code int
+}
+
+func (e *statusErr) Error() string {
+	return fmt.Sprintf("process exited with code %d", e.code)
+}
+
+// statusError — ошибка с кодом завершения, как её возвращает runExternal
+func statusError(code int) error {
+	return &statusErr{code: code}
+}
+
+// parseDuration разбирает длительность для timeout: число секунд
+// (GNU-стиль, допускаются суффиксы s/m/h) или формат time.ParseDuration
+func parseDuration(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid time interval '%s'", s)
+	}
+	return d, nil
+}
+
+// runTimeout — builtin timeout DURATION cmd [args]: выполняет команду
+// с дедлайном и возвращает статус 124, если по дедлайну она была прервана.
+// Команда, завершившаяся сама (пусть и после дедлайна), возвращает свой статус
+func (s *session) runTimeout(ctx context.Context, args []string, stdinFile, stdoutFile string, subst *procSubst) error {
+	if len(args) < 2 {
+		return fmt.Errorf("timeout: missing operand")
+	}
+	d, err := parseDuration(args[0])
+	if err != nil {
+		return s.printError(fmt.Errorf("timeout: %v", err))
+	}
+
+	tctx, interrupted := withInterruptFlag(ctx)
+	var cancel context.CancelFunc = func() {}
+	// нулевая длительность, как у GNU timeout, отключает ограничение
+	if d > 0 {
+		tctx, cancel = context.WithTimeout(tctx, d)
+	}
+	defer cancel()
+
+	fields := args[1:]
+	if isBuiltin(fields[0]) {
+		err = s.runBuiltin(tctx, fields, stdinFile, stdoutFile, subst)
+	} else {
+		err = s.runExternal(tctx, fields, stdinFile, stdoutFile, subst)
+	}
+
+	if err != nil && interrupted.Load() && ctx.Err() == nil {
+		return statusError(timeoutStatus)
+	}
+	return err
+}
+
+// idleTimer закрывает readline, если за TMOUT секунд не введено ни строки.
+// Readline в этом случае вернёт io.EOF, и шелл завершится как по Ctrl+D
+func (s *session) idleTimer(rl *readline.Instance) *time.Timer {
+	secs, err := strconv.Atoi(s.getenv("TMOUT"))
+	if err != nil || secs <= 0 {
+		return nil
+	}
+	return time.AfterFunc(time.Duration(secs)*time.Second, func() {
+		fmt.Fprintln(s.stderr, "\ntimed out waiting for input: auto-logout")
+		_ = rl.Close()
+	})
+}
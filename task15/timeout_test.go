@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"5", 5 * time.Second, true},
+		{"0", 0, true},
+		{"0.25", 250 * time.Millisecond, true},
+		{"1.5s", 1500 * time.Millisecond, true},
+		{"2m", 2 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"-1", 0, false},
+		{"-1s", 0, false},
+		{"", 0, false},
+		{"abc", 0, false},
+		{"5x", 0, false},
+	}
+	for _, tc := range tests {
+		got, err := parseDuration(tc.in)
+		if got != tc.want || (err == nil) != tc.ok {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v, ok %v", tc.in, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := testSession(t)
+	s.setenv("PATH", os.Getenv("PATH"))
+
+	tests := []struct {
+		line   string
+		stdout string
+		status int
+	}{
+		{"timeout 5 echo hi", "hi\n", 0},
+		{"timeout 0.2 sleep 10", "", timeoutStatus},
+		{"timeout 0 true", "", 0},
+		{"timeout 5 false", "", 1},
+		{"timeout 0.2 sleep 10 || echo late", "late\n", 0},
+		{"timeout 0.2 sleep 10 && echo late", "", timeoutStatus},
+		{"timeout 0.2 sh -c 'sleep 10; echo late'", "", timeoutStatus},
+		{"timeout 0.2 timeout 5 sleep 10", "", timeoutStatus},
+		// сама завершилась на SIGTERM с кодом 0 — статус её, а не 124
+		{`timeout 0.2 sh -c 'trap "echo term; exit 0" TERM; sleep 10 & wait'`, "term\n", 0},
+		{"timeout x true", "", 1},
+		{"timeout 5", "", 1},
+	}
+	for _, tc := range tests {
+		start := time.Now()
+		stdout, _, err := runLine(t, s, tc.line)
+		if stdout != tc.stdout || exitStatus(err) != tc.status {
+			t.Errorf("%s: stdout %q, status %d; want %q, %d", tc.line, stdout, exitStatus(err), tc.stdout, tc.status)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%s: шла %v", tc.line, d)
+		}
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	s := testSession(t)
+	s.setenv("PATH", os.Getenv("PATH"))
+
+	tests := []struct {
+		line   string
+		stdout string
+		status int
+	}{
+		{"true && echo a", "a\n", 0},
+		{"false && echo a", "", 1},
+		{"false || echo b", "b\n", 0},
+		{"true || echo b", "", 0},
+		{"false && echo a || echo b", "b\n", 0},
+		{"true || echo a && echo b", "b\n", 0},
+		{"false || false || echo c", "c\n", 0},
+		{"true && false && echo a", "", 1},
+		{"echo 'x && y'", "x && y\n", 0},
+	}
+	for _, tc := range tests {
+		stdout, _, err := runLine(t, s, tc.line)
+		if stdout != tc.stdout || exitStatus(err) != tc.status {
+			t.Errorf("%s: stdout %q, status %d; want %q, %d", tc.line, stdout, exitStatus(err), tc.stdout, tc.status)
+		}
+	}
+}
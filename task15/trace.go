@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// shellOptions — флаги исполнителя, которые меняет builtin set
+type shellOptions struct {
+	xtrace  bool // set -x: печатать команды после подстановок перед запуском
+	noexec  bool // set -n: только разбирать строки, ничего не запуская
+	verbose bool // set -v: печатать входные строки как есть
+}
+
+// optionNames — имена флагов для set -o/+o в порядке вывода
+var optionNames = []string{"noexec", "verbose", "xtrace"}
+
+// flag возвращает указатель на флаг по имени или букве
+func (o *shellOptions) flag(name string) *bool {
+	switch name {
+	case "xtrace", "x":
+		return &o.xtrace
+	case "noexec", "n":
+		return &o.noexec
+	case "verbose", "v":
+		return &o.verbose
+	}
+	return nil
+}
+
+// runSet — builtin set: -x/-n/-v включают флаги, +x/+n/+v выключают,
+// -o name и +o name делают то же по полному имени, без аргументов
+// (или с одним -o) выводится состояние всех флагов.
+// В режиме -n set всё равно выполняется, иначе из него не выйти
+func (s *session) runSet(args []string) (string, error) {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-o") {
+		lines := make([]string, len(optionNames))
+		for i, name := range optionNames {
+			state := "off"
+			if *s.opts.flag(name) {
+				state = "on"
+			}
+			lines[i] = fmt.Sprintf("%-8s %s", name, state)
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	fail := func(format string, a ...any) (string, error) {
+		return "", s.printError(fmt.Errorf("set: "+format, a...))
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			return fail("%s: invalid option", arg)
+		}
+		on := arg[0] == '-'
+
+		if arg[1:] == "o" {
+			if i+1 >= len(args) {
+				return fail("%s: option name required", arg)
+			}
+			i++
+			f := s.opts.flag(args[i])
+			if f == nil || len(args[i]) == 1 {
+				return fail("%s: invalid option name", args[i])
+			}
+			*f = on
+			continue
+		}
+
+		for _, c := range arg[1:] {
+			f := s.opts.flag(string(c))
+			if f == nil {
+				return fail("%c%c: invalid option", arg[0], c)
+			}
+			*f = on
+		}
+	}
+	return "", nil
+}
+
+// traceLine печатает входную строку в режиме set -v
+func (s *session) traceLine(line string) {
+	if s.opts.verbose {
+		fmt.Fprintln(s.stderr, line)
+	}
+}
+
+// trace печатает простую команду в режиме set -x: "+ cmd args"
+func (s *session) trace(fields []string) {
+	if s.opts.xtrace {
+		fmt.Fprintln(s.stderr, "+ "+quoteFields(fields))
+	}
+}
+
+// tracePipeline печатает пайплайн в режиме set -x одной строкой,
+// команды разделены " | " в порядке запуска
+func (s *session) tracePipeline(stages [][]string) {
+	if !s.opts.xtrace {
+		return
+	}
+	parts := make([]string, len(stages))
+	for i, fields := range stages {
+		parts[i] = quoteFields(fields)
+	}
+	fmt.Fprintln(s.stderr, "+ "+strings.Join(parts, " | "))
+}
+
+// quoteFields склеивает аргументы так, чтобы их можно было вставить обратно
+// в шелл: аргументы с пробелами и спецсимволами берутся в одинарные кавычки
+func quoteFields(fields []string) string {
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		if f != "" && !strings.ContainsAny(f, " \t\n'\"\\|&<>()$*?;") {
+			quoted[i] = f
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(f, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// checkSyntax разбирает команду без запуска (для set -n) и сообщает о том,
+// что при запуске выполнилось бы неправильно: незакрытые кавычки,
+// пустые команды в пайплайне и редиректы без файла
+func checkSyntax(line string) error {
+	inSingle, inDouble := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' && !inSingle {
+			i++
+			continue
+		}
+		if c == '"' && !inSingle {
+			inDouble = !inDouble
+		}
+		if c == '\'' && !inDouble {
+			inSingle = !inSingle
+		}
+	}
+	if inSingle || inDouble {
+		return fmt.Errorf("syntax error: unterminated quote")
+	}
+
+	for _, part := range strings.Split(line, "|") {
+		fields := splitFieldsRespectingQuotes(strings.TrimSpace(part))
+		if len(fields) == 0 {
+			return fmt.Errorf("syntax error: empty command in pipeline")
+		}
+		switch fields[len(fields)-1] {
+		case ">", ">>", "<":
+			return fmt.Errorf("syntax error near unexpected token `newline'")
+		}
+	}
+	return nil
+}
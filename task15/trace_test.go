@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheckSyntax(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+	}{
+		{"echo hi", true},
+		{"echo 'a b' \"c d\"", true},
+		{`echo it\'s`, true},
+		{`echo "a \" b"`, true},
+		{"echo 'a", false},
+		{`echo "a`, false},
+		{`echo 'a\'`, true}, // в одинарных кавычках \ — обычный символ
+		{"ls | wc -l", true},
+		{"ls |", false},
+		{"| wc", false},
+		{"ls || wc", false},
+		{"echo >", false},
+		{"echo >>", false},
+		{"cat <", false},
+		{"cat < in | sort > out", true},
+	}
+	for _, tc := range tests {
+		if err := checkSyntax(tc.line); (err == nil) != tc.ok {
+			t.Errorf("checkSyntax(%q) = %v, want ok %v", tc.line, err, tc.ok)
+		}
+	}
+}
+
+func TestQuoteFields(t *testing.T) {
+	tests := []struct {
+		fields []string
+		want   string
+	}{
+		{[]string{"echo", "hi"}, "echo hi"},
+		{[]string{"echo", "a b"}, "echo 'a b'"},
+		{[]string{"echo", ""}, "echo ''"},
+		{[]string{"echo", "it's"}, `echo 'it'\''s'`},
+		{[]string{"echo", "$HOME", "*.go", "a|b"}, "echo '$HOME' '*.go' 'a|b'"},
+		{[]string{"ls", "-l", "/tmp"}, "ls -l /tmp"},
+		{nil, ""},
+	}
+	for _, tc := range tests {
+		if got := quoteFields(tc.fields); got != tc.want {
+			t.Errorf("quoteFields(%q) = %q, want %q", tc.fields, got, tc.want)
+		}
+	}
+}
+
+func TestRunSet(t *testing.T) {
+	s := testSession(t)
+	tests := []struct {
+		args []string
+		opts shellOptions
+		ok   bool
+	}{
+		{[]string{"-x"}, shellOptions{xtrace: true}, true},
+		{[]string{"+x", "-v"}, shellOptions{verbose: true}, true},
+		{[]string{"-nx"}, shellOptions{noexec: true, verbose: true, xtrace: true}, true},
+		{[]string{"+nxv"}, shellOptions{}, true},
+		{[]string{"-o", "xtrace"}, shellOptions{xtrace: true}, true},
+		{[]string{"+o", "xtrace", "-o", "noexec"}, shellOptions{noexec: true}, true},
+		{[]string{"+n"}, shellOptions{}, true},
+		{[]string{"-q"}, shellOptions{}, false},
+		{[]string{"-xq"}, shellOptions{xtrace: true}, false}, // флаги до ошибки уже применены
+		{[]string{"+x", "x"}, shellOptions{}, false},
+		{[]string{"-o", "x"}, shellOptions{}, false}, // -o принимает только полные имена
+		{[]string{"-o", "bogus"}, shellOptions{}, false},
+		{[]string{"+o"}, shellOptions{}, false},
+	}
+	for _, tc := range tests {
+		_, err := s.runSet(tc.args)
+		if s.opts != tc.opts || (err == nil) != tc.ok {
+			t.Errorf("set %q: opts %+v, err %v; want %+v, ok %v", tc.args, s.opts, err, tc.opts, tc.ok)
+		}
+	}
+
+	s.opts = shellOptions{xtrace: true}
+	out, err := s.runSet([]string{"-o"})
+	if want := "noexec   off\nverbose  off\nxtrace   on"; out != want || err != nil {
+		t.Errorf("set -o = %q, %v; want %q", out, err, want)
+	}
+}
+
+func TestTraceOutput(t *testing.T) {
+	s := testSession(t)
+	s.setenv("PATH", os.Getenv("PATH"))
+	s.setenv("V", "a b")
+
+	tests := []struct {
+		line   string
+		stdout string
+		stderr string
+	}{
+		{"set -x", "", ""},
+		{"echo $V c", "a b c\n", "+ echo 'a b' c\n"},
+		{"echo x | tr x y", "y\n", "+ echo x | tr x y\n"},
+		{"set +x -v", "", "+ set +x -v\n"},
+		{"echo v", "v\n", "echo v\n"},
+		{"set +v -n", "", "set +v -n\n"},
+		{"echo skipped", "", ""},
+		{"echo 'open", "", "syntax error: unterminated quote\n"},
+		{"echo >", "", "syntax error near unexpected token `newline'\n"},
+		{"set +n", "", ""},
+		{"echo back", "back\n", ""},
+	}
+	for _, tc := range tests {
+		stdout, stderr, _ := runLine(t, s, tc.line)
+		if stdout != tc.stdout || stderr != tc.stderr {
+			t.Errorf("%s: stdout %q, stderr %q; want %q, %q", tc.line, stdout, stderr, tc.stdout, tc.stderr)
+		}
+	}
+}
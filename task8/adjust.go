@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// capSysTime — номер capability CAP_SYS_TIME (linux/capability.h)
+const capSysTime = 25
+
+// Ошибки коррекции часов
+var (
+	ErrNoCapSysTime = errors.New("нет права менять системные часы: нужна capability CAP_SYS_TIME " +
+		"(запустите от root или выдайте её: setcap cap_sys_time+ep timeMachine)")
+	ErrStepTooLarge = errors.New("смещение больше -max-step")
+)
+
+// clockAPI — системные вызовы, которыми sync меняет часы.
+// В тестах подменяется, чтобы не нужны были права
+type clockAPI interface {
+	// checkPrivilege возвращает ErrNoCapSysTime, если процессу нельзя менять часы
+	checkPrivilege() error
+	// slew плавно сдвигает часы на offset, немного ускоряя или замедляя их (adjtimex)
+	slew(offset time.Duration) error
+	// step переставляет часы на offset сразу (settimeofday)
+	step(offset time.Duration) error
+}
+
+// Способы коррекции часов
+const (
+	methodSlew = "slew"
+	methodStep = "step"
+)
+
+// adjustment — итог sync: что сделано (или с -dry-run было бы сделано) с часами
+type adjustment struct {
+	Offset  time.Duration `json:"offset_ns"`
+	Error   time.Duration `json:"error_ns"`
+	Method  string        `json:"method"`
+	DryRun  bool          `json:"dry_run"`
+	Applied bool          `json:"applied"`
+}
+
+// planAdjustment выбирает способ коррекции: смещение не больше slewLimit
+// догоняется плавно, большее — шагом, но не больше maxStep: такое смещение
+// скорее говорит об ошибке, чем о реальном уходе часов
+func planAdjustment(offset, slewLimit, maxStep time.Duration) (string, error) {
+	abs := offset
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs <= slewLimit:
+		return methodSlew, nil
+	case abs <= maxStep:
+		return methodStep, nil
+	default:
+		return "", fmt.Errorf("%w: %s при пределе %v; поставьте часы вручную или увеличьте -max-step",
+			ErrStepTooLarge, signed(offset), maxStep)
+	}
+}
+
+// adjustClock корректирует часы через api на offset. С dryRun только
+// выбирает способ и ничего не вызывает
+func adjustClock(api clockAPI, offset, slewLimit, maxStep time.Duration, dryRun bool) (*adjustment, error) {
+	method, err := planAdjustment(offset, slewLimit, maxStep)
+	if err != nil {
+		return nil, err
+	}
+	adj := &adjustment{Offset: offset, Method: method, DryRun: dryRun}
+	if dryRun {
+		return adj, nil
+	}
+
+	if method == methodSlew {
+		err = api.slew(offset)
+	} else {
+		err = api.step(offset)
+	}
+	if errors.Is(err, syscall.EPERM) {
+		err = ErrNoCapSysTime
+	}
+	if err != nil {
+		return adj, fmt.Errorf("%s: %w", method, err)
+	}
+	adj.Applied = true
+	return adj, nil
+}
+
+// hasCapability проверяет бит capability в строке CapEff из /proc/<pid>/status
+func hasCapability(status []byte, capability uint) (bool, error) {
+	sc := bufio.NewScanner(bytes.NewReader(status))
+	for sc.Scan() {
+		v, ok := strings.CutPrefix(sc.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		if err != nil {
+			return false, fmt.Errorf("CapEff: %w", err)
+		}
+		return caps&(1<<capability) != 0, nil
+	}
+	return false, errors.New("нет строки CapEff")
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var errUnsupported = errors.New("коррекция часов поддерживается только в Linux")
+
+// systemClock — заглушка clockAPI для систем, кроме Linux
+type systemClock struct{}
+
+func (systemClock) checkPrivilege() error           { return errUnsupported }
+func (systemClock) slew(offset time.Duration) error { return errUnsupported }
+func (systemClock) step(offset time.Duration) error { return errUnsupported }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeClock — clockAPI, который только запоминает вызовы
+type fakeClock struct {
+	privileged bool
+	err        error // что вернуть из slew и step
+	calls      []string
+	offsets    []time.Duration
+}
+
+func (f *fakeClock) checkPrivilege() error {
+	if !f.privileged {
+		return ErrNoCapSysTime
+	}
+	return nil
+}
+
+func (f *fakeClock) slew(offset time.Duration) error {
+	f.calls = append(f.calls, methodSlew)
+	f.offsets = append(f.offsets, offset)
+	return f.err
+}
+
+func (f *fakeClock) step(offset time.Duration) error {
+	f.calls = append(f.calls, methodStep)
+	f.offsets = append(f.offsets, offset)
+	return f.err
+}
+
+func TestAdjustClock(t *testing.T) {
+	const slewLimit, maxStep = 128 * time.Millisecond, time.Minute
+	tests := []struct {
+		name    string
+		offset  time.Duration
+		dryRun  bool
+		callErr error
+		want    []string
+		wantErr error
+	}{
+		{"slew", 20 * time.Millisecond, false, nil, []string{methodSlew}, nil},
+		{"slew negative", -128 * time.Millisecond, false, nil, []string{methodSlew}, nil},
+		{"step", 5 * time.Second, false, nil, []string{methodStep}, nil},
+		{"step negative", -30 * time.Second, false, nil, []string{methodStep}, nil},
+		{"too large", -2 * time.Minute, false, nil, nil, ErrStepTooLarge},
+		{"dry run", 5 * time.Second, true, nil, nil, nil},
+		{"dry run too large", time.Hour, true, nil, nil, ErrStepTooLarge},
+		{"eperm", 5 * time.Second, false, syscall.EPERM, []string{methodStep}, ErrNoCapSysTime},
+	}
+	for _, tt := range tests {
+		api := &fakeClock{privileged: true, err: tt.callErr}
+		adj, err := adjustClock(api, tt.offset, slewLimit, maxStep, tt.dryRun)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if strings.Join(api.calls, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: calls = %v, want %v", tt.name, api.calls, tt.want)
+		}
+		if len(api.offsets) > 0 && api.offsets[0] != tt.offset {
+			t.Errorf("%s: offset = %v, want %v", tt.name, api.offsets[0], tt.offset)
+		}
+		if err == nil && adj.Applied == tt.dryRun {
+			t.Errorf("%s: applied = %v, dry run = %v", tt.name, adj.Applied, tt.dryRun)
+		}
+	}
+}
+
+func TestHasCapability(t *testing.T) {
+	status := []byte("Name:\ttimeMachine\nCapInh:\t0000000000000000\nCapEff:\t0000000002000000\n")
+	if ok, err := hasCapability(status, capSysTime); err != nil || !ok {
+		t.Errorf("CAP_SYS_TIME: ok=%v err=%v", ok, err)
+	}
+	if ok, _ := hasCapability(status, 21); ok {
+		t.Error("CAP_SYS_ADMIN не выдан, но найден")
+	}
+	if _, err := hasCapability([]byte("Name:\tx\n"), capSysTime); err == nil {
+		t.Error("нет ошибки без строки CapEff")
+	}
+}
+
+func TestRunSync(t *testing.T) {
+	addr := standIn{stratum: 1, refID: "GPS", offset: 2 * time.Second}.start(t)
+
+	api := &fakeClock{privileged: true}
+	var stdout, stderr bytes.Buffer
+	if code := runSync([]string{"-s", addr, "-samples", "1", "-json"}, &stdout, &stderr, api); code != 0 {
+		t.Fatalf("code = %d, stderr: %s", code, stderr.String())
+	}
+	var out syncReport
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("json: %v\n%s", err, stdout.String())
+	}
+	if out.Adjustment == nil || out.Adjustment.Method != methodStep || !out.Adjustment.Applied {
+		t.Errorf("adjustment = %+v", out.Adjustment)
+	}
+	if len(api.offsets) != 1 || api.offsets[0] < 1900*time.Millisecond || api.offsets[0] > 2100*time.Millisecond {
+		t.Errorf("step offsets = %v, want ~2s", api.offsets)
+	}
+
+	// -max-step меньше смещения: часы не трогаем
+	api = &fakeClock{privileged: true}
+	stdout.Reset()
+	stderr.Reset()
+	if code := runSync([]string{"-s", addr, "-samples", "1", "-max-step", "1s"}, &stdout, &stderr, api); code != 1 {
+		t.Errorf("max-step: code = %d, want 1", code)
+	}
+	if len(api.calls) != 0 || !strings.Contains(stderr.String(), "-max-step") {
+		t.Errorf("max-step: calls=%v stderr=%q", api.calls, stderr.String())
+	}
+
+	// без прав: понятная ошибка, серверы даже не опрашиваются
+	api = &fakeClock{}
+	stdout.Reset()
+	stderr.Reset()
+	if code := runSync([]string{"-s", "127.0.0.1:1"}, &stdout, &stderr, api); code != 1 {
+		t.Errorf("no cap: code = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "CAP_SYS_TIME") || stdout.Len() != 0 {
+		t.Errorf("no cap: stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+
+	// -dry-run работает и без прав
+	stdout.Reset()
+	stderr.Reset()
+	if code := runSync([]string{"-s", addr, "-samples", "1", "-dry-run"}, &stdout, &stderr, api); code != 0 {
+		t.Fatalf("dry run: code = %d, stderr: %s", code, stderr.String())
+	}
+	if len(api.calls) != 0 || !strings.Contains(stdout.String(), "step by +") || !strings.Contains(stdout.String(), "dry run") {
+		t.Errorf("dry run: calls=%v stdout:\n%s", api.calls, stdout.String())
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// Способы проверки подлинности ответа (поле report.Auth)
+const (
+	authNTS       = "nts"
+	authSymmetric = "symmetric"
+)
+
+// symmetricKey — ключ из файла ключей в формате ntp.keys
+type symmetricKey struct {
+	id  uint16
+	typ ntp.AuthType
+	key string // с префиксом ASCII: или HEX:, как ждёт ntp.AuthOptions
+}
+
+// keyTypes — названия алгоритмов в файле ключей
+var keyTypes = map[string]ntp.AuthType{
+	"M":          ntp.AuthMD5,
+	"MD5":        ntp.AuthMD5,
+	"SHA1":       ntp.AuthSHA1,
+	"SHA256":     ntp.AuthSHA256,
+	"SHA512":     ntp.AuthSHA512,
+	"AES128CMAC": ntp.AuthAES128,
+	"AES256CMAC": ntp.AuthAES256,
+}
+
+// loadKeys читает файл ключей как у ntpd: "id тип ключ" на строку. Ключ
+// до 20 символов берётся как ASCII, длиннее — как hex. Всё после # пропускается
+func loadKeys(path string) (map[uint16]symmetricKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := map[uint16]symmetricKey{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: нужно \"id тип ключ\"", path, n)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("%s:%d: id ключа должен быть от 1 до 65535", path, n)
+		}
+		typ, ok := keyTypes[strings.ToUpper(fields[1])]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: неизвестный тип ключа %s", path, n, fields[1])
+		}
+		key := "ASCII:" + fields[2]
+		if len(fields[2]) > 20 {
+			if _, err := hex.DecodeString(fields[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: ключ длиннее 20 символов должен быть в hex", path, n)
+			}
+			key = "HEX:" + fields[2]
+		}
+		keys[uint16(id)] = symmetricKey{uint16(id), typ, key}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// authConfig — как проверять подлинность ответов серверов.
+// nil — без проверки, как у обычного SNTP-клиента
+type authConfig struct {
+	nts     bool
+	ntsPort string // порт NTS-KE на хосте сервера
+	tls     *tls.Config
+	key     *symmetricKey // ключ, если NTS нет или он не удался
+
+	mu       sync.Mutex
+	sessions map[string]*ntsSession // по адресу NTS-KE
+}
+
+// authFlags — флаги проверки подлинности, общие для режимов с опросом серверов
+type authFlags struct {
+	nts     *bool
+	ntsPort *int
+	ca      *string
+	keys    *string
+	keyID   *uint
+}
+
+func addAuthFlags(fs *flag.FlagSet) *authFlags {
+	return &authFlags{
+		nts:     fs.Bool("nts", false, "authenticate servers with NTS (RFC 8915)"),
+		ntsPort: fs.Int("nts-port", ntsKEPort, "NTS-KE port on the server host"),
+		ca:      fs.String("nts-ca", "", "PEM file with CA certificates for NTS-KE instead of the system ones"),
+		keys:    fs.String("keys", "", "ntp.keys-style file with symmetric keys"),
+		keyID:   fs.Uint("key", 0, "`id` of the symmetric key from -keys; used alone or as a fallback when NTS fails"),
+	}
+}
+
+// config собирает authConfig из флагов; nil, если проверка не включена
+func (f *authFlags) config() (*authConfig, error) {
+	if !*f.nts && *f.keys == "" && *f.keyID == 0 {
+		return nil, nil
+	}
+	if (*f.keys == "") != (*f.keyID == 0) {
+		return nil, errors.New("-keys и -key задаются вместе")
+	}
+	a := &authConfig{nts: *f.nts, ntsPort: strconv.Itoa(*f.ntsPort), tls: &tls.Config{}}
+	if *f.ca != "" {
+		pem, err := os.ReadFile(*f.ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: нет сертификатов в PEM", *f.ca)
+		}
+		a.tls.RootCAs = pool
+	}
+	if *f.keys != "" {
+		keys, err := loadKeys(*f.keys)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := keys[uint16(*f.keyID)]
+		if !ok || uint(k.id) != *f.keyID {
+			return nil, fmt.Errorf("%s: нет ключа %d", *f.keys, *f.keyID)
+		}
+		a.key = &k
+	}
+	return a, nil
+}
+
+// prepare настраивает запрос к server: добавляет NTS, если он включён,
+// а если NTS-KE не удался и есть ключ — симметричную подпись.
+// Возвращает адрес, куда слать запрос, способ проверки и ошибку NTS-KE,
+// если пришлось откатиться на ключ
+func (a *authConfig) prepare(server string, opts *ntp.QueryOptions) (addr, method string, ntsErr error, err error) {
+	if a == nil {
+		return server, "", nil, nil
+	}
+	if a.nts {
+		s, err := a.session(server, opts.Timeout)
+		if err == nil {
+			opts.Extensions = []ntp.Extension{&ntsExchange{s: s}}
+			return s.ntpServer, authNTS, nil, nil
+		}
+		if a.key == nil {
+			return "", "", nil, err
+		}
+		ntsErr = err
+	}
+	opts.Auth = ntp.AuthOptions{Type: a.key.typ, Key: a.key.key, KeyID: a.key.id}
+	return server, authSymmetric, ntsErr, nil
+}
+
+// session возвращает NTS-сессию с хостом server, проводя NTS-KE,
+// если сессии ещё нет или в ней кончились cookies
+func (a *authConfig) session(server string, timeout time.Duration) (*ntsSession, error) {
+	host := server
+	if h, _, err := net.SplitHostPort(server); err == nil {
+		host = h
+	}
+	ke := net.JoinHostPort(host, a.ntsPort)
+
+	a.mu.Lock()
+	s := a.sessions[ke]
+	a.mu.Unlock()
+	if s != nil {
+		s.mu.Lock()
+		left := len(s.cookies)
+		s.mu.Unlock()
+		if left > 0 {
+			return s, nil
+		}
+	}
+
+	s, err := ntsKeyExchange(ke, a.tls, timeout)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sessions == nil {
+		a.sessions = map[string]*ntsSession{}
+	}
+	a.sessions[ke] = s
+	return s, nil
+}
+
+// forget сбрасывает NTS-сессию с хостом server после неудачного запроса:
+// следующий запрос заново проведёт NTS-KE
+func (a *authConfig) forget(server string) {
+	if a == nil {
+		return
+	}
+	host := server
+	if h, _, err := net.SplitHostPort(server); err == nil {
+		host = h
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, net.JoinHostPort(host, a.ntsPort))
+}
@@ -0,0 +1,112 @@
+// Package clock — часы, подстроенные под время NTP-серверов.
+//
+// Synced хранит последнее измеренное смещение и между синхронизациями
+// отсчитывает время по монотонным часам, поэтому перевод системных часов
+// (вручную или другой службой) на Now не влияет. Сервисы,
+// которым нужно сетевое время, берут его через интерфейс Clock вместо time.Now
+package clock
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// Clock — источник текущего времени
+type Clock interface {
+	Now() time.Time
+}
+
+// System — системные часы как Clock
+type System struct{}
+
+func (System) Now() time.Time { return time.Now() }
+
+// Synced — часы, подстроенные под сеть. Нулевое значение готово к работе
+// и до первой синхронизации идёт как системные часы. Безопасен для
+// одновременного использования
+type Synced struct {
+	now func() time.Time // time.Now; подменяется в тестах
+
+	mu      sync.RWMutex
+	local   time.Time     // локальное время синхронизации, с монотонным отсчётом
+	network time.Time     // сетевое время в тот же момент, без монотонного отсчёта
+	offset  time.Duration // network - local
+}
+
+func (c *Synced) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// Set запоминает смещение offset, измеренное только что: сетевое время
+// равно локальному плюс offset
+func (c *Synced) Set(offset time.Duration) {
+	local := c.clock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.local = local
+	c.network = local.Round(0).Add(offset)
+	c.offset = offset
+}
+
+// SyncNTP измеряет смещение по серверу server и запоминает его.
+// Ответ kiss-of-death, от несинхронизированного сервера или не прошедший
+// проверку ntp.Response.Validate не применяется
+func (c *Synced) SyncNTP(server string, timeout time.Duration) error {
+	r, err := ntp.QueryWithOptions(server, ntp.QueryOptions{Timeout: timeout})
+	if err != nil {
+		return err
+	}
+	if r.Leap == ntp.LeapNotInSync {
+		return errors.New("clock: сервер не синхронизирован")
+	}
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	c.Set(r.ClockOffset)
+	return nil
+}
+
+// Now — сетевое время: время последней синхронизации плюс прошедшее
+// с неё время по монотонным часам. До синхронизации — системное время
+func (c *Synced) Now() time.Time {
+	return c.at(c.clock())
+}
+
+// at — сетевое время в момент, когда системные часы показывали now
+func (c *Synced) at(now time.Time) time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.local.IsZero() {
+		return now
+	}
+	return c.network.Add(now.Sub(c.local))
+}
+
+// Offset — последнее измеренное смещение и время синхронизации (нулевое, если её не было)
+func (c *Synced) Offset() (offset time.Duration, syncedAt time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.offset, c.local.Round(0)
+}
+
+// Diff — расхождение локальных часов с сетевыми в один момент
+type Diff struct {
+	Local   time.Time     `json:"local"`
+	Network time.Time     `json:"network"`
+	Offset  time.Duration `json:"offset_ns"` // Network - Local; больше нуля — локальные часы отстают
+}
+
+// Diff сравнивает системные часы с c. Если системные часы перевели после
+// синхронизации, Offset это покажет, а Network — нет
+func (c *Synced) Diff() Diff {
+	now := c.clock()
+	d := Diff{Local: now.Round(0), Network: c.at(now)}
+	d.Offset = d.Network.Sub(d.Local)
+	return d
+}
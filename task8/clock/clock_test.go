@@ -0,0 +1,99 @@
+package clock
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNow — управляемые системные часы
+type fakeNow struct{ t time.Time }
+
+func (f *fakeNow) now() time.Time { return f.t }
+
+func TestSyncedBeforeSync(t *testing.T) {
+	var c Synced
+	if d := time.Since(c.Now()); d < 0 || d > time.Second {
+		t.Errorf("Now до синхронизации отличается от системного на %v", d)
+	}
+	if off, at := c.Offset(); off != 0 || !at.IsZero() {
+		t.Errorf("Offset = %v, %v", off, at)
+	}
+}
+
+func TestSyncedAdvances(t *testing.T) {
+	start := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	f := &fakeNow{start}
+	c := &Synced{now: f.now}
+
+	c.Set(2 * time.Second)
+	f.t = start.Add(10 * time.Second)
+	if got, want := c.Now(), start.Add(12*time.Second); !got.Equal(want) {
+		t.Errorf("Now = %v, want %v", got, want)
+	}
+
+	d := c.Diff()
+	if !d.Local.Equal(f.t) || d.Offset != 2*time.Second {
+		t.Errorf("Diff = %+v", d)
+	}
+
+	c.Set(-time.Second)
+	f.t = f.t.Add(time.Minute)
+	if got, want := c.Now(), start.Add(10*time.Second+time.Minute-time.Second); !got.Equal(want) {
+		t.Errorf("после пересинхронизации Now = %v, want %v", got, want)
+	}
+	if off, at := c.Offset(); off != -time.Second || !at.Equal(start.Add(10*time.Second)) {
+		t.Errorf("Offset = %v, %v", off, at)
+	}
+}
+
+func TestSyncedRealClock(t *testing.T) {
+	var c Synced
+	c.Set(time.Hour)
+	// с настоящими часами Now идёт вровень с системным временем плюс смещение
+	if d := c.Now().Sub(time.Now().Add(time.Hour)); d < -time.Second || d > time.Second {
+		t.Errorf("Now отличается от ожидаемого на %v", d)
+	}
+	if _, at := c.Offset(); at.Round(0) != at {
+		t.Error("Offset вернул время с монотонным отсчётом")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tm := time.Date(2024, 3, 10, 12, 34, 56, 789_000_000, time.UTC)
+	moscow, err := LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		format string
+		loc    *time.Location
+		want   string
+	}{
+		{"rfc3339", time.UTC, "2024-03-10T12:34:56Z"},
+		{"RFC3339", moscow, "2024-03-10T15:34:56+03:00"},
+		{"rfc3339nano", moscow, "2024-03-10T15:34:56.789+03:00"},
+		{"unix", moscow, "1710074096"},
+		{"unixms", nil, "1710074096789"},
+		{"unixns", nil, "1710074096789000000"},
+	}
+	for _, tt := range tests {
+		f, err := ParseFormat(tt.format)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if got := f.Format(tm, tt.loc); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := ParseFormat("iso"); err == nil || !strings.Contains(err.Error(), "unixms") {
+		t.Errorf("ParseFormat(iso) = %v", err)
+	}
+	if _, err := LoadLocation("Mars/Olympus"); err == nil {
+		t.Error("LoadLocation(Mars/Olympus): нет ошибки")
+	}
+	if loc, err := LoadLocation(""); err != nil || loc != time.Local {
+		t.Errorf("LoadLocation(\"\") = %v, %v", loc, err)
+	}
+}
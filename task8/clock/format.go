@@ -0,0 +1,71 @@
+package clock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format — формат вывода времени
+type Format string
+
+// Поддерживаемые форматы
+const (
+	RFC3339     Format = "rfc3339"
+	RFC3339Nano Format = "rfc3339nano"
+	Unix        Format = "unix"   // секунды с 1970-01-01 UTC
+	UnixMilli   Format = "unixms" // миллисекунды
+	UnixNano    Format = "unixns" // наносекунды
+)
+
+// Formats — все форматы, в порядке для справки
+var Formats = []Format{RFC3339, RFC3339Nano, Unix, UnixMilli, UnixNano}
+
+// ParseFormat разбирает название формата без учёта регистра
+func ParseFormat(s string) (Format, error) {
+	f := Format(strings.ToLower(s))
+	for _, known := range Formats {
+		if f == known {
+			return f, nil
+		}
+	}
+	names := make([]string, len(Formats))
+	for i, known := range Formats {
+		names[i] = string(known)
+	}
+	return "", fmt.Errorf("неизвестный формат %q, есть: %s", s, strings.Join(names, ", "))
+}
+
+// LoadLocation находит часовой пояс по имени IANA (Europe/Moscow).
+// Пустое имя и "Local" — локальный пояс системы
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("часовой пояс %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// Format печатает t в формате f в поясе loc (nil — локальный).
+// Для Unix-форматов пояс не важен
+func (f Format) Format(t time.Time, loc *time.Location) string {
+	if loc == nil {
+		loc = time.Local
+	}
+	switch f {
+	case Unix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case UnixMilli:
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	case UnixNano:
+		return strconv.FormatInt(t.UnixNano(), 10)
+	case RFC3339Nano:
+		return t.In(loc).Format(time.RFC3339Nano)
+	default:
+		return t.In(loc).Format(time.RFC3339)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadServers читает список серверов из файла: по серверу на строку,
+// в форме host[:port] или как в ntp.conf — "server host[:port]" / "pool host".
+// Пустые строки и всё после # пропускаются, прочие параметры строки игнорируются
+func loadServers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var servers []string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "server" || fields[0] == "pool" {
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s:%d: не указан адрес сервера", path, n)
+			}
+			fields = fields[1:]
+		}
+		servers = append(servers, fields[0])
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%s: список серверов пуст", path)
+	}
+	return servers, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Параметры алгоритмов отбора из RFC 5905
+const (
+	minSurvivors = 3                       // NMIN: меньше стольких серверов кластеризация не отбрасывает
+	minDistance  = time.Millisecond        // нижняя граница root distance кандидата
+	maxDistance  = 1500 * time.Millisecond // MAXDIST: с большей дистанцией сервер не участвует
+)
+
+// ErrNoMajority — пересечение интервалов не нашло согласного большинства
+var ErrNoMajority = errors.New("нет большинства серверов с пересекающимися интервалами")
+
+// candidate — сервер, участвующий в выборе: смещение и интервал корректности
+// [offset-distance, offset+distance], в котором по его данным находится истинное время
+type candidate struct {
+	server   string
+	offset   time.Duration
+	distance time.Duration
+	jitter   time.Duration
+}
+
+// rejection — сервер, не вошедший в итог, и причина
+type rejection struct {
+	Server string `json:"server"`
+	Reason string `json:"reason"`
+}
+
+// consensus — итог опроса нескольких серверов.
+// Истинное смещение лежит в [Low, High], Error — насколько Offset может от него отличаться
+type consensus struct {
+	Offset    time.Duration `json:"offset_ns"`
+	Error     time.Duration `json:"error_ns"`
+	Jitter    time.Duration `json:"jitter_ns"`
+	Low       time.Duration `json:"low_ns"`
+	High      time.Duration `json:"high_ns"`
+	Survivors []string      `json:"survivors"`
+	Rejected  []rejection   `json:"rejected"`
+}
+
+// buildConsensus отбирает достоверные ответы и объединяет их смещения:
+// сначала отбрасывает неответившие и непрошедшие проверку серверы,
+// затем пересечением Марзулло — falsetickers, затем кластеризацией — выбросы
+func buildConsensus(reports []*report) (*consensus, error) {
+	c := &consensus{Survivors: []string{}, Rejected: []rejection{}}
+
+	var cands []candidate
+	for _, r := range reports {
+		switch {
+		case r.Error != "" && r.Time.IsZero():
+			c.Rejected = append(c.Rejected, rejection{r.Server, "нет ответа: " + r.Error})
+		case !r.Valid:
+			c.Rejected = append(c.Rejected, rejection{r.Server, "ответ отклонён: " + r.Error})
+		default:
+			d := r.RootDistance + r.Jitter
+			if d > maxDistance {
+				c.Rejected = append(c.Rejected, rejection{r.Server,
+					fmt.Sprintf("слишком большая root distance %v", d)})
+				continue
+			}
+			cands = append(cands, candidate{r.Server, r.Offset, max(d, minDistance), r.Jitter})
+		}
+	}
+	if len(cands) == 0 {
+		return c, fmt.Errorf("нет пригодных ответов")
+	}
+
+	low, high, truechimers, falsetickers, err := intersect(cands)
+	if err != nil {
+		for _, f := range cands {
+			c.Rejected = append(c.Rejected, rejection{f.server, "не согласуется с остальными"})
+		}
+		return c, err
+	}
+	for _, f := range falsetickers {
+		c.Rejected = append(c.Rejected, rejection{f.server,
+			fmt.Sprintf("falseticker: смещение %s вне пересечения [%s, %s]", signed(f.offset), signed(low), signed(high))})
+	}
+
+	survivors, outliers := cluster(truechimers)
+	c.Rejected = append(c.Rejected, outliers...)
+
+	c.Offset, c.Jitter = combine(survivors)
+	c.Low, c.High = low, high
+	c.Error = max(c.Offset-low, high-c.Offset)
+	for _, s := range survivors {
+		c.Survivors = append(c.Survivors, s.server)
+	}
+	return c, nil
+}
+
+// intersect — алгоритм выбора из RFC 5905 (вариант алгоритма Марзулло).
+// Ищет наименьшее число f допустимых falsetickers, при котором пересечение
+// интервалов хотя бы m-f кандидатов непусто и вне него лежат не более f
+// середин интервалов. Кандидаты со смещением вне [low, high] — falsetickers
+func intersect(cands []candidate) (low, high time.Duration, truechimers, falsetickers []candidate, err error) {
+	type edge struct {
+		val time.Duration
+		typ int // -1 — нижняя граница, 0 — середина, +1 — верхняя
+	}
+	edges := make([]edge, 0, 3*len(cands))
+	for _, c := range cands {
+		edges = append(edges,
+			edge{c.offset - c.distance, -1},
+			edge{c.offset, 0},
+			edge{c.offset + c.distance, 1})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].val != edges[j].val {
+			return edges[i].val < edges[j].val
+		}
+		return edges[i].typ < edges[j].typ
+	})
+
+	m := len(cands)
+	found := false
+	for allow := 0; 2*allow < m; allow++ {
+		mids := 0
+
+		chime := 0
+		for _, e := range edges {
+			chime -= e.typ
+			if chime >= m-allow {
+				low = e.val
+				break
+			}
+			if e.typ == 0 {
+				mids++
+			}
+		}
+
+		chime = 0
+		for i := len(edges) - 1; i >= 0; i-- {
+			chime += edges[i].typ
+			if chime >= m-allow {
+				high = edges[i].val
+				break
+			}
+			if edges[i].typ == 0 {
+				mids++
+			}
+		}
+
+		if mids <= allow && low < high {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return 0, 0, nil, nil, ErrNoMajority
+	}
+
+	for _, c := range cands {
+		if c.offset >= low && c.offset <= high {
+			truechimers = append(truechimers, c)
+		} else {
+			falsetickers = append(falsetickers, c)
+		}
+	}
+	return low, high, truechimers, falsetickers, nil
+}
+
+// cluster — алгоритм кластеризации из RFC 5905: пока кандидатов больше
+// minSurvivors, отбрасывает того, чей разброс относительно остальных
+// (selection jitter) наибольший, если он больше наименьшего собственного
+// джиттера сервера — то есть если отбрасывание уменьшает общий разброс
+func cluster(cands []candidate) (survivors []candidate, outliers []rejection) {
+	survivors = append([]candidate(nil), cands...)
+	for len(survivors) > minSurvivors {
+		worst, worstJitter := 0, -1.0
+		minPeer := math.Inf(1)
+		for i, c := range survivors {
+			var sum float64
+			for _, o := range survivors {
+				d := float64(c.offset - o.offset)
+				sum += d * d
+			}
+			sel := math.Sqrt(sum / float64(len(survivors)-1))
+			if sel > worstJitter {
+				worst, worstJitter = i, sel
+			}
+			minPeer = math.Min(minPeer, float64(c.jitter))
+		}
+		if worstJitter <= minPeer {
+			break
+		}
+		c := survivors[worst]
+		outliers = append(outliers, rejection{c.server,
+			fmt.Sprintf("выброс: разброс относительно остальных %v", time.Duration(worstJitter))})
+		survivors = append(survivors[:worst], survivors[worst+1:]...)
+	}
+	return survivors, outliers
+}
+
+// combine усредняет смещения с весами 1/distance, как clock_combine из RFC 5905,
+// и возвращает его вместе с взвешенным разбросом смещений (system jitter)
+func combine(cands []candidate) (offset, jitter time.Duration) {
+	var wsum, osum float64
+	for _, c := range cands {
+		w := 1 / float64(c.distance)
+		wsum += w
+		osum += w * float64(c.offset)
+	}
+	mean := osum / wsum
+
+	var jsum float64
+	for _, c := range cands {
+		d := float64(c.offset) - mean
+		jsum += d * d / float64(c.distance)
+	}
+	return time.Duration(math.Round(mean)), time.Duration(math.Sqrt(jsum / wsum))
+}
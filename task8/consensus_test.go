@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ms(n float64) time.Duration { return time.Duration(n * float64(time.Millisecond)) }
+
+func servers(cands []candidate) []string {
+	var names []string
+	for _, c := range cands {
+		names = append(names, c.server)
+	}
+	return names
+}
+
+func TestIntersect(t *testing.T) {
+	tests := []struct {
+		name         string
+		cands        []candidate
+		falsetickers []string
+		wantErr      error
+	}{
+		{
+			name: "все согласны",
+			cands: []candidate{
+				{server: "a", offset: ms(1), distance: ms(5)},
+				{server: "b", offset: ms(2), distance: ms(5)},
+				{server: "c", offset: ms(3), distance: ms(5)},
+			},
+		},
+		{
+			name: "один врёт",
+			cands: []candidate{
+				{server: "a", offset: ms(1), distance: ms(5)},
+				{server: "b", offset: ms(2), distance: ms(5)},
+				{server: "c", offset: ms(3), distance: ms(5)},
+				{server: "bad", offset: ms(5000), distance: ms(5)},
+			},
+			falsetickers: []string{"bad"},
+		},
+		{
+			name: "нет большинства",
+			cands: []candidate{
+				{server: "a", offset: ms(0), distance: ms(1)},
+				{server: "b", offset: ms(100), distance: ms(1)},
+			},
+			wantErr: ErrNoMajority,
+		},
+	}
+
+	for _, tt := range tests {
+		low, high, truechimers, falsetickers, err := intersect(tt.cands)
+		if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(servers(falsetickers), tt.falsetickers) {
+			t.Errorf("%s: falsetickers = %v, want %v", tt.name, servers(falsetickers), tt.falsetickers)
+		}
+		for _, c := range truechimers {
+			if c.offset < low || c.offset > high {
+				t.Errorf("%s: %s (%v) вне [%v, %v]", tt.name, c.server, c.offset, low, high)
+			}
+		}
+	}
+}
+
+func TestCluster(t *testing.T) {
+	cands := []candidate{
+		{server: "a", offset: ms(1.0), jitter: ms(0.1)},
+		{server: "b", offset: ms(1.1), jitter: ms(0.1)},
+		{server: "c", offset: ms(0.9), jitter: ms(0.1)},
+		{server: "d", offset: ms(1.05), jitter: ms(0.1)},
+		{server: "far", offset: ms(9), jitter: ms(0.1)},
+	}
+	survivors, outliers := cluster(cands)
+	if len(outliers) == 0 || outliers[0].Server != "far" {
+		t.Errorf("outliers = %v, want far first", outliers)
+	}
+	if len(survivors) < minSurvivors {
+		t.Errorf("survivors = %v, want at least %d", servers(survivors), minSurvivors)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	// вес 1/distance: ближний сервер тянет результат к себе
+	offset, _ := combine([]candidate{
+		{offset: ms(0), distance: ms(1)},
+		{offset: ms(3), distance: ms(2)},
+	})
+	if offset != ms(1) {
+		t.Errorf("offset = %v, want 1ms", offset)
+	}
+}
+
+func TestLoadServers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.conf")
+	conf := "# список\nserver a.example iburst\npool b.example\n\nc.example:1123 # свой порт\n"
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadServers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.example", "b.example", "c.example:1123"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadServers = %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte("# пусто\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadServers(path); err == nil {
+		t.Error("пустой список должен быть ошибкой")
+	}
+}
+
+func TestRunConsensus(t *testing.T) {
+	good := []string{
+		standIn{stratum: 1, refID: "GPS", offset: ms(100)}.start(t),
+		standIn{stratum: 2, refID: "\x0a\x00\x00\x01", offset: ms(101)}.start(t),
+		standIn{stratum: 2, refID: "\x0a\x00\x00\x02", offset: ms(99)}.start(t),
+	}
+	liar := standIn{stratum: 1, refID: "PPS", offset: 30 * time.Second}.start(t)
+	kod := standIn{stratum: 0, refID: "RATE"}.start(t)
+
+	path := filepath.Join(t.TempDir(), "servers.conf")
+	conf := strings.Join(append(good, "server "+liar, "server "+kod), "\n")
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-json", "-timeout", "1s", "-samples", "2", "-config", path}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("code = %d, stderr: %s\n%s", code, stderr.String(), stdout.String())
+	}
+
+	var out consensusReport
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("JSON: %v\n%s", err, stdout.String())
+	}
+	c := out.Consensus
+	if c.Offset < ms(95) || c.Offset > ms(105) {
+		t.Errorf("offset = %v, want ~100ms", c.Offset)
+	}
+	if c.Error <= 0 || c.Error > ms(50) {
+		t.Errorf("error bound = %v", c.Error)
+	}
+	if !reflect.DeepEqual(c.Survivors, good) {
+		t.Errorf("survivors = %v, want %v", c.Survivors, good)
+	}
+
+	reasons := map[string]string{}
+	for _, r := range c.Rejected {
+		reasons[r.Server] = r.Reason
+	}
+	if !strings.HasPrefix(reasons[liar], "falseticker") {
+		t.Errorf("liar rejected as %q", reasons[liar])
+	}
+	if !strings.Contains(reasons[kod], "RATE") {
+		t.Errorf("kod rejected as %q", reasons[kod])
+	}
+}
+
+func TestRunConsensusText(t *testing.T) {
+	a := standIn{stratum: 1, refID: "GPS"}.start(t)
+	b := standIn{stratum: 1, refID: "GPS"}.start(t)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-consensus", "-s", a, "-s", b}, &stdout, &stderr); code != 0 {
+		t.Fatalf("code = %d, stderr: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "used:            "+a+", "+b) {
+		t.Errorf("вывод:\n%s", stdout.String())
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exitAlert — код выхода monitor с -exit-on-alert, когда часы ушли дальше порога
+const exitAlert = 3
+
+// monitorConfig — параметры режима monitor
+type monitorConfig struct {
+	servers     []string
+	interval    time.Duration // обычный интервал опроса
+	maxBackoff  time.Duration // предел интервала при ошибках
+	timeout     time.Duration
+	samples     int
+	threshold   time.Duration // допустимое |offset|
+	webhook     string        // URL для POST об alert; пусто — без webhook
+	history     int           // сколько точек хранить в памяти
+	exitOnAlert bool
+	record      io.Writer   // куда дописывать точки в JSON lines; nil — никуда
+	auth        *authConfig // проверка подлинности ответов; nil — без неё
+}
+
+// monitorSample — одна точка временного ряда: итог опроса всех серверов
+type monitorSample struct {
+	Time    time.Time     `json:"time"`
+	Offset  time.Duration `json:"offset_ns"`
+	Jitter  time.Duration `json:"jitter_ns"`
+	Error   time.Duration `json:"error_ns"`
+	RTT     time.Duration `json:"rtt_ns"` // средний RTT серверов, вошедших в итог
+	Servers int           `json:"servers"`
+	Failure string        `json:"failure,omitempty"`
+}
+
+// alertEvent — тело запроса к webhook
+type alertEvent struct {
+	Status    string        `json:"status"` // firing или resolved
+	Time      time.Time     `json:"time"`
+	Offset    time.Duration `json:"offset_ns"`
+	Threshold time.Duration `json:"threshold_ns"`
+	Servers   []string      `json:"servers"`
+}
+
+// monitor периодически опрашивает серверы, хранит временной ряд
+// смещения и отдаёт состояние в формате Prometheus
+type monitor struct {
+	cfg    monitorConfig
+	log    *log.Logger
+	client *http.Client
+
+	mu          sync.Mutex
+	series      []monitorSample // кольцевой буфер из cfg.history точек
+	next        int
+	reports     []*report // ответы серверов последнего опроса
+	polls       uint64
+	failures    uint64
+	alerting    bool
+	lastSuccess time.Time
+	last        monitorSample
+}
+
+func newMonitor(cfg monitorConfig, logOut io.Writer) *monitor {
+	return &monitor{
+		cfg:    cfg,
+		log:    log.New(logOut, "", log.LstdFlags),
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// nextWait — интервал до следующего опроса: после ошибки удваивается
+// (но не больше maxBackoff), после успеха возвращается к interval
+func nextWait(cur, interval, maxBackoff time.Duration, ok bool) time.Duration {
+	if ok {
+		return interval
+	}
+	return min(max(cur*2, interval), maxBackoff)
+}
+
+// run опрашивает серверы до отмены ctx. Возвращает exitAlert, если
+// включён -exit-on-alert и часы ушли дальше порога, иначе 0
+func (m *monitor) run(ctx context.Context) int {
+	wait := m.cfg.interval
+	for {
+		ok, alert := m.poll(ctx)
+		if alert && m.cfg.exitOnAlert {
+			return exitAlert
+		}
+		wait = nextWait(wait, m.cfg.interval, m.cfg.maxBackoff, ok)
+		if !ok {
+			m.log.Printf("следующая попытка через %v", wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(wait):
+		}
+	}
+}
+
+// poll выполняет один опрос: считает консенсус, записывает точку,
+// обновляет состояние alert. Возвращает успех опроса и то, превышен ли порог
+func (m *monitor) poll(ctx context.Context) (ok, alert bool) {
+	reports := queryAll(m.cfg.servers, m.cfg.samples, m.cfg.timeout, m.cfg.auth)
+	c, err := buildConsensus(reports)
+	now := time.Now()
+
+	s := monitorSample{Time: now}
+	if err != nil {
+		s.Failure = err.Error()
+		m.log.Printf("опрос не удался: %v", err)
+	} else {
+		s.Offset, s.Jitter, s.Error = c.Offset, c.Jitter, c.Error
+		s.Servers = len(c.Survivors)
+		s.RTT = meanRTT(reports, c.Survivors)
+	}
+	for _, r := range c.Rejected {
+		m.log.Printf("%s отклонён: %s", r.Server, r.Reason)
+	}
+
+	m.mu.Lock()
+	m.polls++
+	m.reports = reports
+	if err != nil {
+		m.failures++
+	} else {
+		m.lastSuccess = now
+		m.last = s
+	}
+	m.push(s)
+	m.mu.Unlock()
+
+	if m.cfg.record != nil {
+		if data, e := json.Marshal(s); e == nil {
+			_, _ = m.cfg.record.Write(append(data, '\n'))
+		}
+	}
+	if err != nil {
+		return false, false
+	}
+
+	alert = s.Offset > m.cfg.threshold || s.Offset < -m.cfg.threshold
+	m.mu.Lock()
+	changed := alert != m.alerting
+	m.alerting = alert
+	m.mu.Unlock()
+
+	if changed {
+		status := "resolved"
+		if alert {
+			status = "firing"
+			m.log.Printf("ALERT: смещение часов %s превышает порог %v", signed(s.Offset), m.cfg.threshold)
+		} else {
+			m.log.Printf("RESOLVED: смещение часов %s в пределах порога %v", signed(s.Offset), m.cfg.threshold)
+		}
+		m.notify(ctx, alertEvent{status, now, s.Offset, m.cfg.threshold, c.Survivors})
+	}
+	return true, alert
+}
+
+// push добавляет точку в кольцевой буфер; вызывается под m.mu
+func (m *monitor) push(s monitorSample) {
+	if m.cfg.history <= 0 {
+		return
+	}
+	if len(m.series) < m.cfg.history {
+		m.series = append(m.series, s)
+		return
+	}
+	m.series[m.next] = s
+	m.next = (m.next + 1) % m.cfg.history
+}
+
+// samples возвращает точки ряда от старых к новым
+func (m *monitor) samples() []monitorSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]monitorSample, 0, len(m.series))
+	out = append(out, m.series[m.next:]...)
+	return append(out, m.series[:m.next]...)
+}
+
+// notify отправляет событие alert на webhook, если он задан
+func (m *monitor) notify(ctx context.Context, ev alertEvent) {
+	if m.cfg.webhook == "" {
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.webhook, bytes.NewReader(body))
+	if err != nil {
+		m.log.Printf("webhook: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		m.log.Printf("webhook: %v", err)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		m.log.Printf("webhook: %s", resp.Status)
+	}
+}
+
+// meanRTT — средний RTT серверов из списка names
+func meanRTT(reports []*report, names []string) time.Duration {
+	var sum time.Duration
+	n := 0
+	for _, r := range reports {
+		for _, name := range names {
+			if r.Server == name {
+				sum += r.RTT
+				n++
+				break
+			}
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / time.Duration(n)
+}
+
+// handler — HTTP-интерфейс монитора: /metrics для Prometheus и /series с рядом в JSON
+func (m *monitor) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.writeMetrics(w)
+	})
+	mux.HandleFunc("/series", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = printJSON(w, m.samples())
+	})
+	return mux
+}
+
+// writeMetrics пишет состояние в текстовом формате Prometheus
+func (m *monitor) writeMetrics(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	gauge := func(name, help string, v float64) {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, v)
+	}
+	counter := func(name, help string, v uint64) {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+
+	counter("timemachine_polls_total", "Polls of the NTP servers.", m.polls)
+	counter("timemachine_poll_failures_total", "Polls that produced no consensus.", m.failures)
+	if !m.lastSuccess.IsZero() {
+		gauge("timemachine_offset_seconds", "Combined offset of the local clock (positive: local clock is behind).", m.last.Offset.Seconds())
+		gauge("timemachine_jitter_seconds", "Jitter of the combined offset.", m.last.Jitter.Seconds())
+		gauge("timemachine_error_bound_seconds", "Maximum error of the combined offset.", m.last.Error.Seconds())
+		gauge("timemachine_rtt_seconds", "Mean round-trip time of the servers used.", m.last.RTT.Seconds())
+		gauge("timemachine_last_success_timestamp_seconds", "Unix time of the last successful poll.", float64(m.lastSuccess.UnixNano())/1e9)
+	}
+	alerting := 0.0
+	if m.alerting {
+		alerting = 1
+	}
+	gauge("timemachine_alert", "1 if the offset is beyond the threshold.", alerting)
+	gauge("timemachine_threshold_seconds", "Alert threshold for the offset.", m.cfg.threshold.Seconds())
+
+	reports := append([]*report(nil), m.reports...)
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Server < reports[j].Server })
+	perServer := []struct {
+		name, help string
+		value      func(r *report) float64
+	}{
+		{"timemachine_server_up", "1 if the server answered with a valid response.", func(r *report) float64 {
+			if r.Valid {
+				return 1
+			}
+			return 0
+		}},
+		{"timemachine_server_offset_seconds", "Offset reported by the server.", func(r *report) float64 { return r.Offset.Seconds() }},
+		{"timemachine_server_rtt_seconds", "Round-trip time to the server.", func(r *report) float64 { return r.RTT.Seconds() }},
+		{"timemachine_server_jitter_seconds", "Jitter of the server samples.", func(r *report) float64 { return r.Jitter.Seconds() }},
+		{"timemachine_server_stratum", "Stratum of the server.", func(r *report) float64 { return float64(r.Stratum) }},
+	}
+	for _, metric := range perServer {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", metric.name, metric.help, metric.name)
+		for _, r := range reports {
+			if r.Time.IsZero() && metric.name != "timemachine_server_up" {
+				continue // сервер не ответил, кроме up сообщать нечего
+			}
+			_, _ = fmt.Fprintf(w, "%s{server=\"%s\"} %g\n", metric.name, escapeLabel(r.Server), metric.value(r))
+		}
+	}
+}
+
+// escapeLabel экранирует значение метки Prometheus
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
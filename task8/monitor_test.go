@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextWait(t *testing.T) {
+	tests := []struct {
+		cur  time.Duration
+		ok   bool
+		want time.Duration
+	}{
+		{time.Second, true, time.Second},
+		{time.Second, false, 2 * time.Second},
+		{8 * time.Second, false, 10 * time.Second}, // не больше maxBackoff
+		{10 * time.Second, true, time.Second},      // успех сбрасывает backoff
+	}
+	for _, tt := range tests {
+		if got := nextWait(tt.cur, time.Second, 10*time.Second, tt.ok); got != tt.want {
+			t.Errorf("nextWait(%v, ok=%v) = %v, want %v", tt.cur, tt.ok, got, tt.want)
+		}
+	}
+}
+
+func TestMonitorAlertAndMetrics(t *testing.T) {
+	srv, addr := startServer(t, 1, "GPS", func(s *sntpServer) {
+		s.offset = 500 * time.Millisecond
+	})
+
+	events := make(chan alertEvent, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev alertEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		events <- ev
+	}))
+	defer hook.Close()
+
+	var logs, record bytes.Buffer
+	m := newMonitor(monitorConfig{
+		servers:   []string{addr},
+		timeout:   time.Second,
+		samples:   1,
+		threshold: 100 * time.Millisecond,
+		webhook:   hook.URL,
+		history:   2,
+		record:    &record,
+	}, &logs)
+
+	ok, alert := m.poll(context.Background())
+	if !ok || !alert {
+		t.Fatalf("poll = ok %v alert %v, want alert; log:\n%s", ok, alert, logs.String())
+	}
+	select {
+	case ev := <-events:
+		if ev.Status != "firing" || ev.Offset < 400*time.Millisecond {
+			t.Errorf("event = %+v", ev)
+		}
+	default:
+		t.Fatal("webhook не вызван")
+	}
+	if !strings.Contains(logs.String(), "ALERT") {
+		t.Errorf("нет ALERT в логе:\n%s", logs.String())
+	}
+
+	// повторный опрос с тем же смещением не шлёт событие повторно
+	m.poll(context.Background())
+	if len(events) != 0 {
+		t.Error("повторное событие firing")
+	}
+
+	// часы вернулись — событие resolved
+	srv.mu.Lock()
+	srv.offset = 0
+	srv.mu.Unlock()
+	if ok, alert := m.poll(context.Background()); !ok || alert {
+		t.Fatalf("poll = ok %v alert %v, want no alert", ok, alert)
+	}
+	if ev := <-events; ev.Status != "resolved" {
+		t.Errorf("event = %+v, want resolved", ev)
+	}
+
+	// в кольцевом буфере остаются две последние точки
+	series := m.samples()
+	if len(series) != 2 || series[1].Offset > 50*time.Millisecond || series[0].Offset < 400*time.Millisecond {
+		t.Errorf("series = %+v", series)
+	}
+	if n := strings.Count(record.String(), "\n"); n != 3 {
+		t.Errorf("record: %d строк, want 3", n)
+	}
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"timemachine_polls_total 3",
+		"timemachine_poll_failures_total 0",
+		"timemachine_alert 0",
+		"# TYPE timemachine_offset_seconds gauge",
+		`timemachine_server_up{server="` + addr + `"} 1`,
+		`timemachine_server_stratum{server="` + addr + `"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("нет %q в /metrics:\n%s", want, body)
+		}
+	}
+}
+
+func TestMonitorFailure(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	m := newMonitor(monitorConfig{
+		servers:   []string{addr},
+		timeout:   100 * time.Millisecond,
+		samples:   1,
+		threshold: time.Second,
+		history:   10,
+	}, io.Discard)
+
+	if ok, _ := m.poll(context.Background()); ok {
+		t.Fatal("poll недоступного сервера прошёл успешно")
+	}
+	var buf bytes.Buffer
+	m.writeMetrics(&buf)
+	if !strings.Contains(buf.String(), "timemachine_poll_failures_total 1") ||
+		!strings.Contains(buf.String(), `timemachine_server_up{server="`+addr+`"} 0`) {
+		t.Errorf("metrics:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "timemachine_offset_seconds") {
+		t.Error("offset без единого успешного опроса")
+	}
+}
+
+func TestMonitorExitOnAlert(t *testing.T) {
+	addr := standIn{stratum: 1, refID: "GPS", offset: -2 * time.Second}.start(t)
+	m := newMonitor(monitorConfig{
+		servers:     []string{addr},
+		interval:    time.Hour,
+		maxBackoff:  time.Hour,
+		timeout:     time.Second,
+		samples:     1,
+		threshold:   time.Second,
+		exitOnAlert: true,
+	}, io.Discard)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if code := m.run(ctx); code != exitAlert {
+		t.Errorf("run = %d, want %d", code, exitAlert)
+	}
+}
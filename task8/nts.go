@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Параметры NTS (RFC 8915)
+const (
+	ntsKEPort        = 4460
+	ntsALPN          = "ntske/1"
+	ntsExporterLabel = "EXPORTER-network-time-security"
+	ntsProtoNTPv4    = 0
+	aeadAESSIVCMAC   = 15 // AEAD_AES_SIV_CMAC_256
+	ntsWantCookies   = 8  // сколько cookies держать про запас
+)
+
+// Типы записей NTS-KE
+const (
+	recEnd        = 0
+	recNextProto  = 1
+	recError      = 2
+	recWarning    = 3
+	recAEAD       = 4
+	recCookie     = 5
+	recNTPServer  = 6
+	recNTPPort    = 7
+	recCritical   = 0x8000
+	maxRecordBody = 1 << 14
+)
+
+// Типы полей расширения NTP для NTS
+const (
+	efUniqueID          = 0x0104
+	efCookie            = 0x0204
+	efCookiePlaceholder = 0x0304
+	efAuthenticator     = 0x0404
+)
+
+// Ошибки NTS
+var (
+	ErrNTSKeyExchange = errors.New("NTS-KE")
+	ErrNTSAuth        = errors.New("ответ не прошёл проверку NTS")
+	ErrNTSNak         = errors.New("сервер не принял cookie NTS (kiss-of-death NTSN)")
+)
+
+// ntsSession — результат NTS-KE: ключи и запас cookies для запросов к NTP-серверу
+type ntsSession struct {
+	ntpServer string // host:port для запросов NTP
+	c2s, s2c  *aesSIV
+
+	mu      sync.Mutex
+	cookies [][]byte
+}
+
+// ntsKeyExchange проводит NTS-KE с сервером addr (host:port) по TLS 1.3
+func ntsKeyExchange(addr string, conf *tls.Config, timeout time.Duration) (*ntsSession, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	conf = conf.Clone()
+	conf.NextProtos = []string{ntsALPN}
+	conf.MinVersion = tls.VersionTLS13
+	if conf.ServerName == "" {
+		conf.ServerName = host
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, conf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNTSKeyExchange, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != ntsALPN {
+		return nil, fmt.Errorf("%w: сервер не поддерживает %s", ErrNTSKeyExchange, ntsALPN)
+	}
+
+	var req bytes.Buffer
+	writeRecord(&req, recNextProto|recCritical, binary.BigEndian.AppendUint16(nil, ntsProtoNTPv4))
+	writeRecord(&req, recAEAD|recCritical, binary.BigEndian.AppendUint16(nil, aeadAESSIVCMAC))
+	writeRecord(&req, recEnd|recCritical, nil)
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNTSKeyExchange, err)
+	}
+
+	s := &ntsSession{}
+	ntpHost, ntpPort := host, "123"
+	var protoOK, aeadOK bool
+	for {
+		typ, body, err := readRecord(conn)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNTSKeyExchange, err)
+		}
+		switch typ &^ recCritical {
+		case recEnd:
+			if !protoOK || !aeadOK {
+				return nil, fmt.Errorf("%w: сервер не согласился на NTPv4 с AES-SIV-CMAC-256", ErrNTSKeyExchange)
+			}
+			if len(s.cookies) == 0 {
+				return nil, fmt.Errorf("%w: сервер не выдал cookies", ErrNTSKeyExchange)
+			}
+			if err := s.deriveKeys(&state); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrNTSKeyExchange, err)
+			}
+			s.ntpServer = net.JoinHostPort(ntpHost, ntpPort)
+			return s, nil
+		case recNextProto:
+			protoOK = len(body) == 2 && binary.BigEndian.Uint16(body) == ntsProtoNTPv4
+		case recAEAD:
+			aeadOK = len(body) == 2 && binary.BigEndian.Uint16(body) == aeadAESSIVCMAC
+		case recError:
+			return nil, fmt.Errorf("%w: сервер вернул ошибку %d", ErrNTSKeyExchange, recordCode(body))
+		case recWarning:
+			return nil, fmt.Errorf("%w: сервер вернул предупреждение %d", ErrNTSKeyExchange, recordCode(body))
+		case recCookie:
+			s.cookies = append(s.cookies, body)
+		case recNTPServer:
+			ntpHost = string(body)
+		case recNTPPort:
+			if len(body) != 2 {
+				return nil, fmt.Errorf("%w: неверная запись порта", ErrNTSKeyExchange)
+			}
+			ntpPort = strconv.Itoa(int(binary.BigEndian.Uint16(body)))
+		default:
+			if typ&recCritical != 0 {
+				return nil, fmt.Errorf("%w: неизвестная критическая запись %d", ErrNTSKeyExchange, typ&^recCritical)
+			}
+		}
+	}
+}
+
+// deriveKeys получает ключи C2S и S2C из TLS-сессии (RFC 8915, раздел 5.1)
+func (s *ntsSession) deriveKeys(state *tls.ConnectionState) error {
+	for i, dst := range []**aesSIV{&s.c2s, &s.s2c} {
+		ctx := []byte{0, ntsProtoNTPv4, 0, aeadAESSIVCMAC, byte(i)}
+		key, err := state.ExportKeyingMaterial(ntsExporterLabel, ctx, 32)
+		if err != nil {
+			return err
+		}
+		if *dst, err = newAESSIV(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeCookie забирает cookie для очередного запроса и сообщает, сколько осталось
+func (s *ntsSession) takeCookie() (cookie []byte, left int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cookies) == 0 {
+		return nil, 0
+	}
+	cookie = s.cookies[0]
+	s.cookies = s.cookies[1:]
+	return cookie, len(s.cookies)
+}
+
+func (s *ntsSession) addCookies(cookies [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cookies = append(s.cookies, cookies...)
+}
+
+// ntsExchange — расширение ntp.Extension для одного запроса NTS:
+// добавляет cookie и аутентификатор к запросу и проверяет ответ
+type ntsExchange struct {
+	s   *ntsSession
+	uid []byte
+}
+
+func (e *ntsExchange) ProcessQuery(buf *bytes.Buffer) error {
+	cookie, left := e.s.takeCookie()
+	if cookie == nil {
+		return fmt.Errorf("%w: закончились cookies", ErrNTSAuth)
+	}
+	e.uid = make([]byte, 32)
+	if _, err := rand.Read(e.uid); err != nil {
+		return err
+	}
+	writeField(buf, efUniqueID, e.uid)
+	writeField(buf, efCookie, cookie)
+	for i := left + 1; i < ntsWantCookies; i++ {
+		writeField(buf, efCookiePlaceholder, make([]byte, len(cookie)))
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	writeAuthenticator(buf, e.s.c2s, nonce, nil)
+	return nil
+}
+
+func (e *ntsExchange) ProcessResponse(buf []byte) error {
+	fields, err := parseFields(buf)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNTSAuth, err)
+	}
+	// Поля после аутентификатора не подписаны (RFC 8915, раздел 5.7),
+	// поэтому Unique Identifier ищем только в associated data до него.
+	// У NAK аутентификатора нет — проверить в нём можно лишь uid
+	auth := -1
+	for i, f := range fields {
+		if f.typ == efAuthenticator {
+			auth = i
+			break
+		}
+	}
+	signed := fields
+	if auth >= 0 {
+		signed = fields[:auth]
+	}
+	uid := false
+	for _, f := range signed {
+		if f.typ == efUniqueID && bytes.Equal(f.body, e.uid) {
+			uid = true
+		}
+	}
+	if !uid {
+		return fmt.Errorf("%w: нет нашего Unique Identifier", ErrNTSAuth)
+	}
+	if len(buf) >= packetSize && buf[1] == 0 && string(buf[12:16]) == "NTSN" {
+		return ErrNTSNak
+	}
+
+	if auth < 0 {
+		return fmt.Errorf("%w: нет поля аутентификатора", ErrNTSAuth)
+	}
+	f := fields[auth]
+	plaintext, err := openAuthenticator(e.s.s2c, buf[:f.start], f.body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNTSAuth, err)
+	}
+	inner, err := parseFields(append(make([]byte, packetSize), plaintext...))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNTSAuth, err)
+	}
+	var cookies [][]byte
+	for _, f := range inner {
+		if f.typ == efCookie {
+			cookies = append(cookies, f.body)
+		}
+	}
+	e.s.addCookies(cookies)
+	return nil
+}
+
+// writeRecord пишет запись NTS-KE: тип, длина тела, тело
+func writeRecord(w *bytes.Buffer, typ uint16, body []byte) {
+	_ = binary.Write(w, binary.BigEndian, [2]uint16{typ, uint16(len(body))})
+	w.Write(body)
+}
+
+// readRecord читает одну запись NTS-KE
+func readRecord(r io.Reader) (typ uint16, body []byte, err error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	typ = binary.BigEndian.Uint16(hdr[:2])
+	n := binary.BigEndian.Uint16(hdr[2:])
+	if n > maxRecordBody {
+		return 0, nil, fmt.Errorf("слишком длинная запись %d", typ)
+	}
+	body = make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return typ, body, err
+}
+
+// recordCode — код из записи Error или Warning
+func recordCode(body []byte) int {
+	if len(body) != 2 {
+		return -1
+	}
+	return int(binary.BigEndian.Uint16(body))
+}
+
+// field — поле расширения NTP; start — его смещение от начала пакета
+type field struct {
+	typ   uint16
+	body  []byte
+	start int
+}
+
+// writeField пишет поле расширения (RFC 7822): тип, длина всего поля,
+// тело, дополненное нулями до кратной 4 длины и не короче 16 байт
+func writeField(w *bytes.Buffer, typ uint16, body []byte) {
+	n := max(4+(len(body)+3)&^3, 16)
+	_ = binary.Write(w, binary.BigEndian, [2]uint16{typ, uint16(n)})
+	w.Write(body)
+	w.Write(make([]byte, n-4-len(body)))
+}
+
+// parseFields разбирает поля расширения после заголовка пакета
+func parseFields(pkt []byte) ([]field, error) {
+	var fields []field
+	for off := packetSize; off < len(pkt); {
+		if len(pkt)-off < 4 {
+			return nil, errors.New("обрезанное поле расширения")
+		}
+		typ := binary.BigEndian.Uint16(pkt[off:])
+		n := int(binary.BigEndian.Uint16(pkt[off+2:]))
+		if n < 4 || n%4 != 0 || off+n > len(pkt) {
+			return nil, fmt.Errorf("неверная длина поля расширения %#04x", typ)
+		}
+		fields = append(fields, field{typ, pkt[off+4 : off+n], off})
+		off += n
+	}
+	return fields, nil
+}
+
+// writeAuthenticator дописывает поле NTS Authenticator and Encrypted Extension
+// Fields: пакет до него подписывается ключом key, plaintext шифруется
+func writeAuthenticator(w *bytes.Buffer, key *aesSIV, nonce, plaintext []byte) {
+	ciphertext := key.seal(plaintext, w.Bytes(), nonce)
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(nonce)))
+	body = binary.BigEndian.AppendUint16(body, uint16(len(ciphertext)))
+	body = append(body, nonce...)
+	body = append(body, make([]byte, (4-len(nonce)%4)%4)...)
+	body = append(body, ciphertext...)
+	writeField(w, efAuthenticator, body)
+}
+
+// openAuthenticator проверяет поле аутентификатора body по пакету ad
+// перед ним и возвращает расшифрованные поля
+func openAuthenticator(key *aesSIV, ad, body []byte) ([]byte, error) {
+	if len(body) < 4 {
+		return nil, errors.New("короткий аутентификатор")
+	}
+	nonceLen := int(binary.BigEndian.Uint16(body))
+	ctLen := int(binary.BigEndian.Uint16(body[2:]))
+	ctStart := 4 + (nonceLen+3)&^3
+	if ctStart+ctLen > len(body) {
+		return nil, errors.New("аутентификатор длиннее поля")
+	}
+	return key.open(body[ctStart:ctStart+ctLen], ad, body[4:4+nonceLen])
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// selfSigned выпускает самоподписанный сертификат для 127.0.0.1
+func selfSigned(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "timeMachine test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}, cert
+}
+
+// ntsStandIn — NTS-KE и NTS-сервер NTP в одном процессе. Ключи клиента
+// сервер хранит в cookies, зашифрованных своим мастер-ключом
+type ntsStandIn struct {
+	srv     *sntpServer // заголовок ответа строит обычный SNTP-сервер
+	udp     net.PacketConn
+	cert    *x509.Certificate
+	keAddr  string
+	keCount atomic.Int32 // сколько раз клиенты проходили NTS-KE
+
+	mu      sync.Mutex
+	master  *aesSIV
+	tamper  bool // портить аутентификатор ответов
+	uidLast bool // класть Unique Identifier после аутентификатора, вне подписи
+}
+
+func startNTSStandIn(t *testing.T, offset time.Duration) *ntsStandIn {
+	t.Helper()
+	tlsCert, cert := selfSigned(t)
+	ke, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{ntsALPN},
+		MinVersion:   tls.VersionTLS13,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := startServer(t, 1, "NTS", func(s *sntpServer) { s.offset = offset })
+
+	s := &ntsStandIn{srv: srv, udp: udp, cert: cert, keAddr: ke.Addr().String()}
+	s.rotateMaster()
+	go func() {
+		for {
+			conn, err := ke.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleKE(conn.(*tls.Conn))
+		}
+	}()
+	go s.serveNTP()
+	t.Cleanup(func() {
+		_ = ke.Close()
+		_ = udp.Close()
+	})
+	return s
+}
+
+// rotateMaster меняет мастер-ключ: выданные раньше cookies перестают приниматься
+func (s *ntsStandIn) rotateMaster() {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	m, _ := newAESSIV(key)
+	s.mu.Lock()
+	s.master = m
+	s.mu.Unlock()
+}
+
+func (s *ntsStandIn) setTamper(v bool) {
+	s.mu.Lock()
+	s.tamper = v
+	s.mu.Unlock()
+}
+
+func (s *ntsStandIn) setUIDLast(v bool) {
+	s.mu.Lock()
+	s.uidLast = v
+	s.mu.Unlock()
+}
+
+// auth — настройки клиента, доверяющие сертификату stand-in
+func (s *ntsStandIn) auth() *authConfig {
+	_, port, _ := net.SplitHostPort(s.keAddr)
+	pool := x509.NewCertPool()
+	pool.AddCert(s.cert)
+	return &authConfig{nts: true, ntsPort: port, tls: &tls.Config{RootCAs: pool}}
+}
+
+// cookie шифрует ключи клиента мастер-ключом: nonce, затем seal(c2s||s2c)
+func (s *ntsStandIn) cookie(keys []byte) []byte {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(nonce, s.master.seal(keys, nonce)...)
+}
+
+func (s *ntsStandIn) openCookie(cookie []byte) ([]byte, error) {
+	if len(cookie) < 16 {
+		return nil, errSIVOpen
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.master.open(cookie[16:], cookie[:16])
+}
+
+func (s *ntsStandIn) handleKE(conn *tls.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for {
+		typ, _, err := readRecord(conn)
+		if err != nil {
+			return
+		}
+		if typ&^recCritical == recEnd {
+			break
+		}
+	}
+	s.keCount.Add(1)
+
+	state := conn.ConnectionState()
+	var keys []byte
+	for dir := byte(0); dir < 2; dir++ {
+		k, err := state.ExportKeyingMaterial(ntsExporterLabel, []byte{0, ntsProtoNTPv4, 0, aeadAESSIVCMAC, dir}, 32)
+		if err != nil {
+			return
+		}
+		keys = append(keys, k...)
+	}
+
+	_, port, _ := net.SplitHostPort(s.udp.LocalAddr().String())
+	p, _ := strconv.Atoi(port)
+	var resp bytes.Buffer
+	writeRecord(&resp, recNextProto|recCritical, []byte{0, ntsProtoNTPv4})
+	writeRecord(&resp, recAEAD, []byte{0, aeadAESSIVCMAC})
+	for i := 0; i < ntsWantCookies; i++ {
+		writeRecord(&resp, recCookie, s.cookie(keys))
+	}
+	writeRecord(&resp, recNTPServer, []byte("127.0.0.1"))
+	writeRecord(&resp, recNTPPort, binary.BigEndian.AppendUint16(nil, uint16(p)))
+	writeRecord(&resp, recEnd|recCritical, nil)
+	_, _ = conn.Write(resp.Bytes())
+}
+
+func (s *ntsStandIn) serveNTP() {
+	buf := make([]byte, 4096)
+	for {
+		n, client, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.respond(buf[:n], client, s.srv.now()); resp != nil {
+			_, _ = s.udp.WriteTo(resp, client)
+		}
+	}
+}
+
+// respond отвечает на NTS-запрос; на непрочитанный cookie или неверный
+// аутентификатор — kiss-of-death NTSN
+func (s *ntsStandIn) respond(req []byte, client net.Addr, recv time.Time) []byte {
+	fields, err := parseFields(req)
+	if err != nil {
+		return nil
+	}
+	var uid, cookie []byte
+	placeholders := 0
+	var keys []byte
+	for _, f := range fields {
+		switch f.typ {
+		case efUniqueID:
+			uid = f.body
+		case efCookie:
+			cookie = f.body
+		case efCookiePlaceholder:
+			placeholders++
+		case efAuthenticator:
+			if keys, err = s.openCookie(cookie); err != nil {
+				break
+			}
+			c2s, _ := newAESSIV(keys[:32])
+			if _, err = openAuthenticator(c2s, req[:f.start], f.body); err != nil {
+				keys = nil
+			}
+		}
+	}
+
+	hdr := s.srv.respond(req[:packetSize], client, recv)
+	var out bytes.Buffer
+	if keys == nil {
+		hdr[1] = 0
+		copy(hdr[12:16], "NTSN")
+		out.Write(hdr)
+		writeField(&out, efUniqueID, uid)
+		return out.Bytes()
+	}
+	s.mu.Lock()
+	tamper, uidLast := s.tamper, s.uidLast
+	s.mu.Unlock()
+
+	out.Write(hdr)
+	if !uidLast {
+		writeField(&out, efUniqueID, uid)
+	}
+	var plain bytes.Buffer
+	for i := 0; i <= placeholders; i++ {
+		writeField(&plain, efCookie, s.cookie(keys))
+	}
+	s2c, _ := newAESSIV(keys[32:])
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	writeAuthenticator(&out, s2c, nonce, plain.Bytes())
+	if uidLast {
+		writeField(&out, efUniqueID, uid)
+	}
+
+	resp := out.Bytes()
+	if tamper {
+		resp[len(resp)-1] ^= 1
+	}
+	return resp
+}
+
+func TestNTSQuery(t *testing.T) {
+	s := startNTSStandIn(t, 2*time.Second)
+	auth := s.auth()
+
+	for i := 0; i < 10; i++ {
+		r, err := query("127.0.0.1", time.Second, auth)
+		if err != nil {
+			t.Fatalf("запрос %d: %v", i+1, err)
+		}
+		if !r.Valid || r.Auth != authNTS {
+			t.Fatalf("запрос %d: valid=%v auth=%q err=%s", i+1, r.Valid, r.Auth, r.Error)
+		}
+		if r.Offset < 1900*time.Millisecond || r.Offset > 2100*time.Millisecond {
+			t.Errorf("offset = %v, want ~2s", r.Offset)
+		}
+	}
+	// cookies пополняются из ответов: NTS-KE нужен один раз
+	if n := s.keCount.Load(); n != 1 {
+		t.Errorf("NTS-KE %d раз, want 1", n)
+	}
+	sess, _ := auth.session("127.0.0.1", time.Second)
+	if len(sess.cookies) != ntsWantCookies {
+		t.Errorf("cookies = %d, want %d", len(sess.cookies), ntsWantCookies)
+	}
+}
+
+func TestNTSRejectsForgedResponse(t *testing.T) {
+	s := startNTSStandIn(t, 0)
+	auth := s.auth()
+	if _, err := query("127.0.0.1", time.Second, auth); err != nil {
+		t.Fatal(err)
+	}
+
+	s.setTamper(true)
+	if _, err := query("127.0.0.1", time.Second, auth); !errors.Is(err, ErrNTSAuth) {
+		t.Fatalf("подделанный ответ: err = %v, want ErrNTSAuth", err)
+	}
+
+	// после ошибки сессия сброшена, следующий запрос заново проходит NTS-KE
+	s.setTamper(false)
+	if r, err := query("127.0.0.1", time.Second, auth); err != nil || !r.Valid {
+		t.Fatalf("после сброса: %v", err)
+	}
+	if n := s.keCount.Load(); n != 2 {
+		t.Errorf("NTS-KE %d раз, want 2", n)
+	}
+}
+
+func TestNTSRejectsUnsignedUniqueID(t *testing.T) {
+	s := startNTSStandIn(t, 0)
+	auth := s.auth()
+	// Unique Identifier после аутентификатора не подписан: его мог
+	// дописать кто угодно, ответ засчитывать нельзя
+	s.setUIDLast(true)
+	if _, err := query("127.0.0.1", time.Second, auth); !errors.Is(err, ErrNTSAuth) {
+		t.Fatalf("uid вне подписи: err = %v, want ErrNTSAuth", err)
+	}
+	s.setUIDLast(false)
+	if r, err := query("127.0.0.1", time.Second, auth); err != nil || !r.Valid {
+		t.Fatalf("uid в подписи: %v", err)
+	}
+}
+
+func TestNTSNak(t *testing.T) {
+	s := startNTSStandIn(t, 0)
+	auth := s.auth()
+	if _, err := query("127.0.0.1", time.Second, auth); err != nil {
+		t.Fatal(err)
+	}
+	s.rotateMaster()
+	if _, err := query("127.0.0.1", time.Second, auth); !errors.Is(err, ErrNTSNak) {
+		t.Errorf("err = %v, want ErrNTSNak", err)
+	}
+}
+
+func TestNTSUntrustedCertificate(t *testing.T) {
+	s := startNTSStandIn(t, 0)
+	auth := s.auth()
+	auth.tls = &tls.Config{} // системные корни не знают самоподписанный сертификат
+	if _, err := query("127.0.0.1", time.Second, auth); !errors.Is(err, ErrNTSKeyExchange) {
+		t.Errorf("err = %v, want ErrNTSKeyExchange", err)
+	}
+}
+
+func TestRunNTS(t *testing.T) {
+	s := startNTSStandIn(t, 0)
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(s.keAddr)
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-s", "127.0.0.1", "-nts", "-nts-port", port, "-nts-ca", ca}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("code = %d, stderr: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "auth:            nts\n") {
+		t.Errorf("stdout:\n%s", stdout.String())
+	}
+}
+
+// symmetricStandIn — SNTP-сервер с симметричным ключом MD5: подписывает
+// ответы на подписанные тем же ключом запросы, на остальные отвечает crypto-NAK
+func symmetricStandIn(t *testing.T, keyID uint32, key string) string {
+	t.Helper()
+	srv, _ := startServer(t, 2, "10.0.0.1")
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	sign := func(pkt []byte, id uint32) []byte {
+		sum := md5.Sum(append([]byte(key), pkt...))
+		return append(binary.BigEndian.AppendUint32(pkt, id), sum[:]...)
+	}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, client, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := buf[:n]
+			resp := srv.respond(req, client, srv.now())
+			if resp == nil {
+				continue
+			}
+			if n == packetSize+20 && bytes.Equal(sign(req[:packetSize:packetSize], keyID), req) {
+				resp = sign(resp, keyID)
+			} else {
+				resp = append(resp, 0, 0, 0, 0) // crypto-NAK
+			}
+			_, _ = conn.WriteTo(resp, client)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func writeKeys(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ntp.keys")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSymmetricKey(t *testing.T) {
+	addr := symmetricStandIn(t, 7, "s3cret")
+
+	good := &authConfig{key: &symmetricKey{7, keyTypes["MD5"], "ASCII:s3cret"}}
+	r, err := query(addr, time.Second, good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Valid || r.Auth != authSymmetric {
+		t.Errorf("valid=%v auth=%q err=%s", r.Valid, r.Auth, r.Error)
+	}
+
+	for _, k := range []symmetricKey{
+		{7, keyTypes["MD5"], "ASCII:wrong"},
+		{8, keyTypes["MD5"], "ASCII:s3cret"},
+	} {
+		r, err := query(addr, time.Second, &authConfig{key: &k})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Valid || r.Error != ErrAuthFailed.Error() {
+			t.Errorf("ключ %d %s: valid=%v err=%q", k.id, k.key, r.Valid, r.Error)
+		}
+	}
+
+	// без проверки подлинности подписанный ответ принимается как обычный
+	if r, err := query(addr, time.Second, nil); err != nil || !r.Valid {
+		t.Errorf("без ключа: %v %+v", err, r)
+	}
+}
+
+func TestRunNTSFallbackToKey(t *testing.T) {
+	addr := symmetricStandIn(t, 3, "fallback-key")
+	keys := writeKeys(t, "# id type key\n1 SHA1 0123456789abcdef0123456789abcdef01234567\n3 md5 fallback-key\n")
+
+	// на порту NTS-KE никто не слушает
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	_ = ln.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-s", addr, "-nts", "-nts-port", port, "-keys", keys, "-key", "3", "-json"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("code = %d, stderr: %s", code, stderr.String())
+	}
+	var reports []report
+	if err := json.Unmarshal(stdout.Bytes(), &reports); err != nil {
+		t.Fatal(err)
+	}
+	r := reports[0]
+	if !r.Valid || r.Auth != authSymmetric || !strings.Contains(r.NTSError, "NTS-KE") {
+		t.Errorf("report = %+v", r)
+	}
+
+	// без ключа откатываться некуда
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"-s", addr, "-nts", "-nts-port", port}, &stdout, &stderr); code != 1 {
+		t.Errorf("без ключа: code = %d, want 1", code)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	keys, err := loadKeys(writeKeys(t, "1 M abc # comment\n\n42 SHA1 0123456789abcdef0123456789abcdef01234567\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys[1].key != "ASCII:abc" || keys[42].key != "HEX:0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("keys = %+v", keys)
+	}
+
+	for _, bad := range []string{
+		"1 MD5\n",
+		"0 MD5 abc\n",
+		"70000 MD5 abc\n",
+		"1 RC4 abc\n",
+		"1 SHA1 not-hex-but-longer-than-twenty\n",
+	} {
+		if _, err := loadKeys(writeKeys(t, bad)); err == nil {
+			t.Errorf("%q: нет ошибки", bad)
+		}
+	}
+}
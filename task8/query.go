@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// Ошибки проверки ответа сервера
+var (
+	ErrKissOfDeath = errors.New("сервер прислал kiss-of-death")
+	ErrNotInSync   = errors.New("сервер не синхронизирован")
+	ErrAuthFailed  = errors.New("подпись ответа не сошлась с ключом")
+)
+
+// report — разобранный ответ одного NTP-сервера.
+// Длительности в JSON — целые наносекунды (поля с суффиксом _ns)
+type report struct {
+	Server         string        `json:"server"`
+	Source         string        `json:"source,omitempty"` // ntp, roughtime или http
+	Time           time.Time     `json:"time"`
+	Offset         time.Duration `json:"offset_ns"`
+	RTT            time.Duration `json:"rtt_ns"`
+	Stratum        uint8         `json:"stratum"`
+	ReferenceID    string        `json:"reference_id"`
+	ReferenceTime  time.Time     `json:"reference_time"`
+	Leap           string        `json:"leap"`
+	Precision      time.Duration `json:"precision_ns"`
+	RootDelay      time.Duration `json:"root_delay_ns"`
+	RootDispersion time.Duration `json:"root_dispersion_ns"`
+	RootDistance   time.Duration `json:"root_distance_ns"`
+	Poll           time.Duration `json:"poll_ns"`
+	Version        int           `json:"version"`
+	KissCode       string        `json:"kiss_code,omitempty"`
+	Jitter         time.Duration `json:"jitter_ns,omitempty"`
+	Auth           string        `json:"auth,omitempty"`      // nts, symmetric или пусто
+	NTSError       string        `json:"nts_error,omitempty"` // почему NTS не удался, если откатились на ключ
+	Valid          bool          `json:"valid"`
+	Error          string        `json:"error,omitempty"`
+}
+
+// query опрашивает сервер и заполняет отчёт. Ошибка сети, протокола
+// или NTS возвращается как есть, ошибка проверки ответа (в том числе
+// симметричной подписи) попадает в report.Error
+func query(server string, timeout time.Duration, auth *authConfig) (*report, error) {
+	opts := ntp.QueryOptions{Timeout: timeout}
+	addr, method, ntsErr, err := auth.prepare(server, &opts)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ntp.QueryWithOptions(addr, opts)
+	if err != nil {
+		if method == authNTS {
+			auth.forget(server)
+		}
+		return nil, err
+	}
+
+	rep := &report{
+		Server:         server,
+		Time:           r.Time,
+		Offset:         r.ClockOffset,
+		RTT:            r.RTT,
+		Stratum:        r.Stratum,
+		ReferenceID:    r.ReferenceString(),
+		ReferenceTime:  r.ReferenceTime,
+		Leap:           leapString(r.Leap),
+		Precision:      r.Precision,
+		RootDelay:      r.RootDelay,
+		RootDispersion: r.RootDispersion,
+		RootDistance:   r.RootDistance,
+		Poll:           r.Poll,
+		Version:        r.Version,
+		KissCode:       r.KissCode,
+		Auth:           method,
+	}
+	if ntsErr != nil {
+		rep.NTSError = ntsErr.Error()
+	}
+	if err := validate(r); err != nil {
+		rep.Error = err.Error()
+	} else {
+		rep.Valid = true
+	}
+	return rep, nil
+}
+
+// sample делает n запросов к серверу и, как фильтр часов NTP, берёт ответ
+// с наименьшим RTT: на него меньше всего повлияла задержка в сети.
+// Jitter — среднеквадратичное отклонение остальных смещений от выбранного,
+// но не меньше точности часов сервера
+func sample(server string, n int, timeout time.Duration, auth *authConfig) (*report, error) {
+	var best *report
+	var offsets []time.Duration
+	var lastErr error
+	for i := 0; i < n; i++ {
+		r, err := query(server, timeout, auth)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !r.Valid {
+			// отклонённый ответ (KoD, нет синхронизации) не повторяем
+			return r, nil
+		}
+		offsets = append(offsets, r.Offset)
+		if best == nil || r.RTT < best.RTT {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil, lastErr
+	}
+
+	var sum float64
+	for _, o := range offsets {
+		d := float64(o - best.Offset)
+		sum += d * d
+	}
+	best.Jitter = time.Duration(math.Sqrt(sum / float64(len(offsets))))
+	best.Jitter = max(best.Jitter, best.Precision)
+	return best, nil
+}
+
+// queryAll опрашивает серверы параллельно; отчёты идут в порядке servers.
+// Для неответившего сервера отчёт содержит только Server и Error
+func queryAll(servers []string, samples int, timeout time.Duration, auth *authConfig) []*report {
+	reports := make([]*report, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := sample(s, samples, timeout, auth)
+			if err != nil {
+				r = &report{Server: s, Error: err.Error()}
+			}
+			reports[i] = r
+		}()
+	}
+	wg.Wait()
+	return reports
+}
+
+// validate отбрасывает ответы, по которым нельзя ставить часы:
+// неподлинные, kiss-of-death, несинхронизированный сервер
+// и всё, что не проходит ntp.Validate
+func validate(r *ntp.Response) error {
+	// подделанному ответу нельзя верить и в kiss-of-death
+	if err := r.Validate(); errors.Is(err, ntp.ErrAuthFailed) {
+		return ErrAuthFailed
+	}
+	if r.IsKissOfDeath() {
+		return fmt.Errorf("%w: %s", ErrKissOfDeath, r.KissCode)
+	}
+	if r.Leap == ntp.LeapNotInSync {
+		return ErrNotInSync
+	}
+	return r.Validate()
+}
+
+// leapString — название индикатора високосной секунды
+func leapString(l ntp.LeapIndicator) string {
+	switch l {
+	case ntp.LeapNoWarning:
+		return "none"
+	case ntp.LeapAddSecond:
+		return "insert"
+	case ntp.LeapDelSecond:
+		return "delete"
+	default:
+		return "unsynchronized"
+	}
+}
+
+// signed печатает смещение со знаком: +1.5ms, -20µs
+func signed(d time.Duration) string {
+	if d < 0 {
+		return d.String()
+	}
+	return "+" + d.String()
+}
+
+// printText печатает отчёт в человекочитаемом виде
+func printText(w io.Writer, r *report) {
+	if r.Source == sourceRoughtime || r.Source == sourceHTTP {
+		printCoarse(w, r)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "server:          %s\n", r.Server)
+	_, _ = fmt.Fprintf(w, "time:            %s\n", r.Time.Local().Format(time.RFC3339Nano))
+	_, _ = fmt.Fprintf(w, "offset:          %s\n", signed(r.Offset))
+	_, _ = fmt.Fprintf(w, "rtt:             %v\n", r.RTT)
+	_, _ = fmt.Fprintf(w, "stratum:         %d\n", r.Stratum)
+	_, _ = fmt.Fprintf(w, "reference id:    %s\n", r.ReferenceID)
+	_, _ = fmt.Fprintf(w, "reference time:  %s\n", r.ReferenceTime.Local().Format(time.RFC3339Nano))
+	_, _ = fmt.Fprintf(w, "leap:            %s\n", r.Leap)
+	_, _ = fmt.Fprintf(w, "precision:       %v\n", r.Precision)
+	_, _ = fmt.Fprintf(w, "root delay:      %v\n", r.RootDelay)
+	_, _ = fmt.Fprintf(w, "root dispersion: %v\n", r.RootDispersion)
+	_, _ = fmt.Fprintf(w, "root distance:   %v\n", r.RootDistance)
+	_, _ = fmt.Fprintf(w, "poll:            %v\n", r.Poll)
+	_, _ = fmt.Fprintf(w, "version:         %d\n", r.Version)
+	switch {
+	case r.NTSError != "":
+		_, _ = fmt.Fprintf(w, "auth:            %s (NTS failed: %s)\n", r.Auth, r.NTSError)
+	case r.Auth != "":
+		_, _ = fmt.Fprintf(w, "auth:            %s\n", r.Auth)
+	}
+	if r.Valid {
+		_, _ = fmt.Fprintln(w, "status:          ok")
+	} else {
+		_, _ = fmt.Fprintf(w, "status:          invalid: %s\n", r.Error)
+	}
+}
+
+// printCoarse печатает отчёт источника без полей NTP (Roughtime, HTTP Date)
+func printCoarse(w io.Writer, r *report) {
+	_, _ = fmt.Fprintf(w, "server:          %s\n", r.Server)
+	_, _ = fmt.Fprintf(w, "source:          %s\n", r.Source)
+	_, _ = fmt.Fprintf(w, "time:            %s\n", r.Time.Local().Format(time.RFC3339Nano))
+	_, _ = fmt.Fprintf(w, "offset:          %s ± %v\n", signed(r.Offset), r.RootDistance)
+	_, _ = fmt.Fprintf(w, "rtt:             %v\n", r.RTT)
+	if r.Valid {
+		_, _ = fmt.Fprintln(w, "status:          ok")
+	} else {
+		_, _ = fmt.Fprintf(w, "status:          invalid: %s\n", r.Error)
+	}
+}
+
+// printJSON печатает значение как JSON с отступами
+// (массив отчётов или итог опроса в режиме консенсуса)
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printConsensus печатает краткую таблицу серверов и итог консенсуса
+func printConsensus(w io.Writer, reports []*report, c *consensus, err error) {
+	_, _ = fmt.Fprintf(w, "%-30s %14s %12s %12s %7s  %s\n", "server", "offset", "rtt", "jitter", "stratum", "status")
+	for _, r := range reports {
+		status := "ok"
+		if !r.Valid {
+			status = r.Error
+		}
+		if r.Time.IsZero() {
+			_, _ = fmt.Fprintf(w, "%-30s %14s %12s %12s %7s  %s\n", r.Server, "-", "-", "-", "-", status)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%-30s %14s %12v %12v %7d  %s\n",
+			r.Server, signed(r.Offset), r.RTT, r.Jitter, r.Stratum, status)
+	}
+	_, _ = fmt.Fprintln(w)
+
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "consensus:       нет: %v\n", err)
+	} else {
+		_, _ = fmt.Fprintf(w, "offset:          %s ± %v\n", signed(c.Offset), c.Error)
+		_, _ = fmt.Fprintf(w, "interval:        [%s, %s]\n", signed(c.Low), signed(c.High))
+		_, _ = fmt.Fprintf(w, "jitter:          %v\n", c.Jitter)
+		_, _ = fmt.Fprintf(w, "used:            %s\n", strings.Join(c.Survivors, ", "))
+	}
+	if len(c.Rejected) > 0 {
+		_, _ = fmt.Fprintln(w, "rejected:")
+		for _, r := range c.Rejected {
+			_, _ = fmt.Fprintf(w, "  %s: %s\n", r.Server, r.Reason)
+		}
+	}
+}
+
+// printSync печатает итог sync: смещение, отклонённые серверы и что сделано с часами
+func printSync(w io.Writer, s syncReport) {
+	c := s.Consensus
+	if len(c.Survivors) == 0 {
+		_, _ = fmt.Fprintln(w, "consensus:       нет")
+	} else {
+		_, _ = fmt.Fprintf(w, "offset:          %s ± %v\n", signed(c.Offset), c.Error)
+		_, _ = fmt.Fprintf(w, "used:            %s\n", strings.Join(c.Survivors, ", "))
+	}
+	for _, r := range c.Rejected {
+		_, _ = fmt.Fprintf(w, "rejected:        %s: %s\n", r.Server, r.Reason)
+	}
+
+	a := s.Adjustment
+	switch {
+	case a == nil:
+		_, _ = fmt.Fprintln(w, "action:          none")
+	case a.DryRun:
+		_, _ = fmt.Fprintf(w, "action:          %s by %s (dry run, clock not changed)\n", a.Method, signed(a.Offset))
+	case a.Applied:
+		_, _ = fmt.Fprintf(w, "action:          %s by %s\n", a.Method, signed(a.Offset))
+	default:
+		_, _ = fmt.Fprintf(w, "action:          %s by %s failed\n", a.Method, signed(a.Offset))
+	}
+}
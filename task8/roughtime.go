@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Roughtime (протокол Google): сервер подписывает своё время вместе
+// с nonce клиента, поэтому ответ нельзя подделать или повторить
+const (
+	roughtimeRequestSize = 1024 // запрос дополняется до этого размера (защита от усиления)
+	roughtimeNonceSize   = 64
+)
+
+// Контексты подписей
+var (
+	roughtimeDelegationContext = []byte("RoughTime v1 delegation signature--\x00")
+	roughtimeResponseContext   = []byte("RoughTime v1 response signature\x00")
+)
+
+// Ошибки Roughtime
+var (
+	ErrRoughtimeSignature = errors.New("подпись Roughtime не сошлась")
+	ErrRoughtimeChain     = errors.New("цепочка Roughtime нарушена: сервер ответил временем раньше предыдущего")
+)
+
+// rtTag — тег сообщения Roughtime: четыре ASCII-байта, прочитанные как little-endian uint32
+type rtTag uint32
+
+func tag(s string) rtTag {
+	var b [4]byte
+	copy(b[:], s)
+	return rtTag(binary.LittleEndian.Uint32(b[:]))
+}
+
+var (
+	tagNONC = tag("NONC")
+	tagPAD  = tag("PAD\xff")
+	tagSIG  = tag("SIG")
+	tagPATH = tag("PATH")
+	tagSREP = tag("SREP")
+	tagCERT = tag("CERT")
+	tagINDX = tag("INDX")
+	tagROOT = tag("ROOT")
+	tagMIDP = tag("MIDP")
+	tagRADI = tag("RADI")
+	tagDELE = tag("DELE")
+	tagMINT = tag("MINT")
+	tagMAXT = tag("MAXT")
+	tagPUBK = tag("PUBK")
+)
+
+// encodeMessage кодирует сообщение Roughtime: число тегов, смещения
+// значений (кроме первого), теги по возрастанию, значения
+func encodeMessage(msg map[rtTag][]byte) []byte {
+	tags := make([]rtTag, 0, len(msg))
+	for t := range msg {
+		tags = append(tags, t)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(tags)))
+	off := 0
+	for i, t := range tags {
+		if i > 0 {
+			out = binary.LittleEndian.AppendUint32(out, uint32(off))
+		}
+		off += len(msg[t])
+	}
+	for _, t := range tags {
+		out = binary.LittleEndian.AppendUint32(out, uint32(t))
+	}
+	for _, t := range tags {
+		out = append(out, msg[t]...)
+	}
+	return out
+}
+
+// decodeMessage разбирает сообщение Roughtime
+func decodeMessage(data []byte) (map[rtTag][]byte, error) {
+	if len(data) < 4 || len(data)%4 != 0 {
+		return nil, errors.New("roughtime: неверная длина сообщения")
+	}
+	n := int(binary.LittleEndian.Uint32(data))
+	hdr := 4 + 8*n - 4
+	if n == 0 || n > 64 || len(data) < hdr {
+		return nil, errors.New("roughtime: неверное число тегов")
+	}
+	offsets := make([]int, n+1)
+	for i := 1; i < n; i++ {
+		offsets[i] = int(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	values := data[hdr:]
+	offsets[n] = len(values)
+	for i := 1; i <= n; i++ {
+		if offsets[i]%4 != 0 || offsets[i] < offsets[i-1] || offsets[i] > len(values) {
+			return nil, errors.New("roughtime: неверное смещение значения")
+		}
+	}
+
+	msg := make(map[rtTag][]byte, n)
+	var prev rtTag
+	for i := 0; i < n; i++ {
+		t := rtTag(binary.LittleEndian.Uint32(data[4*n+4*i:]))
+		if i > 0 && t <= prev {
+			return nil, errors.New("roughtime: теги не по возрастанию")
+		}
+		msg[t] = values[offsets[i]:offsets[i+1]]
+		prev = t
+	}
+	return msg, nil
+}
+
+// roughtimeServer — сервер Roughtime и его долговременный открытый ключ
+type roughtimeServer struct {
+	addr string
+	key  ed25519.PublicKey
+}
+
+// parseRoughtimeServer разбирает значение -roughtime: host:port=ключ в base64
+func parseRoughtimeServer(s string) (roughtimeServer, error) {
+	addr, key, ok := strings.Cut(s, "=")
+	if !ok {
+		return roughtimeServer{}, fmt.Errorf("roughtime %q: нужно host:port=открытый ключ в base64", s)
+	}
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return roughtimeServer{}, fmt.Errorf("roughtime %q: ключ должен быть 32 байтами в base64", s)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "2002")
+	}
+	return roughtimeServer{addr, pub}, nil
+}
+
+// roughtimeReply — проверенный ответ: середина интервала и радиус неопределённости
+type roughtimeReply struct {
+	midpoint time.Time
+	radius   time.Duration
+	raw      []byte // весь ответ, из него выводится nonce следующего звена цепочки
+}
+
+// roughtimeQuery отправляет запрос с nonce и проверяет ответ ключом сервера
+func roughtimeQuery(srv roughtimeServer, nonce []byte, timeout time.Duration) (reply *roughtimeReply, sent, received time.Time, err error) {
+	req := encodeMessage(map[rtTag][]byte{tagNONC: nonce, tagPAD: nil})
+	pad := roughtimeRequestSize - len(req)
+	req = encodeMessage(map[rtTag][]byte{tagNONC: nonce, tagPAD: make([]byte, pad)})
+
+	conn, err := net.DialTimeout("udp", srv.addr, timeout)
+	if err != nil {
+		return nil, sent, received, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	sent = time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return nil, sent, received, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	received = time.Now()
+	if err != nil {
+		return nil, sent, received, err
+	}
+	reply, err = verifyRoughtime(buf[:n], nonce, srv.key)
+	return reply, sent, received, err
+}
+
+// verifyRoughtime проверяет ответ: сертификат делегированного ключа
+// подписан долговременным ключом, SREP подписан делегированным, nonce
+// входит в дерево Меркла с корнем ROOT, время в пределах срока делегирования
+func verifyRoughtime(data, nonce []byte, rootKey ed25519.PublicKey) (*roughtimeReply, error) {
+	resp, err := decodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := decodeMessage(resp[tagCERT])
+	if err != nil {
+		return nil, fmt.Errorf("CERT: %w", err)
+	}
+	dele := cert[tagDELE]
+	if !ed25519.Verify(rootKey, append(append([]byte(nil), roughtimeDelegationContext...), dele...), cert[tagSIG]) {
+		return nil, fmt.Errorf("%w: делегирование", ErrRoughtimeSignature)
+	}
+	delegation, err := decodeMessage(dele)
+	if err != nil {
+		return nil, fmt.Errorf("DELE: %w", err)
+	}
+	pub := delegation[tagPUBK]
+	if len(pub) != ed25519.PublicKeySize || len(delegation[tagMINT]) != 8 || len(delegation[tagMAXT]) != 8 {
+		return nil, errors.New("roughtime: неполный DELE")
+	}
+
+	srep := resp[tagSREP]
+	if !ed25519.Verify(pub, append(append([]byte(nil), roughtimeResponseContext...), srep...), resp[tagSIG]) {
+		return nil, fmt.Errorf("%w: ответ", ErrRoughtimeSignature)
+	}
+	signed, err := decodeMessage(srep)
+	if err != nil {
+		return nil, fmt.Errorf("SREP: %w", err)
+	}
+	if len(signed[tagMIDP]) != 8 || len(signed[tagRADI]) != 4 || len(resp[tagINDX]) != 4 {
+		return nil, errors.New("roughtime: неполный SREP")
+	}
+
+	root := merkleRoot(nonce, binary.LittleEndian.Uint32(resp[tagINDX]), resp[tagPATH])
+	if !bytes.Equal(root, signed[tagROOT]) {
+		return nil, errors.New("roughtime: nonce не входит в подписанное дерево")
+	}
+
+	midp := binary.LittleEndian.Uint64(signed[tagMIDP])
+	if midp < binary.LittleEndian.Uint64(delegation[tagMINT]) || midp > binary.LittleEndian.Uint64(delegation[tagMAXT]) {
+		return nil, errors.New("roughtime: время вне срока делегированного ключа")
+	}
+	return &roughtimeReply{
+		midpoint: time.UnixMicro(int64(midp)),
+		radius:   time.Duration(binary.LittleEndian.Uint32(signed[tagRADI])) * time.Microsecond,
+		raw:      data,
+	}, nil
+}
+
+// merkleRoot восстанавливает корень дерева по листу nonce, его индексу
+// и пути из соседних хешей
+func merkleRoot(nonce []byte, index uint32, path []byte) []byte {
+	h := sha512.Sum512(append([]byte{0}, nonce...))
+	hash := h[:]
+	for ; len(path) >= sha512.Size; path = path[sha512.Size:] {
+		sibling := path[:sha512.Size]
+		var node [sha512.Size]byte
+		if index&1 == 0 {
+			node = sha512.Sum512(append(append([]byte{1}, hash...), sibling...))
+		} else {
+			node = sha512.Sum512(append(append([]byte{1}, sibling...), hash...))
+		}
+		hash = node[:]
+		index >>= 1
+	}
+	return hash
+}
+
+// chainNonce — nonce следующего запроса цепочки: хеш предыдущего ответа
+// со случайной добавкой. Так ответ следующего сервера доказуемо получен
+// после ответа предыдущего
+func chainNonce(prev []byte) ([]byte, error) {
+	blind := make([]byte, roughtimeNonceSize)
+	if _, err := rand.Read(blind); err != nil {
+		return nil, err
+	}
+	if prev == nil {
+		return blind, nil
+	}
+	h := sha512.Sum512(append(append([]byte(nil), prev...), blind...))
+	return h[:], nil
+}
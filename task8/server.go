@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// Поля пакета NTP (RFC 5905, раздел 7.3)
+const (
+	packetSize     = 48
+	modeClient     = 3
+	modeServer     = 4
+	ntpEpochOffset = 2208988800 // секунды между 1900-01-01 (эпоха NTP) и 1970-01-01
+)
+
+// toNTPTime переводит время в 64-битную метку NTP: 32 бита секунд с 1900 года и 32 бита долей
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+// toNTPShort переводит длительность в короткий формат NTP 16.16
+func toNTPShort(d time.Duration) uint32 {
+	if d < 0 {
+		d = 0
+	}
+	return uint32(uint64(d) << 16 / uint64(time.Second))
+}
+
+// parseRefID разбирает reference ID: IPv4-адрес (для stratum >= 2)
+// или код источника до 4 ASCII-символов (для stratum 1: GPS, PPS, LOCL)
+func parseRefID(s string) (uint32, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return 0, fmt.Errorf("reference id %q: поддерживаются только IPv4-адреса", s)
+		}
+		return binary.BigEndian.Uint32(ip4), nil
+	}
+	if len(s) == 0 || len(s) > 4 {
+		return 0, fmt.Errorf("reference id %q: нужен IPv4-адрес или код из 1-4 символов", s)
+	}
+	var b [4]byte
+	for i := 0; i < len(s); i++ {
+		if s[i] < 32 || s[i] > 126 {
+			return 0, fmt.Errorf("reference id %q: недопустимый символ", s)
+		}
+		b[i] = s[i]
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+// sntpServer отвечает на SNTPv4-запросы (RFC 4330) по своим часам:
+// локальным или подстроенным под upstream-сервер
+type sntpServer struct {
+	conn    net.PacketConn
+	limiter *rateLimiter // nil — без ограничения частоты
+
+	precision int8 // log2 точности часов в секундах
+
+	mu        sync.RWMutex
+	offset    time.Duration // поправка к локальным часам (от upstream)
+	stratum   uint8
+	refID     uint32
+	refTime   time.Time // последняя синхронизация с upstream; нулевое — часы локальные
+	leap      ntp.LeapIndicator
+	rootDelay time.Duration
+	rootDisp  time.Duration
+}
+
+// newSNTPServer слушает UDP-адрес addr. Часы — локальные, со stratum и refID из аргументов
+func newSNTPServer(addr string, stratum uint8, refID uint32) (*sntpServer, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &sntpServer{
+		conn:      conn,
+		precision: -20, // 2^-20 с ≈ 1 мкс
+		stratum:   stratum,
+		refID:     refID,
+	}, nil
+}
+
+// now — время по часам сервера
+func (s *sntpServer) now() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Now().Add(s.offset)
+}
+
+// serve отвечает на запросы, пока соединение не закрыто через close
+func (s *sntpServer) serve() error {
+	buf := make([]byte, 1024)
+	for {
+		n, client, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		recv := s.now()
+		if resp := s.respond(buf[:n], client, recv); resp != nil {
+			_, _ = s.conn.WriteTo(resp, client)
+		}
+	}
+}
+
+func (s *sntpServer) close() error {
+	return s.conn.Close()
+}
+
+// respond строит ответ на запрос req, полученный в момент recv.
+// Возвращает nil, если отвечать не нужно (не клиентский запрос, мусор).
+// Клиенту, превысившему лимит, уходит kiss-of-death RATE
+func (s *sntpServer) respond(req []byte, client net.Addr, recv time.Time) []byte {
+	if len(req) < packetSize {
+		return nil
+	}
+	version := req[0] >> 3 & 0x7
+	if req[0]&0x7 != modeClient || version < 1 || version > 4 {
+		return nil
+	}
+
+	resp := make([]byte, packetSize)
+	copy(resp[24:32], req[40:48]) // origin = transmit клиента
+
+	// лимит считаем по локальным часам: поправка от upstream может их сдвинуть
+	if s.limiter != nil && !s.limiter.allow(clientIP(client), time.Now()) {
+		resp[0] = byte(ntp.LeapNotInSync)<<6 | version<<3 | modeServer
+		copy(resp[12:16], "RATE")
+		binary.BigEndian.PutUint64(resp[32:], toNTPTime(recv))
+		binary.BigEndian.PutUint64(resp[40:], toNTPTime(recv))
+		return resp
+	}
+
+	s.mu.RLock()
+	resp[0] = byte(s.leap)<<6 | version<<3 | modeServer
+	resp[1] = s.stratum
+	resp[2] = req[2] // poll: повторяем интервал клиента
+	resp[3] = byte(s.precision)
+	binary.BigEndian.PutUint32(resp[4:], toNTPShort(s.rootDelay))
+	binary.BigEndian.PutUint32(resp[8:], toNTPShort(s.rootDisp))
+	binary.BigEndian.PutUint32(resp[12:], s.refID)
+	// локальные часы сами себе эталон: время отсчёта — момент ответа,
+	// иначе клиент через 36 часов работы сочтёт их устаревшими
+	refTime := s.refTime
+	if refTime.IsZero() {
+		refTime = recv
+	}
+	binary.BigEndian.PutUint64(resp[16:], toNTPTime(refTime))
+	s.mu.RUnlock()
+
+	binary.BigEndian.PutUint64(resp[32:], toNTPTime(recv))
+	binary.BigEndian.PutUint64(resp[40:], toNTPTime(s.now()))
+	return resp
+}
+
+// syncUpstream подстраивает часы сервера под upstream: запоминает смещение,
+// становится на stratum ниже (если stratum не задан явно) и берёт
+// адрес upstream как reference ID. Непрошедший проверку ответ не применяется
+func (s *sntpServer) syncUpstream(upstream string, timeout time.Duration, fixedStratum bool, fixedRefID bool) error {
+	r, err := ntp.QueryWithOptions(upstream, ntp.QueryOptions{Timeout: timeout})
+	if err != nil {
+		return err
+	}
+	if err := validate(r); err != nil {
+		return err
+	}
+
+	var refID uint32
+	if !fixedRefID {
+		if host, _, err := net.SplitHostPort(upstream); err == nil {
+			upstream = host
+		}
+		if ips, err := net.LookupIP(upstream); err == nil {
+			for _, ip := range ips {
+				if ip4 := ip.To4(); ip4 != nil {
+					refID = binary.BigEndian.Uint32(ip4)
+					break
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset = r.ClockOffset
+	s.refTime = time.Now().Add(r.ClockOffset)
+	s.leap = r.Leap
+	s.rootDelay = r.RootDelay + r.RTT
+	s.rootDisp = r.RootDispersion + r.MinError
+	if !fixedStratum {
+		s.stratum = min(r.Stratum+1, 15)
+	}
+	if refID != 0 {
+		s.refID = refID
+	}
+	return nil
+}
+
+// setUnsynchronized помечает часы сервера несинхронизированными:
+// клиенты с проверкой ответа (как query) такой ответ отклонят
+func (s *sntpServer) setUnsynchronized() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leap = ntp.LeapNotInSync
+}
+
+// clientIP — адрес клиента без порта, ключ для ограничения частоты
+func clientIP(addr net.Addr) string {
+	if u, ok := addr.(*net.UDPAddr); ok {
+		return u.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// rateLimiter — token bucket на каждый адрес клиента: в среднем один запрос
+// за interval, но не больше burst подряд
+type rateLimiter struct {
+	interval time.Duration
+	burst    float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{
+		interval: interval,
+		burst:    float64(burst),
+		buckets:  map[string]*bucket{},
+	}
+}
+
+// allow списывает токен клиента и сообщает, был ли он
+func (l *rateLimiter) allow(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// раз в минуту забываем клиентов, чьи корзины давно полные
+	if now.Sub(l.lastSweep) > time.Minute {
+		full := time.Duration(l.burst) * l.interval
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+float64(now.Sub(b.last))/float64(l.interval))
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
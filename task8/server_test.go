@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// startServer запускает sntpServer на 127.0.0.1 и возвращает его вместе с адресом.
+// setup настраивает сервер до того, как он начнёт отвечать
+func startServer(t *testing.T, stratum uint8, refID string, setup ...func(*sntpServer)) (*sntpServer, string) {
+	t.Helper()
+	ref, err := parseRefID(refID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := newSNTPServer("127.0.0.1:0", stratum, ref)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	for _, f := range setup {
+		f(srv)
+	}
+	go func() { _ = srv.serve() }()
+	t.Cleanup(func() { _ = srv.close() })
+	return srv, srv.conn.LocalAddr().String()
+}
+
+func TestServeLocalClock(t *testing.T) {
+	_, addr := startServer(t, 1, "LOCL")
+
+	r, err := query(addr, time.Second, nil)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if !r.Valid {
+		t.Fatalf("ответ отклонён: %s", r.Error)
+	}
+	if r.Stratum != 1 || r.ReferenceID != ".LOCL." {
+		t.Errorf("stratum=%d refid=%q", r.Stratum, r.ReferenceID)
+	}
+	if r.Offset < -50*time.Millisecond || r.Offset > 50*time.Millisecond {
+		t.Errorf("offset = %v, want ~0", r.Offset)
+	}
+}
+
+// Через 36 часов работы на локальных часах клиент не должен считать их устаревшими
+func TestServeLocalClockStaysFresh(t *testing.T) {
+	_, addr := startServer(t, 1, "LOCL", func(s *sntpServer) {
+		s.offset = 72 * time.Hour // часы ушли на трое суток от момента запуска
+	})
+
+	r, err := ntp.QueryWithOptions(addr, ntp.QueryOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if err := r.Validate(); err != nil {
+		t.Errorf("Validate: %v (reference time %v, time %v)", err, r.ReferenceTime, r.Time)
+	}
+}
+
+func TestServeRateLimit(t *testing.T) {
+	_, addr := startServer(t, 1, "GPS", func(s *sntpServer) {
+		s.limiter = newRateLimiter(time.Hour, 2)
+	})
+
+	for i := 0; i < 2; i++ {
+		r, err := query(addr, time.Second, nil)
+		if err != nil || !r.Valid {
+			t.Fatalf("запрос %d: err=%v report=%+v", i+1, err, r)
+		}
+	}
+	r, err := query(addr, time.Second, nil)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if r.Valid || r.KissCode != "RATE" {
+		t.Errorf("третий запрос: valid=%v kiss=%q, want RATE", r.Valid, r.KissCode)
+	}
+}
+
+func TestServeUpstream(t *testing.T) {
+	upstream := standIn{stratum: 1, refID: "GPS", offset: 5 * time.Second}.start(t)
+	srv, addr := startServer(t, 1, "LOCL")
+
+	if err := srv.syncUpstream(upstream, time.Second, false, false); err != nil {
+		t.Fatalf("syncUpstream: %v", err)
+	}
+	r, err := query(addr, time.Second, nil)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if !r.Valid {
+		t.Fatalf("ответ отклонён: %s", r.Error)
+	}
+	if r.Offset < 4900*time.Millisecond || r.Offset > 5100*time.Millisecond {
+		t.Errorf("offset = %v, want ~5s от upstream", r.Offset)
+	}
+	if r.Stratum != 2 || r.ReferenceID != "127.0.0.1" {
+		t.Errorf("stratum=%d refid=%q, want 2 и адрес upstream", r.Stratum, r.ReferenceID)
+	}
+}
+
+func TestServeUnsynchronized(t *testing.T) {
+	srv, addr := startServer(t, 2, "10.0.0.1")
+	srv.setUnsynchronized()
+
+	r, err := query(addr, time.Second, nil)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if r.Valid || !strings.Contains(r.Error, ErrNotInSync.Error()) {
+		t.Errorf("valid=%v error=%q, want %v", r.Valid, r.Error, ErrNotInSync)
+	}
+}
+
+func TestRespondIgnoresNonClient(t *testing.T) {
+	srv := &sntpServer{}
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	short := make([]byte, 20)
+	short[0] = 4<<3 | modeClient
+	if srv.respond(short, client, time.Now()) != nil {
+		t.Error("ответ на короткий пакет")
+	}
+
+	fromServer := make([]byte, packetSize)
+	fromServer[0] = 4<<3 | modeServer
+	if srv.respond(fromServer, client, time.Now()) != nil {
+		t.Error("ответ на пакет в режиме server")
+	}
+
+	req := make([]byte, packetSize)
+	req[0] = 3<<3 | modeClient
+	resp := srv.respond(req, client, time.Now())
+	if resp == nil || resp[0]>>3&0x7 != 3 || resp[0]&0x7 != modeServer {
+		t.Errorf("ответ на запрос v3: %v", resp)
+	}
+}
+
+func TestParseRefID(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint32
+		wantErr bool
+	}{
+		{"GPS", 0x47505300, false},
+		{"LOCL", 0x4c4f434c, false},
+		{"192.0.2.1", 0xc0000201, false},
+		{"", 0, true},
+		{"TOOLONG", 0, true},
+		{"::1", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRefID(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRefID(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRefID(%q) = %#x, want %#x", tt.input, got, tt.want)
+		}
+	}
+}
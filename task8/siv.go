@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// errSIVOpen — шифротекст или связанные данные не прошли проверку
+var errSIVOpen = errors.New("AES-SIV: проверка подлинности не прошла")
+
+// aesSIV — AEAD_AES_SIV_CMAC_256 (RFC 5297), единственный алгоритм,
+// обязательный для NTS. Ключ 32 байта: первая половина для S2V (CMAC),
+// вторая для CTR. Результат seal — 16 байт синтетического IV, затем шифротекст
+type aesSIV struct {
+	mac    cipher.Block
+	ctr    cipher.Block
+	k1, k2 [16]byte // подключи CMAC
+}
+
+func newAESSIV(key []byte) (*aesSIV, error) {
+	if len(key) != 32 {
+		return nil, errors.New("AES-SIV: нужен ключ 32 байта")
+	}
+	mac, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[16:])
+	if err != nil {
+		return nil, err
+	}
+	s := &aesSIV{mac: mac, ctr: ctr}
+	mac.Encrypt(s.k1[:], s.k1[:])
+	dbl(&s.k1)
+	s.k2 = s.k1
+	dbl(&s.k2)
+	return s, nil
+}
+
+// seal шифрует plaintext и подписывает его вместе с ad. В NTS ad — пакет
+// до поля аутентификатора и nonce, в этом порядке
+func (s *aesSIV) seal(plaintext []byte, ad ...[]byte) []byte {
+	v := s.s2v(plaintext, ad)
+	out := make([]byte, 16+len(plaintext))
+	copy(out, v[:])
+	s.xorCTR(out[16:], plaintext, v)
+	return out
+}
+
+// open расшифровывает результат seal и проверяет его подлинность
+func (s *aesSIV) open(ciphertext []byte, ad ...[]byte) ([]byte, error) {
+	if len(ciphertext) < 16 {
+		return nil, errSIVOpen
+	}
+	var v [16]byte
+	copy(v[:], ciphertext)
+	plaintext := make([]byte, len(ciphertext)-16)
+	s.xorCTR(plaintext, ciphertext[16:], v)
+	want := s.s2v(plaintext, ad)
+	if subtle.ConstantTimeCompare(v[:], want[:]) != 1 {
+		return nil, errSIVOpen
+	}
+	return plaintext, nil
+}
+
+// s2v — псевдослучайная функция над вектором строк (RFC 5297, раздел 2.4)
+func (s *aesSIV) s2v(plaintext []byte, ad [][]byte) [16]byte {
+	var zero [16]byte
+	d := s.cmac(zero[:])
+	for _, a := range ad {
+		dbl(&d)
+		m := s.cmac(a)
+		subtle.XORBytes(d[:], d[:], m[:])
+	}
+
+	var t []byte
+	if len(plaintext) >= 16 {
+		// xorend: D ксорится с последними 16 байтами
+		t = append([]byte(nil), plaintext...)
+		tail := t[len(t)-16:]
+		subtle.XORBytes(tail, tail, d[:])
+	} else {
+		dbl(&d)
+		var p [16]byte
+		copy(p[:], plaintext)
+		p[len(plaintext)] = 0x80
+		subtle.XORBytes(d[:], d[:], p[:])
+		t = d[:]
+	}
+	return s.cmac(t)
+}
+
+// xorCTR шифрует (и расшифровывает) src в режиме CTR со счётчиком из v,
+// в котором сброшены биты 31 и 63 — так счётчик переносится только внутри слов
+func (s *aesSIV) xorCTR(dst, src []byte, v [16]byte) {
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(s.ctr, v[:]).XORKeyStream(dst, src)
+}
+
+// cmac — AES-CMAC (RFC 4493) на ключе S2V
+func (s *aesSIV) cmac(msg []byte) [16]byte {
+	var x [16]byte
+	for len(msg) > 16 {
+		subtle.XORBytes(x[:], x[:], msg[:16])
+		s.mac.Encrypt(x[:], x[:])
+		msg = msg[16:]
+	}
+	var last [16]byte
+	copy(last[:], msg)
+	if len(msg) == 16 {
+		subtle.XORBytes(last[:], last[:], s.k1[:])
+	} else {
+		last[len(msg)] = 0x80
+		subtle.XORBytes(last[:], last[:], s.k2[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	s.mac.Encrypt(x[:], x[:])
+	return x
+}
+
+// dbl — умножение на x в GF(2^128)
+func dbl(b *[16]byte) {
+	carry := b[0] >> 7
+	for i := 0; i < 15; i++ {
+		b[i] = b[i]<<1 | b[i+1]>>7
+	}
+	b[15] = b[15]<<1 ^ 0x87*carry
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Имена источников времени для -sources
+const (
+	sourceNTP       = "ntp"
+	sourceRoughtime = "roughtime"
+	sourceHTTP      = "http"
+)
+
+// httpDateResolution — заголовок Date точен до секунды
+const httpDateResolution = time.Second
+
+// timeSource — источник времени: опрашивает свои серверы и возвращает
+// по отчёту на каждый. Годный ответ — отчёт с Valid
+type timeSource interface {
+	name() string
+	query(timeout time.Duration) []*report
+}
+
+// ntpSource — NTP-серверы через beevik/ntp, с NTS или ключом, если заданы
+type ntpSource struct {
+	servers []string
+	samples int
+	auth    *authConfig
+}
+
+func (s *ntpSource) name() string { return sourceNTP }
+
+func (s *ntpSource) query(timeout time.Duration) []*report {
+	return queryAll(s.servers, s.samples, timeout, s.auth)
+}
+
+// roughtimeSource — серверы Roughtime, опрашиваемые по очереди цепочкой:
+// nonce каждого запроса выводится из предыдущего ответа. Если сервер
+// ответил временем раньше, чем предыдущий (с учётом радиусов), его отчёт
+// отклоняется: один из двух серверов врёт, и цепочка это доказывает
+type roughtimeSource struct {
+	servers []roughtimeServer
+}
+
+func (s *roughtimeSource) name() string { return sourceRoughtime }
+
+func (s *roughtimeSource) query(timeout time.Duration) []*report {
+	reports := make([]*report, 0, len(s.servers))
+	var prev *roughtimeReply
+	for _, srv := range s.servers {
+		r := &report{Server: srv.addr, Source: sourceRoughtime}
+		reports = append(reports, r)
+
+		var raw []byte
+		if prev != nil {
+			raw = prev.raw
+		}
+		nonce, err := chainNonce(raw)
+		if err != nil {
+			r.Error = err.Error()
+			continue
+		}
+		reply, sent, received, err := roughtimeQuery(srv, nonce, timeout)
+		if err != nil {
+			r.Error = err.Error()
+			continue
+		}
+
+		rtt := received.Sub(sent)
+		r.Time = reply.midpoint
+		r.RTT = rtt
+		r.Offset = reply.midpoint.Sub(sent.Add(rtt / 2))
+		r.RootDistance = reply.radius + rtt/2
+		if prev != nil && reply.midpoint.Add(reply.radius).Before(prev.midpoint.Add(-prev.radius)) {
+			r.Error = ErrRoughtimeChain.Error()
+			continue
+		}
+		r.Valid = true
+		prev = reply
+	}
+	return reports
+}
+
+// httpSource — грубый запасной источник: заголовок Date ответов HTTPS-серверов.
+// Date округлён вниз до секунды, поэтому смещение известно лишь с точностью ±0.5 с
+type httpSource struct {
+	urls   []string
+	client *http.Client
+}
+
+func (s *httpSource) name() string { return sourceHTTP }
+
+func (s *httpSource) query(timeout time.Duration) []*report {
+	reports := make([]*report, len(s.urls))
+	var wg sync.WaitGroup
+	for i, u := range s.urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reports[i] = s.probe(u, timeout)
+		}()
+	}
+	wg.Wait()
+	return reports
+}
+
+// probe делает HEAD-запрос и сравнивает Date с серединой интервала между
+// отправкой запроса и первым байтом ответа: установка соединения и TLS
+// в RTT не входят
+func (s *httpSource) probe(u string, timeout time.Duration) *report {
+	r := &report{Server: u, Source: sourceHTTP}
+	// колбэки вызываются из горутин транспорта
+	var mu sync.Mutex
+	var sent, received time.Time
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			mu.Lock()
+			sent = time.Now()
+			mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			received = time.Now()
+			mu.Unlock()
+		},
+	}
+	req, err := http.NewRequest(http.MethodHead, u, nil)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	client := *s.client
+	client.Timeout = timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	_ = resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		r.Error = "нет или неверный заголовок Date"
+		return r
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if sent.IsZero() || received.Before(sent) {
+		sent = received
+	}
+	rtt := received.Sub(sent)
+	mid := date.Add(httpDateResolution / 2)
+	r.Time = mid
+	r.RTT = rtt
+	r.Offset = mid.Sub(sent.Add(rtt / 2))
+	r.RootDistance = httpDateResolution/2 + rtt/2
+	r.Valid = true
+	return r
+}
+
+// parseHTTPSource проверяет адрес для -http: только HTTPS, иначе Date
+// может подменить кто угодно на пути. Голый хост дополняется до https://host/
+func parseHTTPSource(s string) (string, error) {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s + "/"
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("-http %q: %w", s, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("-http %q: нужен адрес https://", s)
+	}
+	return u.String(), nil
+}
+
+// querySources опрашивает источники по порядку, пока один не даст хотя бы
+// один годный ответ. Возвращает отчёты этого источника (или последнего,
+// если не удалось ни одному) и сообщения об источниках, от которых пришлось отказаться
+func querySources(sources []timeSource, timeout time.Duration) (reports []*report, skipped []string) {
+	for i, src := range sources {
+		reports = src.query(timeout)
+		valid := false
+		for _, r := range reports {
+			r.Source = src.name()
+			valid = valid || r.Valid
+		}
+		if valid {
+			return reports, skipped
+		}
+		if i < len(sources)-1 {
+			skipped = append(skipped, fmt.Sprintf("%s: нет годных ответов, пробуем %s", src.name(), sources[i+1].name()))
+		}
+	}
+	return reports, skipped
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roughtimeStandIn — сервер Roughtime с часами, сдвинутыми на offset.
+// Nonce клиента кладётся в дерево Меркла из двух листьев вторым,
+// чтобы клиенту пришлось пройти путь до корня
+type roughtimeStandIn struct {
+	addr   string
+	public ed25519.PublicKey
+	nonces chan []byte
+}
+
+func startRoughtime(t *testing.T, offset time.Duration) *roughtimeStandIn {
+	t.Helper()
+	rootPub, rootPriv, _ := ed25519.GenerateKey(rand.Reader)
+	delePub, delePriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	now := time.Now()
+	dele := encodeMessage(map[rtTag][]byte{
+		tagPUBK: delePub,
+		tagMINT: binary.LittleEndian.AppendUint64(nil, uint64(now.Add(-time.Hour).UnixMicro())),
+		tagMAXT: binary.LittleEndian.AppendUint64(nil, uint64(now.Add(time.Hour).UnixMicro())),
+	})
+	cert := encodeMessage(map[rtTag][]byte{
+		tagDELE: dele,
+		tagSIG:  ed25519.Sign(rootPriv, append(append([]byte(nil), roughtimeDelegationContext...), dele...)),
+	})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	s := &roughtimeStandIn{addr: conn.LocalAddr().String(), public: rootPub, nonces: make(chan []byte, 16)}
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, client, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := decodeMessage(buf[:n])
+			if err != nil || n < roughtimeRequestSize || len(req[tagNONC]) != roughtimeNonceSize {
+				continue
+			}
+			s.nonces <- append([]byte(nil), req[tagNONC]...)
+
+			other := make([]byte, roughtimeNonceSize)
+			_, _ = rand.Read(other)
+			sibling := sha512.Sum512(append([]byte{0}, other...))
+			root := merkleRoot(req[tagNONC], 1, sibling[:])
+
+			srep := encodeMessage(map[rtTag][]byte{
+				tagROOT: root,
+				tagMIDP: binary.LittleEndian.AppendUint64(nil, uint64(time.Now().Add(offset).UnixMicro())),
+				tagRADI: binary.LittleEndian.AppendUint32(nil, 10000), // 10 мс
+			})
+			resp := encodeMessage(map[rtTag][]byte{
+				tagSIG:  ed25519.Sign(delePriv, append(append([]byte(nil), roughtimeResponseContext...), srep...)),
+				tagPATH: sibling[:],
+				tagSREP: srep,
+				tagCERT: cert,
+				tagINDX: binary.LittleEndian.AppendUint32(nil, 1),
+			})
+			_, _ = conn.WriteTo(resp, client)
+		}
+	}()
+	return s
+}
+
+func (s *roughtimeStandIn) server() roughtimeServer {
+	return roughtimeServer{s.addr, s.public}
+}
+
+func (s *roughtimeStandIn) flag() string {
+	return s.addr + "=" + base64.StdEncoding.EncodeToString(s.public)
+}
+
+func TestRoughtimeMessage(t *testing.T) {
+	msg := map[rtTag][]byte{tagNONC: bytes.Repeat([]byte{1}, 64), tagPAD: make([]byte, 8), tagRADI: {1, 2, 3, 4}}
+	data := encodeMessage(msg)
+	got, err := decodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range msg {
+		if !bytes.Equal(got[k], v) {
+			t.Errorf("тег %x: %x, want %x", k, got[k], v)
+		}
+	}
+
+	bad := append([]byte(nil), data...)
+	bad[4] = 0xff // смещение за пределами сообщения
+	for _, b := range [][]byte{nil, {1, 0, 0}, {0, 0, 0, 0}, bad} {
+		if _, err := decodeMessage(b); err == nil {
+			t.Errorf("%x: нет ошибки", b)
+		}
+	}
+}
+
+func TestRoughtimeSource(t *testing.T) {
+	a := startRoughtime(t, 2*time.Second)
+	b := startRoughtime(t, 2*time.Second)
+
+	src := &roughtimeSource{servers: []roughtimeServer{a.server(), b.server()}}
+	reports := src.query(time.Second)
+	for _, r := range reports {
+		if !r.Valid {
+			t.Fatalf("%s: %s", r.Server, r.Error)
+		}
+		if r.Offset < 1900*time.Millisecond || r.Offset > 2100*time.Millisecond {
+			t.Errorf("%s: offset = %v, want ~2s", r.Server, r.Offset)
+		}
+		if r.RootDistance < 10*time.Millisecond {
+			t.Errorf("%s: root distance = %v, want radius + rtt/2", r.Server, r.RootDistance)
+		}
+	}
+	if bytes.Equal(<-a.nonces, <-b.nonces) {
+		t.Error("nonce второго звена цепочки совпал с первым")
+	}
+}
+
+func TestRoughtimeWrongKey(t *testing.T) {
+	s := startRoughtime(t, 0)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	reports := (&roughtimeSource{servers: []roughtimeServer{{s.addr, other}}}).query(time.Second)
+	if reports[0].Valid || !strings.Contains(reports[0].Error, ErrRoughtimeSignature.Error()) {
+		t.Errorf("report = %+v", reports[0])
+	}
+}
+
+func TestRoughtimeChainViolation(t *testing.T) {
+	ahead := startRoughtime(t, 10*time.Second)
+	behind := startRoughtime(t, 0)
+
+	reports := (&roughtimeSource{servers: []roughtimeServer{ahead.server(), behind.server()}}).query(time.Second)
+	if !reports[0].Valid {
+		t.Fatalf("первый сервер: %s", reports[0].Error)
+	}
+	if reports[1].Valid || reports[1].Error != ErrRoughtimeChain.Error() {
+		t.Errorf("второй сервер: %+v", reports[1])
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(3*time.Second).UTC().Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+	noDate := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Date"] = nil
+	}))
+	defer noDate.Close()
+
+	src := &httpSource{urls: []string{srv.URL, noDate.URL}, client: srv.Client()}
+	src.client.Transport.(*http.Transport).TLSClientConfig.RootCAs.AddCert(noDate.Certificate())
+	reports := src.query(time.Second)
+
+	r := reports[0]
+	if !r.Valid {
+		t.Fatalf("%s: %s", r.Server, r.Error)
+	}
+	// Date точен до секунды: смещение в пределах 3s ± 0.5s плюс RTT
+	if r.Offset < 2400*time.Millisecond || r.Offset > 3600*time.Millisecond {
+		t.Errorf("offset = %v, want ~3s", r.Offset)
+	}
+	if r.RootDistance < 500*time.Millisecond {
+		t.Errorf("root distance = %v, want >= 0.5s", r.RootDistance)
+	}
+	if reports[1].Valid {
+		t.Errorf("ответ без Date принят: %+v", reports[1])
+	}
+}
+
+func TestParseHTTPSource(t *testing.T) {
+	if u, err := parseHTTPSource("example.com"); err != nil || u != "https://example.com/" {
+		t.Errorf("example.com: %q, %v", u, err)
+	}
+	for _, bad := range []string{"http://example.com/", "https://"} {
+		if _, err := parseHTTPSource(bad); err == nil {
+			t.Errorf("%q: нет ошибки", bad)
+		}
+	}
+}
+
+func TestRunSourceFallback(t *testing.T) {
+	rt := startRoughtime(t, -time.Second)
+
+	// NTP недоступен: UDP-порт закрыт
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-s", closed, "-timeout", "200ms", "-sources", "ntp,roughtime", "-roughtime", rt.flag()}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("code = %d, stderr: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "source:          roughtime\n") || !strings.Contains(stdout.String(), "offset:          -") {
+		t.Errorf("stdout:\n%s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "ntp: нет годных ответов, пробуем roughtime") {
+		t.Errorf("stderr:\n%s", stderr.String())
+	}
+
+	for _, args := range [][]string{
+		{"-sources", "ntp,sundial"},
+		{"-sources", "roughtime"},
+		{"-sources", "http", "-http", "http://example.com"},
+		{"-sources", "roughtime", "-roughtime", "127.0.0.1:2002=notbase64"},
+	} {
+		if code := run(args, &stdout, &stderr); code != 2 {
+			t.Errorf("%v: code = %d, want 2", args, code)
+		}
+	}
+}
+
+func TestQuerySourcesAllFail(t *testing.T) {
+	rt := startRoughtime(t, 0)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	sources := []timeSource{
+		&roughtimeSource{servers: []roughtimeServer{{rt.addr, other}}},
+		&httpSource{urls: []string{"https://127.0.0.1:1/"}, client: http.DefaultClient},
+	}
+	reports, skipped := querySources(sources, 200*time.Millisecond)
+	if len(reports) != 1 || reports[0].Source != sourceHTTP || reports[0].Valid {
+		t.Errorf("reports = %+v", reports)
+	}
+	if len(skipped) != 1 {
+		t.Errorf("skipped = %q", skipped)
+	}
+	if reports[0].Error == "" {
+		t.Error("нет ошибки последнего источника")
+	}
+}
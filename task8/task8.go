@@ -1,18 +1,564 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata" // -tz работает и без системной базы часовых поясов
 
-	"github.com/beevik/ntp"
+	"timeMachine/clock"
 )
 
+// serverList — значение флага -s, который можно указать несколько раз
+type serverList []string
+
+func (l *serverList) String() string { return strings.Join(*l, ",") }
+
+func (l *serverList) Set(v string) error {
+	if v == "" {
+		return fmt.Errorf("пустой адрес сервера")
+	}
+	*l = append(*l, v)
+	return nil
+}
+
 func main() {
-	t, err := ntp.Time("pool.ntp.org")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			os.Exit(runServe(os.Args[2:], os.Stderr))
+		case "monitor":
+			os.Exit(runMonitor(os.Args[2:], os.Stderr))
+		case "sync":
+			os.Exit(runSync(os.Args[2:], os.Stdout, os.Stderr, systemClock{}))
+		case "now", "diff":
+			os.Exit(runTime(os.Args[1], os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// consensusReport — вывод -json в режиме консенсуса
+type consensusReport struct {
+	Servers   []*report  `json:"servers"`
+	Consensus *consensus `json:"consensus"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// run разбирает флаги, опрашивает серверы и печатает отчёты.
+// Серверы опрашиваются параллельно. С -config или -consensus вместо
+// отдельных отчётов печатается общее смещение по согласным серверам.
+// С -nts или -keys ответы без подтверждённой подлинности считаются ошибкой.
+// -sources задаёт порядок источников: следующий опрашивается, только если
+// у предыдущего не нашлось ни одного годного ответа.
+// Код возврата: 0 — все ответы получены и прошли проверку (в режиме
+// консенсуса — консенсус найден), 1 — иначе, 2 — ошибка в аргументах
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("timeMachine", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var servers serverList
+	fs.Var(&servers, "s", "NTP server `host[:port]` (can be repeated, default pool.ntp.org)")
+	timeout := fs.Duration("timeout", 5*time.Second, "query timeout per server")
+	asJSON := fs.Bool("json", false, "print reports as JSON")
+	configPath := fs.String("config", "", "file with servers, one per line; implies -consensus")
+	useConsensus := fs.Bool("consensus", false, "combine offsets of all servers, rejecting falsetickers")
+	samples := fs.Int("samples", 1, "queries per server; the one with the lowest RTT is used")
+	authOpts := addAuthFlags(fs)
+	sourceOrder := fs.String("sources", sourceNTP, "time sources to try in order: ntp, roughtime, http")
+	var roughtimeServers, httpURLs serverList
+	fs.Var(&roughtimeServers, "roughtime", "Roughtime server `host:port=base64key` (can be repeated; queried as a chain)")
+	fs.Var(&httpURLs, "http", "HTTPS `url` whose Date header is a coarse fallback (can be repeated)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *samples < 1 {
+		_, _ = fmt.Fprintln(stderr, "-samples должен быть не меньше 1")
+		return 2
+	}
+	auth, err := authOpts.config()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Ошибка настройки проверки подлинности: %v\n", err)
+		return 2
+	}
+	if *configPath != "" {
+		list, err := loadServers(*configPath)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка чтения конфигурации: %v\n", err)
+			return 2
+		}
+		servers = append(servers, list...)
+		*useConsensus = true
+	}
+	if fs.NArg() > 0 {
+		_, _ = fmt.Fprintf(stderr, "Лишние аргументы: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+	if len(servers) == 0 {
+		servers = serverList{"pool.ntp.org"}
+	}
+
+	var sources []timeSource
+	for _, name := range strings.Split(*sourceOrder, ",") {
+		switch strings.TrimSpace(name) {
+		case sourceNTP:
+			sources = append(sources, &ntpSource{servers, *samples, auth})
+		case sourceRoughtime:
+			src := &roughtimeSource{}
+			for _, v := range roughtimeServers {
+				srv, err := parseRoughtimeServer(v)
+				if err != nil {
+					_, _ = fmt.Fprintln(stderr, err)
+					return 2
+				}
+				src.servers = append(src.servers, srv)
+			}
+			if len(src.servers) == 0 {
+				_, _ = fmt.Fprintln(stderr, "для источника roughtime нужен хотя бы один -roughtime")
+				return 2
+			}
+			sources = append(sources, src)
+		case sourceHTTP:
+			src := &httpSource{client: http.DefaultClient}
+			for _, v := range httpURLs {
+				u, err := parseHTTPSource(v)
+				if err != nil {
+					_, _ = fmt.Fprintln(stderr, err)
+					return 2
+				}
+				src.urls = append(src.urls, u)
+			}
+			if len(src.urls) == 0 {
+				_, _ = fmt.Fprintln(stderr, "для источника http нужен хотя бы один -http")
+				return 2
+			}
+			sources = append(sources, src)
+		default:
+			_, _ = fmt.Fprintf(stderr, "Неизвестный источник времени %q, есть: ntp, roughtime, http\n", name)
+			return 2
+		}
+	}
+
+	code := 0
+	reports, skipped := querySources(sources, *timeout)
+	for _, msg := range skipped {
+		_, _ = fmt.Fprintln(stderr, msg)
+	}
+	for _, r := range reports {
+		if r.Time.IsZero() {
+			_, _ = fmt.Fprintf(stderr, "Ошибка получения времени от %s: %v\n", r.Server, r.Error)
+		}
+		if !r.Valid {
+			code = 1
+		}
+	}
+
+	if *useConsensus {
+		c, err := buildConsensus(reports)
+		code = 0
+		if err != nil {
+			code = 1
+		}
+		if *asJSON {
+			out := consensusReport{Servers: reports, Consensus: c}
+			if err != nil {
+				out.Error = err.Error()
+			}
+			if err := printJSON(stdout, out); err != nil {
+				_, _ = fmt.Fprintf(stderr, "Ошибка вывода: %v\n", err)
+				return 1
+			}
+			return code
+		}
+		printConsensus(stdout, reports, c, err)
+		return code
+	}
+
+	if *asJSON {
+		if err := printJSON(stdout, reports); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка вывода: %v\n", err)
+			return 1
+		}
+		return code
+	}
+
+	first := true
+	for _, r := range reports {
+		if r.Time.IsZero() {
+			continue // сервер не ответил, ошибка уже напечатана
+		}
+		if !first {
+			_, _ = fmt.Fprintln(stdout)
+		}
+		first = false
+		printText(stdout, r)
+	}
+	return code
+}
+
+// runServe — режим timeMachine serve: SNTP-сервер по локальным часам
+// или по часам upstream-сервера. Работает до SIGINT/SIGTERM
+func runServe(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("timeMachine serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", ":123", "UDP address to listen on")
+	stratum := fs.Int("stratum", 0, "stratum to announce (default 1, or upstream stratum + 1)")
+	refID := fs.String("refid", "", "reference ID: IPv4 address or up to 4 chars (default LOCL, or upstream address)")
+	upstream := fs.String("upstream", "", "NTP server `host[:port]` to take the time from instead of the local clock")
+	poll := fs.Duration("poll", 64*time.Second, "how often to query the upstream")
+	timeout := fs.Duration("timeout", 5*time.Second, "upstream query timeout")
+	rate := fs.Duration("rate", time.Second, "average interval allowed between requests of one client, 0 disables the limit")
+	burst := fs.Int("burst", 8, "requests a client may send in a row before getting kiss-of-death RATE")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		_, _ = fmt.Fprintf(stderr, "Лишние аргументы: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+	if *stratum < 0 || *stratum > 15 {
+		_, _ = fmt.Fprintln(stderr, "-stratum должен быть от 1 до 15")
+		return 2
+	}
+	if *burst < 1 || *poll <= 0 {
+		_, _ = fmt.Fprintln(stderr, "-burst должен быть не меньше 1, -poll — больше нуля")
+		return 2
+	}
+
+	id := *refID
+	if id == "" {
+		id = "LOCL"
+	}
+	ref, err := parseRefID(id)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 2
+	}
+	st := uint8(*stratum)
+	if st == 0 {
+		st = 1
+	}
+
+	srv, err := newSNTPServer(*addr, st, ref)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Ошибка запуска сервера: %v\n", err)
+		return 1
+	}
+	if *rate > 0 {
+		srv.limiter = newRateLimiter(*rate, *burst)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		_ = srv.close()
+	}()
+
+	if *upstream != "" {
+		// пока не получили время от upstream, клиентам отвечаем «не синхронизирован»
+		srv.setUnsynchronized()
+		go followUpstream(srv, *upstream, *poll, *timeout, *stratum != 0, *refID != "", stderr)
+	}
+
+	_, _ = fmt.Fprintln(stderr, "serving SNTP on", srv.conn.LocalAddr())
+	if err := srv.serve(); err != nil {
+		_, _ = fmt.Fprintf(stderr, "Ошибка сервера: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// followUpstream раз в poll синхронизирует сервер с upstream. Если upstream
+// не отвечает дольше четырёх интервалов, сервер объявляет себя несинхронизированным
+func followUpstream(srv *sntpServer, upstream string, poll, timeout time.Duration, fixedStratum, fixedRefID bool, stderr io.Writer) {
+	var lastSync time.Time
+	for {
+		if err := srv.syncUpstream(upstream, timeout, fixedStratum, fixedRefID); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка синхронизации с %s: %v\n", upstream, err)
+			if !lastSync.IsZero() && time.Since(lastSync) > 4*poll {
+				srv.setUnsynchronized()
+			}
+		} else {
+			lastSync = time.Now()
+		}
+		time.Sleep(poll)
+	}
+}
+
+// runMonitor — режим timeMachine monitor: следит за смещением локальных часов
+// относительно серверов, пока не придёт SIGINT/SIGTERM
+// (или, с -exit-on-alert, пока смещение не превысит порог)
+func runMonitor(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("timeMachine monitor", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var servers serverList
+	fs.Var(&servers, "s", "NTP server `host[:port]` (can be repeated, default pool.ntp.org)")
+	configPath := fs.String("config", "", "file with servers, one per line")
+	interval := fs.Duration("interval", 64*time.Second, "poll interval")
+	maxBackoff := fs.Duration("max-backoff", time.Hour, "longest interval between polls after failures")
+	timeout := fs.Duration("timeout", 5*time.Second, "query timeout per server")
+	samples := fs.Int("samples", 1, "queries per server on each poll")
+	threshold := fs.Duration("threshold", 100*time.Millisecond, "alert when the offset exceeds this")
+	webhook := fs.String("webhook", "", "URL to POST alert events to")
+	exitOnAlert := fs.Bool("exit-on-alert", false, "exit with code 3 when the offset exceeds the threshold")
+	metricsAddr := fs.String("metrics", "", "address for the HTTP server with /metrics and /series, e.g. :9123")
+	history := fs.Int("history", 1024, "samples kept in memory for /series")
+	recordPath := fs.String("record", "", "append every sample to this file as JSON lines")
+	authOpts := addAuthFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		_, _ = fmt.Fprintf(stderr, "Лишние аргументы: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+	if *interval <= 0 || *maxBackoff < *interval || *samples < 1 || *threshold <= 0 {
+		_, _ = fmt.Fprintln(stderr, "нужно: -interval > 0, -max-backoff >= -interval, -samples >= 1, -threshold > 0")
+		return 2
+	}
+	if *configPath != "" {
+		list, err := loadServers(*configPath)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка чтения конфигурации: %v\n", err)
+			return 2
+		}
+		servers = append(servers, list...)
+	}
+	if len(servers) == 0 {
+		servers = serverList{"pool.ntp.org"}
+	}
+	auth, err := authOpts.config()
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "Ошибка получения времени: %v\n", err)
-		os.Exit(1)
+		_, _ = fmt.Fprintf(stderr, "Ошибка настройки проверки подлинности: %v\n", err)
+		return 2
+	}
+
+	cfg := monitorConfig{
+		servers:     servers,
+		interval:    *interval,
+		maxBackoff:  *maxBackoff,
+		timeout:     *timeout,
+		samples:     *samples,
+		threshold:   *threshold,
+		webhook:     *webhook,
+		history:     *history,
+		exitOnAlert: *exitOnAlert,
+		auth:        auth,
+	}
+	if *recordPath != "" {
+		f, err := os.OpenFile(*recordPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка открытия %s: %v\n", *recordPath, err)
+			return 1
+		}
+		defer f.Close()
+		cfg.record = f
+	}
+	m := newMonitor(cfg, stderr)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *metricsAddr != "" {
+		ln, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка запуска /metrics: %v\n", err)
+			return 1
+		}
+		srv := &http.Server{Handler: m.handler()}
+		go func() { _ = srv.Serve(ln) }()
+		defer srv.Close()
+		m.log.Printf("metrics on http://%s/metrics", ln.Addr())
 	}
 
-	fmt.Println(t)
+	return m.run(ctx)
+}
+
+// syncReport — вывод -json режима sync
+type syncReport struct {
+	Servers    []*report   `json:"servers"`
+	Consensus  *consensus  `json:"consensus"`
+	Adjustment *adjustment `json:"adjustment,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// runSync — режим timeMachine sync: находит смещение по консенсусу серверов
+// и корректирует по нему локальные часы через api. Небольшое смещение
+// догоняется плавно (slew), большее — шагом (step), больше -max-step —
+// не исправляется вовсе. Код возврата: 0 — часы скорректированы (с -dry-run —
+// способ выбран), 1 — нет консенсуса, прав или смещение слишком велико,
+// 2 — ошибка в аргументах
+func runSync(args []string, stdout, stderr io.Writer, api clockAPI) int {
+	fs := flag.NewFlagSet("timeMachine sync", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var servers serverList
+	fs.Var(&servers, "s", "NTP server `host[:port]` (can be repeated, default pool.ntp.org)")
+	configPath := fs.String("config", "", "file with servers, one per line")
+	timeout := fs.Duration("timeout", 5*time.Second, "query timeout per server")
+	samples := fs.Int("samples", 4, "queries per server; the one with the lowest RTT is used")
+	slewLimit := fs.Duration("slew-limit", 128*time.Millisecond, "largest offset corrected by slewing; larger ones are stepped")
+	maxStep := fs.Duration("max-step", 1000*time.Second, "refuse to correct offsets larger than this")
+	dryRun := fs.Bool("dry-run", false, "only print what would be done")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	authOpts := addAuthFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		_, _ = fmt.Fprintf(stderr, "Лишние аргументы: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+	if *samples < 1 || *slewLimit < 0 || *maxStep < *slewLimit {
+		_, _ = fmt.Fprintln(stderr, "нужно: -samples >= 1, -slew-limit >= 0, -max-step >= -slew-limit")
+		return 2
+	}
+	if *configPath != "" {
+		list, err := loadServers(*configPath)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка чтения конфигурации: %v\n", err)
+			return 2
+		}
+		servers = append(servers, list...)
+	}
+	if len(servers) == 0 {
+		servers = serverList{"pool.ntp.org"}
+	}
+	auth, err := authOpts.config()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Ошибка настройки проверки подлинности: %v\n", err)
+		return 2
+	}
+
+	// права проверяем до опроса, чтобы не ждать серверы впустую
+	if !*dryRun {
+		if err := api.checkPrivilege(); err != nil {
+			_, _ = fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	out := syncReport{Servers: queryAll(servers, *samples, *timeout, auth)}
+	c, err := buildConsensus(out.Servers)
+	out.Consensus = c
+	if err == nil {
+		out.Adjustment, err = adjustClock(api, c.Offset, *slewLimit, *maxStep, *dryRun)
+		if out.Adjustment != nil {
+			out.Adjustment.Error = c.Error
+		}
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+
+	if *asJSON {
+		if e := printJSON(stdout, out); e != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка вывода: %v\n", e)
+			return 1
+		}
+	} else {
+		printSync(stdout, out)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Ошибка коррекции часов: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// timeDiff — вывод -json режима diff
+type timeDiff struct {
+	clock.Diff
+	Error time.Duration `json:"error_ns"`
+}
+
+// runTime — режимы timeMachine now и diff: текущее сетевое время по
+// консенсусу серверов (now) или его сравнение с локальными часами (diff)
+// в выбранном формате и часовом поясе. Код возврата: 0 — время получено,
+// 1 — нет консенсуса, 2 — ошибка в аргументах
+func runTime(mode string, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("timeMachine "+mode, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var servers serverList
+	fs.Var(&servers, "s", "NTP server `host[:port]` (can be repeated, default pool.ntp.org)")
+	configPath := fs.String("config", "", "file with servers, one per line")
+	timeout := fs.Duration("timeout", 5*time.Second, "query timeout per server")
+	samples := fs.Int("samples", 1, "queries per server; the one with the lowest RTT is used")
+	format := fs.String("format", string(clock.RFC3339Nano), "output format: rfc3339, rfc3339nano, unix, unixms or unixns")
+	tz := fs.String("tz", "", "IANA time zone for the output, e.g. Europe/Moscow (default local)")
+	asJSON := fs.Bool("json", false, "print the result as JSON (diff only)")
+	authOpts := addAuthFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		_, _ = fmt.Fprintf(stderr, "Лишние аргументы: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+	if *samples < 1 {
+		_, _ = fmt.Fprintln(stderr, "-samples должен быть не меньше 1")
+		return 2
+	}
+	f, err := clock.ParseFormat(*format)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 2
+	}
+	loc, err := clock.LoadLocation(*tz)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if *configPath != "" {
+		list, err := loadServers(*configPath)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка чтения конфигурации: %v\n", err)
+			return 2
+		}
+		servers = append(servers, list...)
+	}
+	if len(servers) == 0 {
+		servers = serverList{"pool.ntp.org"}
+	}
+	auth, err := authOpts.config()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Ошибка настройки проверки подлинности: %v\n", err)
+		return 2
+	}
+
+	reports := queryAll(servers, *samples, *timeout, auth)
+	c, err := buildConsensus(reports)
+	if err != nil {
+		for _, r := range c.Rejected {
+			_, _ = fmt.Fprintf(stderr, "%s: %s\n", r.Server, r.Reason)
+		}
+		_, _ = fmt.Fprintf(stderr, "Ошибка получения времени: %v\n", err)
+		return 1
+	}
+	var synced clock.Synced
+	synced.Set(c.Offset)
+
+	if mode == "now" {
+		_, _ = fmt.Fprintln(stdout, f.Format(synced.Now(), loc))
+		return 0
+	}
+	d := synced.Diff()
+	if *asJSON {
+		if err := printJSON(stdout, timeDiff{d, c.Error}); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Ошибка вывода: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	_, _ = fmt.Fprintf(stdout, "local:   %s\n", f.Format(d.Local, loc))
+	_, _ = fmt.Fprintf(stdout, "network: %s\n", f.Format(d.Network, loc))
+	_, _ = fmt.Fprintf(stdout, "offset:  %s ± %v\n", signed(d.Offset), c.Error)
+	return 0
 }
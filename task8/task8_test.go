@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// standIn — локальный SNTP-сервер для тестов с заданными полями ответа
+type standIn struct {
+	leap    byte
+	stratum byte
+	refID   string
+	offset  time.Duration // на сколько часы «сервера» впереди локальных
+}
+
+// start запускает sntpServer на 127.0.0.1 и возвращает его адрес host:port
+func (s standIn) start(t *testing.T) string {
+	t.Helper()
+	var ref [4]byte
+	copy(ref[:], s.refID)
+	srv, err := newSNTPServer("127.0.0.1:0", s.stratum, binary.BigEndian.Uint32(ref[:]))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv.offset = s.offset
+	srv.leap = ntp.LeapIndicator(s.leap)
+	srv.refTime = time.Now().Add(s.offset - time.Minute)
+	srv.rootDelay = 3906250 * time.Nanosecond // ~4ms
+	srv.rootDisp = 1953125 * time.Nanosecond  // ~2ms
+	go func() { _ = srv.serve() }()
+	t.Cleanup(func() { _ = srv.close() })
+	return srv.conn.LocalAddr().String()
+}
+
+func TestQueryValid(t *testing.T) {
+	addr := standIn{stratum: 1, refID: "GPS", offset: 2 * time.Second}.start(t)
+
+	r, err := query(addr, time.Second, nil)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if !r.Valid {
+		t.Fatalf("ответ отклонён: %s", r.Error)
+	}
+	if r.Stratum != 1 || r.ReferenceID != ".GPS." || r.Leap != "none" {
+		t.Errorf("stratum=%d refid=%q leap=%q", r.Stratum, r.ReferenceID, r.Leap)
+	}
+	if r.Offset < 1900*time.Millisecond || r.Offset > 2100*time.Millisecond {
+		t.Errorf("offset = %v, want ~2s", r.Offset)
+	}
+	if r.Precision != time.Second>>20 {
+		t.Errorf("precision = %v", r.Precision)
+	}
+}
+
+func TestQueryRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  standIn
+		wantErr error
+		kiss    string
+	}{
+		{"kiss of death", standIn{stratum: 0, refID: "RATE"}, ErrKissOfDeath, "RATE"},
+		{"not in sync", standIn{leap: 3, stratum: 2, refID: "\x0a\x00\x00\x01"}, ErrNotInSync, ""},
+	}
+
+	for _, tt := range tests {
+		addr := tt.server.start(t)
+		r, err := query(addr, time.Second, nil)
+		if err != nil {
+			t.Errorf("%s: query: %v", tt.name, err)
+			continue
+		}
+		if r.Valid {
+			t.Errorf("%s: ответ принят, ожидалась ошибка", tt.name)
+		}
+		if r.KissCode != tt.kiss {
+			t.Errorf("%s: kiss code = %q, want %q", tt.name, r.KissCode, tt.kiss)
+		}
+		if !strings.Contains(r.Error, tt.wantErr.Error()) {
+			t.Errorf("%s: error = %q, want %q", tt.name, r.Error, tt.wantErr)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	refTime := time.Now().Add(-time.Minute)
+	tests := []struct {
+		name    string
+		resp    ntp.Response
+		wantErr error
+	}{
+		{"ok", ntp.Response{Stratum: 2, Time: time.Now(), ReferenceTime: refTime}, nil},
+		{"kiss of death", ntp.Response{Stratum: 0, KissCode: "DENY"}, ErrKissOfDeath},
+		{"not in sync", ntp.Response{Stratum: 2, Leap: ntp.LeapNotInSync, Time: time.Now(), ReferenceTime: refTime}, ErrNotInSync},
+		{"stale clock", ntp.Response{Stratum: 2, Time: time.Now(), ReferenceTime: refTime.Add(-48 * time.Hour)}, ntp.ErrServerClockFreshness},
+	}
+
+	for _, tt := range tests {
+		err := validate(&tt.resp)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+			t.Errorf("%s: validate() = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	good := standIn{stratum: 2, refID: "\xc0\x00\x02\x01"}.start(t)
+	bad := standIn{stratum: 0, refID: "RATE"}.start(t)
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-json", "-timeout", "1s", "-s", good, "-s", bad}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("code = %d, want 1 (один ответ отклонён)", code)
+	}
+
+	var reports []report
+	if err := json.Unmarshal(stdout.Bytes(), &reports); err != nil {
+		t.Fatalf("JSON: %v\n%s", err, stdout.String())
+	}
+	if len(reports) != 2 {
+		t.Fatalf("reports = %d, want 2", len(reports))
+	}
+	if !reports[0].Valid || reports[0].ReferenceID != "192.0.2.1" || reports[0].Server != good {
+		t.Errorf("first report = %+v", reports[0])
+	}
+	if reports[1].Valid || reports[1].KissCode != "RATE" {
+		t.Errorf("second report = %+v", reports[1])
+	}
+}
+
+func TestRunText(t *testing.T) {
+	addr := standIn{stratum: 1, refID: "PPS"}.start(t)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-s", addr}, &stdout, &stderr); code != 0 {
+		t.Fatalf("code = %d, stderr: %s", code, stderr.String())
+	}
+	for _, want := range []string{"server:          " + addr, "stratum:         1", "reference id:    .PPS.", "status:          ok"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("нет %q в выводе:\n%s", want, stdout.String())
+		}
+	}
+}
+
+func TestRunUnreachable(t *testing.T) {
+	// порт, на котором никто не слушает: сразу закрываем сокет
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-timeout", "200ms", "-s", addr}, &stdout, &stderr); code != 1 {
+		t.Errorf("code = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "Ошибка получения времени от "+addr) {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+}
+
+func TestRunBadArgs(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"extra"}, &stdout, &stderr); code != 2 {
+		t.Errorf("code = %d, want 2", code)
+	}
+}
+
+func TestRunTime(t *testing.T) {
+	addr := standIn{stratum: 1, refID: "GPS", offset: 2 * time.Second}.start(t)
+
+	var stdout, stderr bytes.Buffer
+	if code := runTime("now", []string{"-s", addr, "-format", "unixms"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("now: code = %d, stderr: %s", code, stderr.String())
+	}
+	ms, err := strconv.ParseInt(strings.TrimSpace(stdout.String()), 10, 64)
+	if err != nil {
+		t.Fatalf("now: %q", stdout.String())
+	}
+	if d := time.UnixMilli(ms).Sub(time.Now()); d < 1900*time.Millisecond || d > 2100*time.Millisecond {
+		t.Errorf("now впереди локальных часов на %v, want ~2s", d)
+	}
+
+	stdout.Reset()
+	if code := runTime("diff", []string{"-s", addr, "-json"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("diff: code = %d, stderr: %s", code, stderr.String())
+	}
+	var d timeDiff
+	if err := json.Unmarshal(stdout.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Offset < 1900*time.Millisecond || d.Offset > 2100*time.Millisecond || d.Network.Sub(d.Local) != d.Offset {
+		t.Errorf("diff = %+v", d)
+	}
+
+	stdout.Reset()
+	if code := runTime("diff", []string{"-s", addr, "-tz", "Europe/Moscow", "-format", "rfc3339"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("diff: code = %d", code)
+	}
+	if !strings.Contains(stdout.String(), "+03:00\nnetwork:") || !strings.Contains(stdout.String(), "offset:  +") {
+		t.Errorf("diff:\n%s", stdout.String())
+	}
+
+	for _, args := range [][]string{{"-format", "iso"}, {"-tz", "Mars/Olympus"}} {
+		if code := runTime("now", args, &stdout, &stderr); code != 2 {
+			t.Errorf("%v: code = %d, want 2", args, code)
+		}
+	}
+}
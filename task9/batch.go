@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// batchStats — итог пакетного режима
+type batchStats struct {
+	Lines  int
+	Failed int
+	In     int64 // байт входа без переводов строк
+	Out    int64 // байт вывода без переводов строк
+}
+
+func (s *batchStats) add(o batchStats) {
+	s.Lines += o.Lines
+	s.Failed += o.Failed
+	s.In += o.In
+	s.Out += o.Out
+}
+
+func (s batchStats) String() string {
+	return fmt.Sprintf("lines: %d, ok: %d, failed: %d, in: %d bytes, out: %d bytes",
+		s.Lines, s.Lines-s.Failed, s.Failed, s.In, s.Out)
+}
+
+// batchChunk — пачка подряд идущих строк входа и канал для их результатов.
+// Горутинам раздаются пачки, а не строки: на коротких строках передача
+// через каналы иначе стоит дороже самой распаковки. Результаты приходят
+// по строкам, чтобы запись не ждала, пока распакуется вся пачка
+type batchChunk struct {
+	first int // номер первой строки
+	lines []string
+	done  chan batchResult
+}
+
+type batchResult struct {
+	out []byte
+	err error
+}
+
+// Пачка закрывается, когда набрала столько строк или байт
+const (
+	chunkLines = 256
+	chunkBytes = 64 << 10
+)
+
+// batchBudget — сколько байт готового вывода может ждать записи в пакетном режиме
+const batchBudget = 64 << 20
+
+// lineFunc обрабатывает одну строку входа в пакетном режиме и пишет результат в w
+type lineFunc func(w io.Writer, text string) error
+
+// unpackLineFunc распаковывает строку с лимитом на строку
+func unpackLineFunc(limit int64, ext bool) lineFunc {
+	return func(w io.Writer, text string) error {
+		var dec io.Reader = NewDecoder(strings.NewReader(text), limit)
+		if ext {
+			dec = NewExtendedDecoder(strings.NewReader(text), limit)
+		}
+		_, err := io.Copy(w, dec)
+		return err
+	}
+}
+
+func packLineFunc(w io.Writer, text string) error {
+	_, err := io.WriteString(w, packString(text))
+	return err
+}
+
+// byteBudget — семафор на байты вывода, распакованного, но ещё не записанного.
+// Строка, которую сейчас ждёт запись (head), берёт байты без ожидания:
+// иначе её горутина ждала бы памяти, занятой строками после неё. Поэтому
+// в памяти не больше size байт плюс вывод одной строки
+type byteBudget struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	free   int64
+	head   int
+	closed bool
+}
+
+func newByteBudget(size int64) *byteBudget {
+	b := &byteBudget{free: size, head: 1}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire занимает n байт под вывод строки line
+func (b *byteBudget) acquire(line int, n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.free < n && line != b.head && !b.closed {
+		b.cond.Wait()
+	}
+	b.free -= n
+}
+
+// release возвращает n байт записанной строки, и запись переходит к строке head
+func (b *byteBudget) release(n int64, head int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.free += n
+	b.head = head
+	b.cond.Broadcast()
+}
+
+// close отпускает всех ждущих: запись остановилась, и вывод уже не нужен
+func (b *byteBudget) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// budgetWriter собирает вывод строки line, занимая под него байты бюджета
+type budgetWriter struct {
+	b    *byteBudget
+	line int
+	buf  bytes.Buffer
+}
+
+func (w *budgetWriter) Write(p []byte) (int, error) {
+	w.b.acquire(w.line, int64(len(p)))
+	return w.buf.Write(p)
+}
+
+// ReadFrom читает прямо в буфер строки: без него io.Copy заводил бы
+// по буферу в 32 КБ на каждую строку
+func (w *budgetWriter) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		w.buf.Grow(bytes.MinRead)
+		p := w.buf.AvailableBuffer()
+		n, err := r.Read(p[:cap(p)])
+		if n > 0 {
+			w.b.acquire(w.line, int64(n))
+			w.buf.Write(p[:n])
+			total += int64(n)
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// runBatch обрабатывает строки r в workers горутинах и пишет результаты
+// в w в порядке входа. Строка с ошибкой не останавливает обработку:
+// ошибка с номером строки и кареткой уходит в errw, а в w вместо
+// результата пустая строка, чтобы строки вывода соответствовали строкам
+// входа. В обработке одновременно не больше 2*workers пачек, а готовый
+// вывод, ждущий записи, ограничен budget байтами, поэтому память
+// ограничена, как бы велики ни были вход и развёртки. Ошибку возвращает
+// только сбой чтения или записи
+func runBatch(w, errw io.Writer, name string, r io.Reader, workers int, budget int64, fn lineFunc) (batchStats, error) {
+	jobs := make(chan *batchChunk)
+	order := make(chan *batchChunk, 2*workers)
+	mem := newByteBudget(budget)
+	defer mem.close()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				for i, text := range c.lines {
+					bw := &budgetWriter{b: mem, line: c.first + i}
+					err := fn(bw, text)
+					c.done <- batchResult{bw.buf.Bytes(), err}
+				}
+			}
+		}()
+	}
+
+	// чтение останавливается, если запись в w сломалась
+	stop := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(order)
+		defer close(jobs)
+		readErr <- readChunks(r, func(c *batchChunk) bool {
+			select {
+			case order <- c:
+			case <-stop:
+				return false
+			}
+			jobs <- c
+			return true
+		})
+	}()
+
+	var stats batchStats
+	var writeErr error
+	for c := range order {
+		for i, text := range c.lines {
+			if writeErr != nil {
+				break
+			}
+			res := <-c.done
+			stats.Lines++
+			stats.In += int64(len(text))
+			out, err := res.out, res.err
+			if err != nil {
+				stats.Failed++
+				out = nil
+				_, _ = fmt.Fprintf(errw, "Ошибка: %s:%d: %v\n", name, c.first+i, err)
+				var pe *ParseError
+				if errors.As(err, &pe) {
+					_, _ = fmt.Fprintln(errw, indent(pe.Caret(text), "    "))
+				}
+			}
+			stats.Out += int64(len(out))
+			if _, writeErr = w.Write(append(out, '\n')); writeErr != nil {
+				close(stop)
+				mem.close()
+			}
+			mem.release(int64(len(res.out)), c.first+i+1)
+		}
+	}
+	wg.Wait()
+	if err := <-readErr; err != nil {
+		return stats, err
+	}
+	return stats, writeErr
+}
+
+// readChunks режет r на пачки строк без \n и \r\n и отдаёт их в send,
+// пока тот возвращает true
+func readChunks(r io.Reader, send func(*batchChunk) bool) error {
+	br := bufio.NewReader(r)
+	line := 1
+	c := &batchChunk{first: line}
+	size := 0
+	flush := func() bool {
+		if len(c.lines) == 0 {
+			return true
+		}
+		c.done = make(chan batchResult, len(c.lines))
+		ok := send(c)
+		c, size = &batchChunk{first: line}, 0
+		return ok
+	}
+	for {
+		text, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if text != "" {
+			c.lines = append(c.lines, strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r"))
+			size += len(text)
+			line++
+		}
+		if err == io.EOF {
+			flush()
+			return nil
+		}
+		if len(c.lines) == chunkLines || size >= chunkBytes {
+			if !flush() {
+				return nil
+			}
+		}
+	}
+}
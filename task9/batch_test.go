@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunBatchOrder(t *testing.T) {
+	var in strings.Builder
+	var want strings.Builder
+	for i := range 1000 {
+		switch {
+		case i%97 == 0:
+			in.WriteString("4bad\n")
+			want.WriteString("\n")
+		default:
+			fmt.Fprintf(&in, "a%db\\%d\r\n", i%13, i%10)
+			fmt.Fprintf(&want, "%sb%d\n", strings.Repeat("a", i%13), i%10)
+		}
+	}
+
+	// строки распаковываются с разной задержкой: порядок всё равно входной
+	slow := func(w io.Writer, text string) error {
+		time.Sleep(time.Duration(len(text)%3) * time.Millisecond)
+		return unpackLineFunc(NoLimit, false)(w, text)
+	}
+	var out, errw bytes.Buffer
+	stats, err := runBatch(&out, &errw, "in", strings.NewReader(in.String()), 8, batchBudget, slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want.String() {
+		t.Error("вывод не совпал со входом построчно")
+	}
+	if stats.Lines != 1000 || stats.Failed != 11 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if !strings.Contains(errw.String(), "in:98: ") || strings.Count(errw.String(), "^") != 11 {
+		t.Errorf("errw:\n%s", errw.String())
+	}
+}
+
+func TestRunBatchLimitPerLine(t *testing.T) {
+	var out, errw bytes.Buffer
+	stats, err := runBatch(&out, &errw, "in", strings.NewReader("a4\nb5\na4"), 2, batchBudget, unpackLineFunc(4, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "aaaa\n\naaaa\n" || stats.Failed != 1 || !strings.Contains(errw.String(), ErrLimitExceeded.Error()) {
+		t.Errorf("out = %q, stats = %+v, errw = %q", out.String(), stats, errw.String())
+	}
+}
+
+// failWriter ломается после первой записи
+type failWriter struct{ n int }
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.n++; w.n > 1 {
+		return 0, errors.New("диск полон")
+	}
+	return len(p), nil
+}
+
+func TestRunBatchWriteError(t *testing.T) {
+	in := strings.Repeat("ab3\n", 10000)
+	_, err := runBatch(&failWriter{}, io.Discard, "in", strings.NewReader(in), 4, batchBudget, unpackLineFunc(NoLimit, false))
+	if err == nil || err.Error() != "диск полон" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// lineCounter считает записанные байты вывода без переводов строк
+type lineCounter struct{ n atomic.Int64 }
+
+func (w *lineCounter) Write(p []byte) (int, error) {
+	w.n.Add(int64(len(p) - bytes.Count(p, []byte("\n"))))
+	return len(p), nil
+}
+
+// Развёрнутый, но не записанный вывод не превышает бюджет плюс одну строку,
+// даже когда первая строка распаковывается дольше остальных
+func TestRunBatchBoundedMemory(t *testing.T) {
+	const (
+		lines   = 600
+		lineOut = 256 << 10
+		budget  = 1 << 20
+	)
+	out := &lineCounter{}
+	var produced, peak atomic.Int64
+	fn := func(w io.Writer, text string) error {
+		if text == "first" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		block := make([]byte, 16<<10)
+		for written := 0; written < lineOut; written += len(block) {
+			if _, err := w.Write(block); err != nil {
+				return err
+			}
+			inFlight := produced.Add(int64(len(block))) - out.n.Load()
+			for {
+				p := peak.Load()
+				if inFlight <= p || peak.CompareAndSwap(p, inFlight) {
+					break
+				}
+			}
+		}
+		return nil
+	}
+
+	in := "first\n" + strings.Repeat("a\n", lines-1)
+	stats, err := runBatch(out, io.Discard, "in", strings.NewReader(in), 8, budget, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Lines != lines || out.n.Load() != lines*lineOut {
+		t.Fatalf("stats = %+v, записано %d байт", stats, out.n.Load())
+	}
+	if max := int64(budget + lineOut); peak.Load() > max {
+		t.Errorf("в памяти было до %d байт вывода, бюджет %d + строка %d", peak.Load(), budget, lineOut)
+	}
+}
+
+func TestRunBatchCLI(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-batch", "-workers", "3", "-ext"}, strings.NewReader("(ab)2\n((\nx3\n"), &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("code = %d, want 1", code)
+	}
+	if stdout.String() != "abab\n\nxxx\n" {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "stdin:2: ") || !strings.HasSuffix(stderr.String(), "lines: 3, ok: 2, failed: 1, in: 9 bytes, out: 7 bytes\n") {
+		t.Errorf("stderr:\n%s", stderr.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"-batch", "-pack"}, strings.NewReader("aaab\n"), &stdout, &stderr); code != 0 || stdout.String() != "a3b\n" {
+		t.Errorf("-batch -pack: code = %d, stdout = %q", code, stdout.String())
+	}
+	if code := run([]string{"-batch", "-workers", "0"}, nil, &stdout, &stderr); code != 2 {
+		t.Errorf("-workers 0: code = %d, want 2", code)
+	}
+}
+
+// benchInput — дамп из строк разной длины, как в логах
+func benchInput() string {
+	var b strings.Builder
+	for i := range 20000 {
+		fmt.Fprintf(&b, "user%d\\%dlogin%dok3x%d\n", i%7, i%10, i%50+1, i%200)
+	}
+	return b.String()
+}
+
+// BenchmarkSequential — построчный unpackingString в одной горутине
+func BenchmarkSequential(b *testing.B) {
+	in := benchInput()
+	b.SetBytes(int64(len(in)))
+	for b.Loop() {
+		out := bufio.NewWriter(io.Discard)
+		sc := bufio.NewScanner(strings.NewReader(in))
+		for sc.Scan() {
+			s, err := unpackingString(sc.Text())
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, _ = out.WriteString(s)
+			_ = out.WriteByte('\n')
+		}
+		_ = out.Flush()
+	}
+}
+
+func BenchmarkBatch(b *testing.B) {
+	in := benchInput()
+	counts := []int{1}
+	if n := runtime.GOMAXPROCS(0); n > 1 {
+		counts = append(counts, n)
+	}
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for b.Loop() {
+				out := bufio.NewWriter(io.Discard)
+				stats, err := runBatch(out, io.Discard, "bench", strings.NewReader(in), workers, batchBudget, unpackLineFunc(NoLimit, false))
+				if err != nil || stats.Failed > 0 {
+					b.Fatal(err, stats)
+				}
+				_ = out.Flush()
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NoLimit — лимит распаковки, при котором размер вывода не ограничен
+const NoLimit = math.MaxInt64
+
+// Decoder распаковывает поток вида a4bc2d5e. Вход читается по руне, а повтор
+// выдаётся кусками по мере чтения, так что память не зависит от счётчиков:
+// a1000000000 не разворачивается целиком. Лимит проверяется по счётчику до
+// выдачи первого байта повтора. При ошибке уже выданная часть остаётся
+// у читателя, а Read возвращает ошибку. Ошибки входа — *ParseError
+type Decoder struct {
+	r     io.RuneReader
+	limit int64
+	total int64 // байт вывода, выданных или назначенных к выдаче
+	pos   int   // рун входа прочитано
+
+	pending rune // последний символ: его счётчик ещё может продолжиться
+	has     bool
+	at      int    // руна входа, с которой начался pending
+	quoted  bool   // pending был экранирован
+	digits  []byte // счётчик pending, как он записан во входе
+	count   int64
+	escaped bool
+
+	sym      []byte // UTF-8 символа, который сейчас повторяется
+	repeat   int64  // сколько раз ещё выдать sym
+	leftover []byte // хвост sym, не поместившийся в прошлый Read
+	err      error
+}
+
+// NewDecoder создаёт декодер, который выдаст не больше limit байт
+// (NoLimit — без ограничения). Если r не io.RuneReader, он оборачивается в bufio.Reader
+func NewDecoder(r io.Reader, limit int64) *Decoder {
+	rr, ok := r.(io.RuneReader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+	return &Decoder{r: rr, limit: limit}
+}
+
+// Read отдаёт распакованные байты
+func (d *Decoder) Read(p []byte) (int, error) {
+	n := copy(p, d.leftover)
+	d.leftover = d.leftover[n:]
+	for n < len(p) {
+		if d.repeat == 0 {
+			if d.err != nil {
+				break
+			}
+			d.err = d.next()
+			continue
+		}
+		if k := min(d.repeat, int64((len(p)-n)/len(d.sym))); k > 0 {
+			// повтор размножается удвоением уже записанного куска
+			start, end := n, n+int(k)*len(d.sym)
+			n += copy(p[n:], d.sym)
+			for n < end {
+				n += copy(p[n:end], p[start:n])
+			}
+			d.repeat -= k
+		}
+		if d.repeat > 0 && n < len(p) {
+			c := copy(p[n:], d.sym)
+			d.leftover = d.sym[c:]
+			d.repeat--
+			n += c
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, d.err
+}
+
+// next читает вход, пока не станет известен счётчик последнего символа,
+// и назначает его к выдаче. Когда вход и последний символ кончились — io.EOF
+func (d *Decoder) next() error {
+	for {
+		c, _, err := d.r.ReadRune()
+		quoted := d.escaped
+		if err == io.EOF {
+			if d.escaped {
+				return &ParseError{ErrTrailingEscape, d.pos - 1, "\\"}
+			}
+			if !d.has {
+				return io.EOF
+			}
+			d.has = false
+			return d.stage()
+		}
+		if err != nil {
+			return err
+		}
+		at := d.pos
+		d.pos++
+
+		switch {
+		case d.escaped:
+			d.escaped = false
+			at-- // символ начинается с обратной косой черты
+		case c == '\\':
+			d.escaped = true
+			continue
+		case '0' <= c && c <= '9':
+			if !d.has {
+				return &ParseError{ErrLeadingDigit, at, string(c)}
+			}
+			d.digits = append(d.digits, byte(c))
+			if d.count > (math.MaxInt64-9)/10 {
+				return &ParseError{ErrCountOverflow, d.at, d.fragment()}
+			}
+			d.count = d.count*10 + int64(c-'0')
+			// длинная строка цифр не дочитывается, если вывод заведомо не влезет
+			if d.count > (d.limit-d.total)/int64(utf8.RuneLen(d.pending)) {
+				return &ParseError{ErrLimitExceeded, d.at, d.fragment()}
+			}
+			continue
+		case !unicode.IsLetter(c):
+			return &ParseError{ErrUnsupportedSymbol, at, string(c)}
+		}
+
+		// c — новый символ, значит счётчик предыдущего дочитан
+		if d.has {
+			err := d.stage()
+			d.pending, d.at, d.quoted = c, at, quoted
+			return err
+		}
+		d.pending, d.at, d.quoted, d.has = c, at, quoted, true
+	}
+}
+
+// stage назначает к выдаче недочитанный символ: без счётчика один раз,
+// с нулём — ни разу
+func (d *Decoder) stage() error {
+	d.sym = utf8.AppendRune(d.sym[:0], d.pending)
+	repeat := int64(1)
+	if len(d.digits) > 0 {
+		repeat = d.count
+	}
+	if repeat > (d.limit-d.total)/int64(len(d.sym)) {
+		return &ParseError{ErrLimitExceeded, d.at, d.fragment()}
+	}
+	d.count, d.digits = 0, d.digits[:0]
+	d.total += repeat * int64(len(d.sym))
+	d.repeat = repeat
+	return nil
+}
+
+// fragment — недочитанный символ со счётчиком, как во входе
+func (d *Decoder) fragment() string {
+	s := string(d.pending) + string(d.digits)
+	if d.quoted {
+		s = "\\" + s
+	}
+	return s
+}
+
+// Encoder упаковывает поток: серия одинаковых символов записывается
+// символом и числом повторов (aaaa → a4), всё, кроме букв, экранируется
+// обратной косой чертой, чтобы Decoder восстановил вход. Запись буферизуется,
+// а последняя серия не закончена, пока не вызван Close
+type Encoder struct {
+	w    *bufio.Writer
+	part []byte // начало руны, разрезанной между вызовами Write
+	cur  rune
+	run  int64
+	err  error
+}
+
+// NewEncoder создаёт упаковщик, пишущий в w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+// Write упаковывает p. Руна может быть разрезана между вызовами;
+// неверные байты UTF-8 становятся U+FFFD
+func (e *Encoder) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n := len(p)
+	if len(e.part) > 0 {
+		p = append(e.part, p...)
+		e.part = nil
+	}
+	for len(p) > 0 {
+		if !utf8.FullRune(p) {
+			e.part = append([]byte(nil), p...)
+			break
+		}
+		c, size := utf8.DecodeRune(p)
+		p = p[size:]
+		e.add(c)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+	return n, nil
+}
+
+// Close дописывает последнюю серию и сбрасывает буфер. Нижний w не закрывается
+func (e *Encoder) Close() error {
+	if len(e.part) > 0 {
+		// оборванная на конце руна
+		for range e.part {
+			e.add(utf8.RuneError)
+		}
+		e.part = nil
+	}
+	e.flushRun()
+	if e.err != nil {
+		return e.err
+	}
+	e.err = e.w.Flush()
+	return e.err
+}
+
+// add продолжает текущую серию или начинает новую
+func (e *Encoder) add(c rune) {
+	if e.run > 0 && c == e.cur {
+		e.run++
+		return
+	}
+	e.flushRun()
+	e.cur, e.run = c, 1
+}
+
+// flushRun пишет законченную серию. Ошибки bufio.Writer запоминает
+// сам, поэтому хватает проверить последнюю запись
+func (e *Encoder) flushRun() {
+	if e.run == 0 || e.err != nil {
+		return
+	}
+	if !unicode.IsLetter(e.cur) {
+		_ = e.w.WriteByte('\\')
+	}
+	_, e.err = e.w.WriteRune(e.cur)
+	if e.run > 1 && e.err == nil {
+		_, e.err = e.w.WriteString(strconv.FormatInt(e.run, 10))
+	}
+	e.run = 0
+}
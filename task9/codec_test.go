@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoderSmallReads(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a4bc2d5e", "aaaabccddddde"},
+		{"ж3я", "жжжя"},
+		{"\\☺2a", "☺☺a"},
+		{"\\🙂3", "🙂🙂🙂"},
+		{"a3\\4", "aaa4"}, // счётчик перед экранированным символом не теряется
+	}
+	for _, tt := range tests {
+		// вывод читается по байту: руны повтора режутся между вызовами Read
+		got, err := io.ReadAll(iotest.OneByteReader(NewDecoder(strings.NewReader(tt.input), NoLimit)))
+		if err != nil {
+			t.Errorf("%q: %v", tt.input, err)
+			continue
+		}
+		if string(got) != tt.expected {
+			t.Errorf("%q: %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestDecoderLimit(t *testing.T) {
+	// счётчик бомбы отвергается, не выдав ни байта повтора
+	var out bytes.Buffer
+	_, err := io.Copy(&out, NewDecoder(strings.NewReader("xa1000000000"), 1<<20))
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("err = %v, want ErrLimitExceeded", err)
+	}
+	if out.String() != "x" {
+		t.Errorf("выдано %q до ошибки", out.String())
+	}
+
+	tests := []struct {
+		input string
+		limit int64
+		ok    bool
+	}{
+		{"a4bc2d5e", 13, true},
+		{"a4bc2d5e", 12, false},
+		{"ж5", 10, true}, // лимит в байтах: ж занимает два
+		{"ж5", 9, false},
+		{"a0", 0, true},
+		{"a", 0, false},
+	}
+	for _, tt := range tests {
+		_, err := io.Copy(io.Discard, NewDecoder(strings.NewReader(tt.input), tt.limit))
+		if (err == nil) != tt.ok {
+			t.Errorf("%q с лимитом %d: err = %v", tt.input, tt.limit, err)
+		}
+	}
+}
+
+func TestDecoderCountOverflow(t *testing.T) {
+	_, err := io.Copy(io.Discard, NewDecoder(strings.NewReader("a99999999999999999999"), NoLimit))
+	if !errors.Is(err, ErrCountOverflow) {
+		t.Errorf("err = %v, want ErrCountOverflow", err)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"aaaabccddddde", "a4bc2d5e"},
+		{"", ""},
+		{"qwe45", "qwe\\4\\5"},
+		{"qwe44444", "qwe\\45"},
+		{"a\\\\b", "a\\\\2b"},
+		{"жжж мир", "ж3\\ мир"},
+		{"aaa4", "a3\\4"},
+		{strings.Repeat("z", 12), "z12"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		enc := NewEncoder(&out)
+		// по байту на Write: многобайтные руны приходят по частям
+		for i := 0; i < len(tt.input); i++ {
+			if _, err := enc.Write([]byte{tt.input[i]}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("%q: %q, want %q", tt.input, out.String(), tt.expected)
+		}
+		back, err := unpackingString(out.String())
+		if err != nil || back != tt.input {
+			t.Errorf("%q: распаковка %q = %q, %v", tt.input, out.String(), back, err)
+		}
+	}
+}
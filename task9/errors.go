@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ошибки разбора. Decoder возвращает их обёрнутыми в *ParseError,
+// поэтому сравнивать нужно через errors.Is
+var (
+	ErrLeadingDigit      = errors.New("invalid string: digit with no previous symbol")
+	ErrTrailingEscape    = errors.New("invalid string: ends with backslash")
+	ErrUnsupportedSymbol = errors.New("invalid string: unsupported symbol")
+	ErrCountOverflow     = errors.New("invalid string: count too large")
+)
+
+// ErrLimitExceeded — распакованный вывод превысил бы лимит декодера
+var ErrLimitExceeded = errors.New("unpacked output exceeds limit")
+
+// caretContext — сколько рун входа Caret показывает по обе стороны от ошибки
+const caretContext = 24
+
+// ParseError — ошибка во входе: что не так, с какой руны (с нуля)
+// и какой фрагмент входа виноват
+type ParseError struct {
+	Err      error
+	Offset   int
+	Fragment string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at rune %d: %q", e.Err, e.Offset, e.Fragment)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Caret показывает вход вокруг ошибки и отмечает фрагмент под ним:
+//
+//	a4b!c
+//	   ^
+func (e *ParseError) Caret(input string) string {
+	return e.caret([]rune(input), 0, true)
+}
+
+// caret рисует окно входа: runes начинаются с руны base. complete — runes
+// доходят до конца входа, иначе справа ставится многоточие
+func (e *ParseError) caret(runes []rune, base int, complete bool) string {
+	at := min(max(e.Offset-base, 0), len(runes))
+	width := max(utf8.RuneCountInString(e.Fragment), 1)
+	from := max(at-caretContext, 0)
+	to := min(at+width+caretContext, len(runes))
+
+	var line strings.Builder
+	if base+from > 0 {
+		line.WriteString("…")
+	}
+	pad := utf8.RuneCountInString(line.String()) + at - from
+	for _, c := range runes[from:to] {
+		// управляющие символы и таб сбили бы колонку каретки
+		if !unicode.IsGraphic(c) {
+			c = '·'
+		}
+		line.WriteRune(c)
+	}
+	if to < len(runes) || !complete {
+		line.WriteString("…")
+	}
+	return line.String() + "\n" + strings.Repeat(" ", pad) + "^" + strings.Repeat("~", width-1)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		input    string
+		err      error
+		offset   int
+		fragment string
+	}{
+		{"45", ErrLeadingDigit, 0, "4"},
+		{"ab\\", ErrTrailingEscape, 2, "\\"},
+		{"жж!", ErrUnsupportedSymbol, 2, "!"},
+		{"a٣b", ErrUnsupportedSymbol, 1, "٣"}, // счётчик — только ASCII-цифры
+		{"ab99999999999999999999", ErrCountOverflow, 1, "b9999999999999999999"},
+		{"a\\☺99999999999999999999", ErrCountOverflow, 1, "\\☺9999999999999999999"},
+	}
+	for _, tt := range tests {
+		_, err := unpackingString(tt.input)
+		var pe *ParseError
+		if !errors.Is(err, tt.err) || !errors.As(err, &pe) {
+			t.Errorf("%q: err = %v, want %v", tt.input, err, tt.err)
+			continue
+		}
+		if pe.Offset != tt.offset || pe.Fragment != tt.fragment {
+			t.Errorf("%q: offset %d, fragment %q, want %d, %q", tt.input, pe.Offset, pe.Fragment, tt.offset, tt.fragment)
+		}
+	}
+
+	_, err := io.Copy(io.Discard, NewDecoder(strings.NewReader("ab3c1000"), 10))
+	var pe *ParseError
+	if !errors.As(err, &pe) || !errors.Is(err, ErrLimitExceeded) || pe.Offset != 3 || pe.Fragment != "c10" {
+		t.Errorf("лимит: %v", err)
+	}
+}
+
+func TestCaret(t *testing.T) {
+	_, err := unpackingString("a4b!c")
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatal(err)
+	}
+	if got, want := pe.Caret("a4b!c"), "a4b!c\n   ^"; got != want {
+		t.Errorf("Caret:\n%s\nwant:\n%s", got, want)
+	}
+
+	long := strings.Repeat("ab", 30) + "c99999999999999999999" + strings.Repeat("d", 30)
+	_, err = unpackingString(long)
+	if !errors.As(err, &pe) {
+		t.Fatal(err)
+	}
+	lines := strings.Split(pe.Caret(long), "\n")
+	if !strings.HasPrefix(lines[0], "…") || !strings.HasSuffix(lines[0], "…") {
+		t.Errorf("окно без многоточий: %q", lines[0])
+	}
+	// каретка стоит под началом фрагмента и тянется на всю его длину
+	col := strings.Index(lines[1], "^")
+	if got := string([]rune(lines[0])[col:][:len(pe.Fragment)]); got != pe.Fragment {
+		t.Errorf("под кареткой %q, want %q", got, pe.Fragment)
+	}
+	if strings.Count(lines[1], "~") != len(pe.Fragment)-1 {
+		t.Errorf("каретка %q", lines[1])
+	}
+
+	// табуляция заменяется, чтобы не сбить колонку
+	if got := (&ParseError{ErrUnsupportedSymbol, 1, "\t"}).Caret("a\tb"); got != "a·b\n ^" {
+		t.Errorf("Caret с табуляцией: %q", got)
+	}
+}
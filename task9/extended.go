@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/rivo/uniseg"
+)
+
+// Расширенный диалект включается явно (ExtendedDecoder, unpackExtended, -ext)
+// и отличается от классического:
+//
+//	(ab)3      группа повторяется целиком: ababab; группы вкладываются
+//	a{12}      счётчик в фигурных скобках, то же, что a12
+//	é3, 🇷🇺2    повторяется графемный кластер, а не руна: флаги, эмодзи
+//	           и буквы с комбинируемыми знаками не распадаются
+//	a 2,       пробелы, пунктуация и эмодзи — обычные символы
+//
+// Экранировать нужно только цифры и \ ( ) { }
+var (
+	ErrUnbalancedGroup = errors.New("invalid string: unbalanced parenthesis")
+	ErrBadCount        = errors.New("invalid string: malformed count")
+	ErrNestingTooDeep  = errors.New("invalid string: groups nested too deeply")
+)
+
+// maxNesting — предел вложенности групп: разбор рекурсивный
+const maxNesting = 256
+
+// node — элемент расширенного диалекта: кластер или группа с числом повторов
+type node struct {
+	text     string // кластер; у группы пусто
+	group    bool
+	children []*node
+	count    int64
+	size     int64 // байт в одном повторе, с насыщением на math.MaxInt64
+}
+
+// ExtendedDecoder распаковывает расширенный диалект. Как и Decoder,
+// отдаёт вывод потоком: элемент верхнего уровня без групп не буферизуется,
+// группа разбирается в дерево (его размер ограничен размером входа),
+// а развёртка выдаётся по мере чтения. Лимит проверяется по размеру
+// развёртки до выдачи её первого байта
+type ExtendedDecoder struct {
+	s     scanner
+	limit int64
+	total int64
+
+	stack    []frame // обход развёртки текущего элемента
+	leftover string
+	err      error
+}
+
+// frame — позиция обхода: узел, следующий ребёнок и оставшиеся повторы
+type frame struct {
+	n    *node
+	i    int
+	left int64
+}
+
+// NewExtendedDecoder — как NewDecoder, но для расширенного диалекта
+func NewExtendedDecoder(r io.Reader, limit int64) *ExtendedDecoder {
+	rr, ok := r.(io.RuneReader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+	return &ExtendedDecoder{s: scanner{r: rr}, limit: limit}
+}
+
+// Read отдаёт распакованные байты
+func (d *ExtendedDecoder) Read(p []byte) (int, error) {
+	n := copy(p, d.leftover)
+	d.leftover = d.leftover[n:]
+	for n < len(p) {
+		chunk, ok := d.nextChunk()
+		if !ok {
+			if d.err != nil {
+				break
+			}
+			d.err = d.next()
+			continue
+		}
+		c := copy(p[n:], chunk)
+		d.leftover = chunk[c:]
+		n += c
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, d.err
+}
+
+// nextChunk — следующий кластер развёртки
+func (d *ExtendedDecoder) nextChunk() (string, bool) {
+	for len(d.stack) > 0 {
+		f := &d.stack[len(d.stack)-1]
+		switch {
+		case f.left == 0:
+			d.stack = d.stack[:len(d.stack)-1]
+		case !f.n.group:
+			f.left--
+			return f.n.text, true
+		case f.i == len(f.n.children):
+			f.i = 0
+			f.left--
+		default:
+			c := f.n.children[f.i]
+			f.i++
+			d.stack = append(d.stack, frame{n: c, left: c.count})
+		}
+	}
+	return "", false
+}
+
+// next разбирает следующий элемент верхнего уровня и назначает его к выдаче
+func (d *ExtendedDecoder) next() error {
+	d.s.src = d.s.src[:0]
+	at := d.s.pos
+	item, err := d.parseItem(0)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return io.EOF
+	}
+	out := mulSat(item.size, item.count)
+	if out > d.limit-d.total {
+		return &ParseError{ErrLimitExceeded, at, string(d.s.src)}
+	}
+	d.total += out
+	d.stack = append(d.stack[:0], frame{n: item, left: item.count})
+	return nil
+}
+
+// parseItem разбирает кластер или группу со счётчиком. На конце входа
+// и перед ) возвращает nil
+func (d *ExtendedDecoder) parseItem(depth int) (*node, error) {
+	at := d.s.pos
+	c, err := d.s.read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var n *node
+	switch {
+	case c == '(':
+		if depth == maxNesting {
+			return nil, &ParseError{ErrNestingTooDeep, at, "("}
+		}
+		n = &node{group: true}
+		for {
+			child, err := d.parseItem(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if child == nil {
+				break
+			}
+			if child.count == 0 {
+				continue
+			}
+			n.children = append(n.children, child)
+			n.size = addSat(n.size, mulSat(child.size, child.count))
+		}
+		if c, err := d.s.read(); err != nil || c != ')' {
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			return nil, &ParseError{ErrUnbalancedGroup, at, "("}
+		}
+	case c == ')':
+		if depth == 0 {
+			return nil, &ParseError{ErrUnbalancedGroup, at, ")"}
+		}
+		d.s.unread(c)
+		return nil, nil
+	case c == '{' || c == '}':
+		return nil, &ParseError{ErrBadCount, at, string(c)}
+	case '0' <= c && c <= '9':
+		return nil, &ParseError{ErrLeadingDigit, at, string(c)}
+	case c == '\\':
+		c, err = d.s.read()
+		if err == io.EOF {
+			return nil, &ParseError{ErrTrailingEscape, at, "\\"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		fallthrough
+	default:
+		text, err := d.s.cluster(c)
+		if err != nil {
+			return nil, err
+		}
+		n = &node{text: text, size: int64(len(text))}
+	}
+
+	// внутри группы ранняя проверка лимита неверна: группу может
+	// обнулить внешний счётчик
+	early := int64(0)
+	if depth == 0 {
+		early = n.size
+	}
+	n.count, err = d.parseCount(at, early)
+	// пустая развёртка ничего не выдаёт, но обход её повторов
+	// не ограничен лимитом и мог бы длиться вечно: () 999999999999
+	if n.size == 0 {
+		n.count = 0
+	}
+	return n, err
+}
+
+// parseCount читает необязательный счётчик: цифры или {цифры}.
+// Без счётчика — 1. at — начало элемента, к которому он относится.
+// Если size не 0, длинный счётчик не дочитывается, когда элемент размера
+// size заведомо не влезет в лимит
+func (d *ExtendedDecoder) parseCount(at int, size int64) (int64, error) {
+	c, err := d.s.read()
+	if err == io.EOF {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	braced := c == '{'
+	if braced {
+		if c, err = d.s.read(); err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	if c < '0' || c > '9' || err == io.EOF {
+		if braced {
+			return 0, &ParseError{ErrBadCount, at, string(d.s.src[d.s.offset(at):])}
+		}
+		d.s.unread(c)
+		return 1, nil
+	}
+
+	var count int64
+	for {
+		if count > (math.MaxInt64-9)/10 {
+			return 0, &ParseError{ErrCountOverflow, at, string(d.s.src[d.s.offset(at):])}
+		}
+		count = count*10 + int64(c-'0')
+		if size > 0 && count > (d.limit-d.total)/size {
+			return 0, &ParseError{ErrLimitExceeded, at, string(d.s.src[d.s.offset(at):])}
+		}
+		c, err = d.s.read()
+		if err == io.EOF {
+			if braced {
+				return 0, &ParseError{ErrBadCount, at, string(d.s.src[d.s.offset(at):])}
+			}
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if c < '0' || c > '9' {
+			break
+		}
+	}
+	if braced {
+		if c != '}' {
+			return 0, &ParseError{ErrBadCount, at, string(d.s.src[d.s.offset(at):])}
+		}
+		return count, nil
+	}
+	d.s.unread(c)
+	return count, nil
+}
+
+// scanner читает руны с возвратом и запоминает исходный текст текущего
+// элемента верхнего уровня для сообщений об ошибках
+type scanner struct {
+	r    io.RuneReader
+	pos  int // номер следующей руны входа
+	back []rune
+	src  []rune // руны текущего элемента верхнего уровня
+	base int    // номер руны входа, с которой начинается src
+}
+
+func (s *scanner) read() (rune, error) {
+	if len(s.src) == 0 {
+		s.base = s.pos
+	}
+	var c rune
+	if len(s.back) > 0 {
+		c = s.back[len(s.back)-1]
+		s.back = s.back[:len(s.back)-1]
+	} else {
+		var err error
+		if c, _, err = s.r.ReadRune(); err != nil {
+			return 0, err
+		}
+	}
+	s.pos++
+	s.src = append(s.src, c)
+	return c, nil
+}
+
+func (s *scanner) unread(c rune) {
+	s.back = append(s.back, c)
+	s.pos--
+	s.src = s.src[:len(s.src)-1]
+}
+
+// offset — индекс в src руны входа с номером at
+func (s *scanner) offset(at int) int {
+	return at - s.base
+}
+
+// cluster дочитывает графемный кластер, начатый руной c
+func (s *scanner) cluster(c rune) (string, error) {
+	text := string(c)
+	for {
+		next, err := s.read()
+		if err == io.EOF {
+			return text, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		joined := text + string(next)
+		if first, _, _, _ := uniseg.FirstGraphemeClusterInString(joined, -1); len(first) != len(joined) {
+			s.unread(next)
+			return text, nil
+		}
+		text = joined
+	}
+}
+
+// mulSat и addSat — арифметика размеров с насыщением: размер, не
+// влезающий в int64, заведомо больше любого лимита
+func mulSat(a, b int64) int64 {
+	if a != 0 && b > math.MaxInt64/a {
+		return math.MaxInt64
+	}
+	return a * b
+}
+
+func addSat(a, b int64) int64 {
+	if b > math.MaxInt64-a {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// unpackExtended распаковывает строку расширенного диалекта целиком в памяти, без лимита
+func unpackExtended(s string) (string, error) {
+	var b bytes.Buffer // см. unpackingString
+	if _, err := b.ReadFrom(NewExtendedDecoder(strings.NewReader(s), NoLimit)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestUnpackExtended(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a4bc2d5e", "aaaabccddddde"},
+		{"(ab)3", "ababab"},
+		{"((ab)2c){2}x", "ababcababcx"},
+		{"a{12}", "aaaaaaaaaaaa"},
+		{"a{0}b", "b"},
+		{"()5x", "x"},
+		{"(ab)", "ab"},
+		{"hello, world!2", "hello, world!!"},
+		{"e\u03013", "e\u0301e\u0301e\u0301"}, // буква с комбинируемым знаком
+		{"🇷🇺2", "🇷🇺🇷🇺"},                       // флаг из двух региональных индикаторов
+		{"👨\u200d👩\u200d👧2", "👨\u200d👩\u200d👧👨\u200d👩\u200d👧"}, // эмодзи, склеенные ZWJ
+		{"\\1\ufe0f\u20e32", "1\ufe0f\u20e31\ufe0f\u20e3"},     // экранированный keycap
+		{"\\(\\)2\\{\\}", "()){}"},
+		{"(a1000000000)0z", "z"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := unpackExtended(tt.input)
+		if err != nil {
+			t.Errorf("unpackExtended(%q): %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("unpackExtended(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestUnpackExtendedErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		err    error
+		offset int
+	}{
+		{"(ab", ErrUnbalancedGroup, 0},
+		{"ab)", ErrUnbalancedGroup, 2},
+		{"x((a)", ErrUnbalancedGroup, 1},
+		{"a{3", ErrBadCount, 0},
+		{"a{}", ErrBadCount, 0},
+		{"a{x}", ErrBadCount, 0},
+		{"{2}", ErrBadCount, 0},
+		{"a{2}3", ErrLeadingDigit, 4},
+		{"(3)", ErrLeadingDigit, 1},
+		{"ab\\", ErrTrailingEscape, 2},
+		{"(a)99999999999999999999", ErrCountOverflow, 0},
+		{strings.Repeat("(", maxNesting+1), ErrNestingTooDeep, maxNesting},
+	}
+	for _, tt := range tests {
+		_, err := unpackExtended(tt.input)
+		var pe *ParseError
+		if !errors.Is(err, tt.err) || !errors.As(err, &pe) {
+			t.Errorf("%.20q: err = %v, want %v", tt.input, err, tt.err)
+			continue
+		}
+		if pe.Offset != tt.offset {
+			t.Errorf("%.20q: offset = %d, want %d", tt.input, pe.Offset, tt.offset)
+		}
+	}
+}
+
+func TestClassicUnchanged(t *testing.T) {
+	// синтаксис расширенного диалекта классический режим по-прежнему отвергает
+	for _, s := range []string{"(ab)3", "a{2}", "a b", "e\u03012"} {
+		if _, err := unpackingString(s); !errors.Is(err, ErrUnsupportedSymbol) {
+			t.Errorf("unpackingString(%q): err = %v, want ErrUnsupportedSymbol", s, err)
+		}
+	}
+	// а классические строки расширенный распаковывает так же
+	for _, s := range unpackSeeds {
+		want, err := unpackingString(s)
+		if err != nil {
+			continue
+		}
+		if got, err := unpackExtended(s); err != nil || got != want {
+			t.Errorf("unpackExtended(%q) = %q, %v, want %q", s, got, err, want)
+		}
+	}
+}
+
+func TestExtendedDecoderLimit(t *testing.T) {
+	// развёртка 10^16 байт отвергается по размеру, не выдав ни байта
+	var out bytes.Buffer
+	_, err := io.Copy(&out, NewExtendedDecoder(strings.NewReader("x((a)100000000)100000000"), 1<<20))
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("err = %v, want ErrLimitExceeded", err)
+	}
+	if out.String() != "x" {
+		t.Errorf("выдано %q до ошибки", out.String())
+	}
+
+	tests := []struct {
+		input string
+		limit int64
+		ok    bool
+	}{
+		{"(ab)3", 6, true},
+		{"(ab)3", 5, false},
+		{"(🇷🇺)2", 16, true}, // флаг — восемь байт
+		{"(🇷🇺)2", 15, false},
+		{"(a99999999999999999)0", 0, true},
+		// пустые развёртки не обходятся, сколько бы раз их ни повторяли
+		{"()999999999999", 100, true},
+		{"(a0)99999999999", 100, true},
+		{"((b0)()999999999)999999999a", 100, true},
+		{"(()9999999999x)99999", 100, false},
+	}
+	for _, tt := range tests {
+		_, err := io.Copy(io.Discard, NewExtendedDecoder(strings.NewReader(tt.input), tt.limit))
+		if (err == nil) != tt.ok {
+			t.Errorf("%q с лимитом %d: err = %v", tt.input, tt.limit, err)
+		}
+	}
+}
+
+func TestExtendedDecoderSmallReads(t *testing.T) {
+	got, err := io.ReadAll(iotest.OneByteReader(NewExtendedDecoder(strings.NewReader("(ж🇷🇺2)2ы"), NoLimit)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ж🇷🇺🇷🇺ж🇷🇺🇷🇺ы"; string(got) != want {
+		t.Errorf("%q, want %q", got, want)
+	}
+}
+
+// FuzzUnpackExtended: разбор произвольного входа не паникует
+// и не выдаёт больше лимита
+func FuzzUnpackExtended(f *testing.F) {
+	for _, s := range []string{"(ab)3", "((ab)2c){2}x", "🇷🇺2", "e\u03013", "a{3", "(a)0",
+		"()999999999999", "(a0)99999999999"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		const limit = 1 << 16
+		n, _ := io.Copy(io.Discard, NewExtendedDecoder(strings.NewReader(s), limit))
+		if n > limit {
+			t.Fatalf("%q: выдано %d байт при лимите %d", s, n, limit)
+		}
+	})
+}
@@ -1,3 +1,5 @@
 module unpackString
 
 go 1.24.2
+
+require github.com/rivo/uniseg v0.4.7
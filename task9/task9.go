@@ -1,99 +1,276 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
+	"flag"
 	"fmt"
-	"strconv"
-	"unicode"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"unicode/utf8"
 )
 
-func main() {
-	example1 := "a4bc2d5e"
-	example2 := "abcd"
-	example3 := "45"
-	example4 := ""
-	example5 := "qwe\\4\\5"
-	example6 := "qwe\\45"
-
-	fmt.Println(unpackingString(example1))
-	fmt.Println(unpackingString(example2))
-	fmt.Println(unpackingString(example3))
-	fmt.Println(unpackingString(example4))
-	fmt.Println(unpackingString(example5))
-	fmt.Println(unpackingString(example6))
+// defaultMax — лимит распакованного вывода CLI по умолчанию
+const defaultMax = 64 << 20
 
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
 
-func unpackingString(s string) (string, error) {
-	r := []rune(s)
-	result := make([]rune, 0, 2*len(r))
-
-	if len(r) == 0 {
-		return "", nil
+// run распаковывает (с -pack — упаковывает) файлы из аргументов или stdin,
+// если файлов нет или указан "-". Каждая строка входа — отдельная упакованная
+// строка, в выводе она тоже занимает одну строку; с -ext строки разбираются
+// в расширенном диалекте (extended.go). Вывод идёт потоком, поэтому
+// при ошибке уже распакованное остаётся напечатанным.
+// С -batch строки обрабатываются параллельно (batch.go), ошибка в строке
+// не останавливает работу, -max ограничивает каждую строку отдельно,
+// а в конце в stderr печатается итог.
+// Код возврата: 0 — всё распаковано, 1 — ошибка в данных или вводе-выводе,
+// 2 — ошибка в аргументах
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("unpack", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	maxOut := fs.Int64("max", defaultMax, "maximum unpacked output in bytes, 0 for no limit")
+	pack := fs.Bool("pack", false, "pack instead of unpacking")
+	ext := fs.Bool("ext", false, "use the extended dialect: (groups)3, {n} counts, any grapheme cluster")
+	batch := fs.Bool("batch", false, "process lines in parallel, report bad lines and go on, print a summary")
+	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "worker goroutines for -batch")
+	if err := fs.Parse(args); err != nil {
+		return 2
 	}
-	if unicode.IsDigit(r[0]) {
-		return "", errors.New("invalid string")
+	if *workers < 1 {
+		_, _ = fmt.Fprintln(stderr, "Ошибка: -workers должен быть не меньше 1")
+		return 2
+	}
+	if *maxOut < 0 {
+		_, _ = fmt.Fprintln(stderr, "Ошибка: -max не может быть отрицательным")
+		return 2
+	}
+	limit := *maxOut
+	if limit == 0 {
+		limit = NoLimit
 	}
 
-	escaped := false
-	numCount := ""
-	for i := 0; i < len(r); i++ {
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	out := bufio.NewWriter(stdout)
+	fn := unpackLineFunc(limit, *ext)
+	if *pack {
+		fn = packLineFunc
+	}
+	var stats batchStats
 
-		if escaped {
-			result = append(result, r[i])
-			escaped = false
-			numCount = ""
-			continue
+	for _, name := range files {
+		in, closeIn := stdin, func() error { return nil }
+		if name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				_ = out.Flush()
+				_, _ = fmt.Fprintf(stderr, "Ошибка: %v\n", err)
+				return 1
+			}
+			in, closeIn = f, f.Close
+		} else {
+			name = "stdin"
 		}
 
-		if r[i] == '\\' {
-			escaped = true
-			continue
+		var err error
+		var line int
+		if *batch {
+			var s batchStats
+			s, err = runBatch(out, stderr, name, in, *workers, batchBudget, fn)
+			stats.add(s)
+			if err != nil {
+				_ = closeIn()
+				_ = out.Flush()
+				_, _ = fmt.Fprintf(stderr, "Ошибка: %s: %v\n", name, err)
+				return 1
+			}
+		} else if *pack {
+			line, err = packLines(out, in)
+		} else {
+			var n int64
+			line, n, err = unpackLines(out, in, limit, *ext)
+			limit -= n
 		}
-
-		if unicode.IsLetter(r[i]) {
-			if numCount != "" {
-				last := result[len(result)-1]
-				count, _ := strconv.Atoi(numCount)
-				if count == 0 {
-					result = result[:len(result)-1]
-				} else {
-					for j := 1; j < count; j++ {
-						result = append(result, last)
-					}
-				}
-				numCount = ""
+		_ = closeIn()
+		if err != nil {
+			_ = out.Flush()
+			_, _ = fmt.Fprintf(stderr, "Ошибка: %s:%d: %v\n", name, line, err)
+			var diag *diagnostic
+			if errors.As(err, &diag) {
+				_, _ = fmt.Fprintln(stderr, indent(diag.caret, "    "))
 			}
-			result = append(result, r[i])
-			continue
+			return 1
 		}
+	}
+	if err := out.Flush(); err != nil {
+		_, _ = fmt.Fprintf(stderr, "Ошибка записи: %v\n", err)
+		return 1
+	}
+	if *batch {
+		_, _ = fmt.Fprintln(stderr, stats)
+		if stats.Failed > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// unpackLines распаковывает строки r в w, тратя из общего лимита; ext —
+// расширенный диалект. Возвращает номер последней прочитанной строки
+// и число выданных байт
+func unpackLines(w io.Writer, r io.Reader, limit int64, ext bool) (line int, written int64, err error) {
+	br := bufio.NewReader(r)
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return line, written, nil
+		}
+		line++
+		lr := &lineReader{r: br}
+		var dec io.Reader = NewDecoder(lr, limit-written)
+		if ext {
+			dec = NewExtendedDecoder(lr, limit-written)
+		}
+		n, err := io.Copy(w, dec)
+		written += n
+		var pe *ParseError
+		if errors.As(err, &pe) {
+			return line, written, &diagnostic{pe, lr.caret(pe)}
+		}
+		if err != nil {
+			return line, written, err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return line, written, err
+		}
+	}
+}
 
-		if unicode.IsDigit(r[i]) {
-			numCount += string(r[i])
-			continue
+// packLines упаковывает строки r в w
+func packLines(w io.Writer, r io.Reader) (line int, err error) {
+	br := bufio.NewReader(r)
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return line, nil
+		}
+		line++
+		enc := NewEncoder(w)
+		if _, err := io.Copy(enc, &lineReader{r: br}); err != nil {
+			return line, err
+		}
+		if err := enc.Close(); err != nil {
+			return line, err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return line, err
 		}
+	}
+}
 
-		return "", errors.New("invalid string: unsupported symbol")
+// diagnostic — ошибка разбора строки с кареткой, указывающей на место
+type diagnostic struct {
+	*ParseError
+	caret string
+}
+
+// lineReader читает из r одну строку без \n (и \r перед ним), затем отдаёт io.EOF.
+// Читает по руне, чтобы не забрать из r начало следующей строки. Последние
+// прочитанные руны запоминаются для каретки: строку целиком CLI не хранит
+type lineReader struct {
+	r      *bufio.Reader
+	eol    bool
+	recent []rune
+	base   int // номер руны строки, с которой начинается recent
+}
+
+func (l *lineReader) ReadRune() (rune, int, error) {
+	if l.eol {
+		return 0, 0, io.EOF
+	}
+	c, size, err := l.r.ReadRune()
+	if err != nil {
+		return c, size, err
+	}
+	if c == '\r' {
+		if next, _ := l.r.Peek(1); len(next) == 1 && next[0] == '\n' {
+			_, _ = l.r.Discard(1)
+			c = '\n'
+		}
 	}
+	if c == '\n' {
+		l.eol = true
+		return 0, 0, io.EOF
+	}
+	if len(l.recent) == 4*caretContext {
+		// окно сдвигается половинами, чтобы не копировать на каждой руне
+		half := copy(l.recent, l.recent[2*caretContext:])
+		l.recent = l.recent[:half]
+		l.base += 2 * caretContext
+	}
+	l.recent = append(l.recent, c)
+	return c, size, nil
+}
 
-	if numCount != "" {
-		if len(result) == 0 {
-			return "", errors.New("invalid string: digit with no previous symbol")
+// caret дочитывает немного строки после ошибки и рисует каретку по
+// запомненным рунам. Остаток строки не нужен: разбор всё равно остановлен
+func (l *lineReader) caret(pe *ParseError) string {
+	for i := 0; i < caretContext; i++ {
+		if _, _, err := l.ReadRune(); err != nil {
+			return pe.caret(l.recent, l.base, true)
 		}
-		last := result[len(result)-1]
-		count, _ := strconv.Atoi(numCount)
-		if count == 0 {
-			result = result[:len(result)-1]
-		} else {
-			for j := 1; j < count; j++ {
-				result = append(result, last)
+	}
+	return pe.caret(l.recent, l.base, false)
+}
+
+// Read нужен для io.Copy в Encoder; p должен вмещать хотя бы одну руну
+func (l *lineReader) Read(p []byte) (int, error) {
+	n := 0
+	for n+utf8.UTFMax <= len(p) {
+		c, _, err := l.ReadRune()
+		if err != nil {
+			if n > 0 {
+				return n, nil
 			}
+			return 0, err
 		}
+		n += utf8.EncodeRune(p[n:], c)
 	}
+	return n, nil
+}
+
+// indent сдвигает каждую строку s на prefix
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
 
-	if escaped {
-		return "", errors.New("invalid string: ends with backslash")
+// unpackingString распаковывает строку целиком в памяти, без лимита
+func unpackingString(s string) (string, error) {
+	// bytes.Buffer читает сам, а io.Copy в strings.Builder выделял бы
+	// буфер 32 КБ на каждую, обычно короткую, строку
+	var b bytes.Buffer
+	if _, err := b.ReadFrom(NewDecoder(strings.NewReader(s), NoLimit)); err != nil {
+		return "", err
 	}
+	return b.String(), nil
+}
 
-	return string(result), nil
+// packString — обратная операция: каноническая упакованная форма s.
+// Серия из двух и более одинаковых символов записывается символом и
+// числом (aaaabccddddde → a4bc2d5e), всё, кроме букв, включая цифры
+// и обратную косую черту, экранируется. Для любой строки в UTF-8
+// unpackingString(packString(s)) == s; неверные байты, как и в []rune(s),
+// становятся U+FFFD
+func packString(s string) string {
+	var b strings.Builder
+	enc := NewEncoder(&b)
+	// strings.Builder не возвращает ошибок
+	_, _ = io.WriteString(enc, s)
+	_ = enc.Close()
+	return b.String()
 }
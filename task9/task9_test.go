@@ -1,6 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -23,6 +28,7 @@ func TestUnpackingString(t *testing.T) {
 		{"x0y0z0", "", false},
 		{"abc2", "abcc", false},
 		{"a\\4b3", "a4bbb", false},
+		{"a3\\4", "aaa4", false},
 	}
 
 	for _, tt := range tests {
@@ -36,3 +42,145 @@ func TestUnpackingString(t *testing.T) {
 		}
 	}
 }
+
+// unpackSeeds — начальные входы фаззинга и проверок обратного хода:
+// примеры из TestUnpackingString и строки с ошибками разбора
+var unpackSeeds = []string{
+	"a4bc2d5e", "abcd", "45", "ab12", "\\", "", "qwe\\4\\5", "qwe\\45",
+	"a0b3", "a10", "x0y0z0", "abc2", "a\\4b3", "a3\\4", "a!", "a\\",
+}
+
+func TestPackString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"aaaabccddddde", "a4bc2d5e"},
+		{"abcd", "abcd"},
+		{"", ""},
+		{"qwe45", "qwe\\4\\5"},
+		{"qwe44444", "qwe\\45"},
+		{"abbbbbbbbbbbb", "ab12"},
+		{"a\\b", "a\\\\b"},
+		{"a\xffb", "a\\\uFFFDb"},
+	}
+	for _, tt := range tests {
+		if got := packString(tt.input); got != tt.expected {
+			t.Errorf("packString(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+	// распакованные примеры упаковываются обратно в то, из чего получены
+	for _, s := range unpackSeeds {
+		unpacked, err := unpackingString(s)
+		if err != nil {
+			continue
+		}
+		if got, err := unpackingString(packString(unpacked)); err != nil || got != unpacked {
+			t.Errorf("unpackingString(packString(%q)) = %q, %v", unpacked, got, err)
+		}
+	}
+}
+
+// FuzzPackRoundTrip: упаковка любой строки распаковывается обратно,
+// а повторная упаковка ничего не меняет
+func FuzzPackRoundTrip(f *testing.F) {
+	for _, s := range unpackSeeds {
+		f.Add(s)
+		if unpacked, err := unpackingString(s); err == nil {
+			f.Add(unpacked)
+		}
+	}
+	f.Add("жжж 🇷🇺🇷🇺 e\u0301\u0301")
+	f.Fuzz(func(t *testing.T, s string) {
+		packed := packString(s)
+		got, err := unpackingString(packed)
+		if err != nil {
+			t.Fatalf("packString(%q) = %q не распаковывается: %v", s, packed, err)
+		}
+		if want := string([]rune(s)); got != want {
+			t.Fatalf("unpackingString(packString(%q)) = %q, want %q", s, got, want)
+		}
+		if again := packString(got); again != packed {
+			t.Fatalf("packString не каноничен: %q и %q", packed, again)
+		}
+	})
+}
+
+// FuzzUnpack: распаковка произвольного входа не паникует, а удавшаяся
+// переживает упаковку и распаковку. Лимит не даёт фаззеру раздуть счётчики
+func FuzzUnpack(f *testing.F) {
+	for _, s := range unpackSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		var b strings.Builder
+		if _, err := io.Copy(&b, NewDecoder(strings.NewReader(s), 1<<16)); err != nil {
+			return
+		}
+		unpacked := b.String()
+		got, err := unpackingString(packString(unpacked))
+		if err != nil || got != unpacked {
+			t.Fatalf("%q: распаковано %q, после упаковки и распаковки %q, %v", s, unpacked, got, err)
+		}
+	})
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(file, []byte("a4bc2d5e\r\n\nqwe\\45"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{file, "-"}, strings.NewReader("ab2\n"), &stdout, &stderr); code != 0 {
+		t.Fatalf("code = %d, stderr: %s", code, stderr.String())
+	}
+	if want := "aaaabccddddde\n\nqwe44444\nabb\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"-pack"}, strings.NewReader("aaaabccddddde\nqwe44444\n"), &stdout, &stderr); code != 0 {
+		t.Fatalf("-pack: code = %d, stderr: %s", code, stderr.String())
+	}
+	if want := "a4bc2d5e\nqwe\\45\n"; stdout.String() != want {
+		t.Errorf("-pack: stdout = %q, want %q", stdout.String(), want)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"-ext"}, strings.NewReader("(ab)3\na{2} 2\n"), &stdout, &stderr); code != 0 {
+		t.Fatalf("-ext: code = %d, stderr: %s", code, stderr.String())
+	}
+	if want := "ababab\naa  \n"; stdout.String() != want {
+		t.Errorf("-ext: stdout = %q, want %q", stdout.String(), want)
+	}
+
+	// общий лимит на все строки: вторая строка в него уже не влезает
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"-max", "6"}, strings.NewReader("a4\nb3\n"), &stdout, &stderr); code != 1 {
+		t.Errorf("-max: code = %d, want 1", code)
+	}
+	if stdout.String() != "aaaa\n" || !strings.Contains(stderr.String(), "stdin:2: "+ErrLimitExceeded.Error()) ||
+		!strings.Contains(stderr.String(), "\n    b3\n    ^~\n") {
+		t.Errorf("-max: stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+
+	stderr.Reset()
+	if code := run(nil, strings.NewReader("ab\n5\n"), &stdout, &stderr); code != 1 {
+		t.Errorf("code = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "stdin:2: invalid string") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+
+	for _, args := range [][]string{{"-max", "-1"}, {"-bogus"}} {
+		if code := run(args, strings.NewReader(""), &stdout, &stderr); code != 2 {
+			t.Errorf("%v: code = %d, want 2", args, code)
+		}
+	}
+	if code := run([]string{filepath.Join(dir, "missing")}, nil, &stdout, &stderr); code != 1 {
+		t.Errorf("нет файла: code = %d, want 1", code)
+	}
+}